@@ -4,6 +4,9 @@ go 1.14
 
 require (
 	github.com/blang/semver v3.5.1+incompatible
+	github.com/go-git/go-billy/v5 v5.0.0
+	github.com/go-git/go-git/v5 v5.1.0
+	github.com/golang-jwt/jwt v3.2.2+incompatible
 	github.com/google/go-github/v32 v32.1.0
 	github.com/sethgrid/pester v1.1.0
 	github.com/sirupsen/logrus v1.6.0
@@ -0,0 +1,165 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/coveooss/lure/lib/lure/log"
+	"github.com/coveooss/lure/lib/lure/project"
+	"github.com/coveooss/lure/lib/lure/repositorymanagementsystem"
+	"github.com/coveooss/lure/lib/lure/vcs"
+)
+
+const defaultBackportLabelPrefix = "backport "
+
+func BackportCommand(ctx context.Context, project project.Project, sourceControl sourceControl, repository Repository, args map[string]string) error {
+	labelPrefix := args["labelPrefix"]
+	if labelPrefix == "" {
+		labelPrefix = defaultBackportLabelPrefix
+	}
+
+	return backport(ctx, project, sourceControl, repository, labelPrefix, newPullRequestOptions(project, args))
+}
+
+// backport cherry-picks the pull requests merged into the default branch onto the branches named by their backport labels.
+// Labels are also read from the description lines, for hosts without labels.
+func backport(ctx context.Context, project project.Project, sourceControl sourceControl, repository Repository, labelPrefix string, options repositorymanagementsystem.PullRequestOptions) error {
+	mergedPRs, err := repository.GetMergedPullRequests(ctx, project.Owner, project.Name, project.DefaultBranch)
+	if err != nil {
+		return err
+	}
+
+	existingPRs, err := repository.GetPullRequests(ctx, project.Owner, project.Name, false)
+	if err != nil {
+		return err
+	}
+	mergedBackportPRs := map[string][]repositorymanagementsystem.PullRequest{}
+
+	for _, pr := range mergedPRs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for _, targetBranch := range getBackportTargets(pr, labelPrefix) {
+			if pr.MergeCommit == "" {
+				log.For(ctx).Warnf("PR '%s' has no merge commit, it can't be backported to %s.", pr.Title, targetBranch)
+				continue
+			}
+
+			if _, ok := mergedBackportPRs[targetBranch]; !ok {
+				mergedBackportPRs[targetBranch], err = repository.GetMergedPullRequests(ctx, project.Owner, project.Name, targetBranch)
+				if err != nil {
+					return err
+				}
+			}
+
+			backportBranch := sourceControl.SanitizeBranchName(fmt.Sprintf("lure_backport_%d_into_%s", pr.ID, targetBranch))
+			if hasPullRequestFrom(backportBranch, existingPRs) || hasPullRequestFrom(backportBranch, mergedBackportPRs[targetBranch]) {
+				log.For(ctx).Infof("PR '%s' was already backported to %s.", pr.Title, targetBranch)
+				continue
+			}
+
+			if err := backportPullRequest(ctx, project, sourceControl, repository, pr, targetBranch, backportBranch, options); err != nil {
+				log.For(ctx).Errorf("Could not backport PR '%s' to %s: %s", pr.Title, targetBranch, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func backportPullRequest(ctx context.Context, project project.Project, sourceControl sourceControl, repository Repository, pr repositorymanagementsystem.PullRequest, targetBranch string, backportBranch string, options repositorymanagementsystem.PullRequestOptions) error {
+	log.For(ctx).Infof("Backporting PR '%s' to %s", pr.Title, targetBranch)
+
+	if _, err := sourceControl.Update(ctx, targetBranch); err != nil {
+		return err
+	}
+	if _, err := sourceControl.Branch(ctx, backportBranch); errors.Is(err, vcs.ErrBranchExists) {
+		log.For(ctx).Infof("Branch %s already exists, PR '%s' is being backported to %s", backportBranch, pr.Title, targetBranch)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if _, pickErr := sourceControl.CherryPick(ctx, pr.MergeCommit); pickErr != nil {
+		if !errors.Is(pickErr, vcs.ErrConflict) {
+			sourceControl.AbortCherryPick(ctx)
+			return pickErr
+		}
+		conflicts, err := sourceControl.ConflictedFiles(ctx)
+		if err != nil {
+			return err
+		}
+		if err := sourceControl.AbortCherryPick(ctx); err != nil {
+			return err
+		}
+		if len(conflicts) == 0 {
+			return pickErr
+		}
+		return reportBackportConflicts(ctx, project, repository, pr, targetBranch, conflicts)
+	}
+
+	if os.Getenv("DRY_RUN") == "1" {
+		log.For(ctx).Info("Running in DryRun mode, not doing the pull request nor pushing the changes for ", backportBranch)
+		return nil
+	}
+
+	if _, err := sourceControl.Push(ctx); err != nil {
+		return err
+	}
+
+	title := fmt.Sprintf("[%s] %s", targetBranch, pr.Title)
+	description := fmt.Sprintf("Backport of #%d to %s.", pr.ID, targetBranch)
+	return repository.CreatePullRequest(ctx, backportBranch, targetBranch, project.Owner, project.Name, title, description, options)
+}
+
+// reportBackportConflicts comments the original pull request, once per target branch
+func reportBackportConflicts(ctx context.Context, project project.Project, repository Repository, pr repositorymanagementsystem.PullRequest, targetBranch string, conflicts []string) error {
+	log.For(ctx).Warnf("Backporting PR '%s' to %s conflicts on %d files", pr.Title, targetBranch, len(conflicts))
+
+	marker := fmt.Sprintf("<!-- lure:backport:%s -->", targetBranch)
+	comments, err := repository.GetPullRequestComments(ctx, project.Owner, project.Name, pr.ID)
+	if err != nil {
+		return err
+	}
+	for _, comment := range comments {
+		if strings.Contains(comment, marker) {
+			return nil
+		}
+	}
+
+	comment := fmt.Sprintf("Lure could not backport this pull request to %s, these files conflict:\n\n- %s\n\n%s", targetBranch, strings.Join(conflicts, "\n- "), marker)
+	if os.Getenv("DRY_RUN") == "1" {
+		log.For(ctx).Infof("Running in DryRun mode, not commenting:\n%s", comment)
+		return nil
+	}
+	return repository.CommentPullRequest(ctx, project.Owner, project.Name, pr.ID, comment)
+}
+
+func getBackportTargets(pr repositorymanagementsystem.PullRequest, labelPrefix string) []string {
+	var targets []string
+	lines := append([]string{}, pr.Labels...)
+	lines = append(lines, strings.Split(pr.Description, "\n")...)
+
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, labelPrefix) {
+			target := strings.TrimSpace(strings.TrimPrefix(line, labelPrefix))
+			if target != "" && !contains(targets, target) {
+				targets = append(targets, target)
+			}
+		}
+	}
+	return targets
+}
+
+func hasPullRequestFrom(branch string, pullRequests []repositorymanagementsystem.PullRequest) bool {
+	for _, pr := range pullRequests {
+		if pr.Source.GetName() == branch {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package command_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/coveooss/lure/lib/lure/command"
+	"github.com/coveooss/lure/lib/lure/project"
+	managementsystem "github.com/coveooss/lure/lib/lure/repositorymanagementsystem"
+)
+
+func newBackportRepository() *dummyRepository {
+	return &dummyRepository{
+		MergedPrs: map[string][]managementsystem.PullRequest{
+			"master": []managementsystem.PullRequest{
+				managementsystem.PullRequest{
+					ID:          7,
+					Title:       "Fix the cat feeder",
+					Source:      &dummyBranch{BranchName: "fix/feeder"},
+					Dest:        &dummyBranch{BranchName: "master"},
+					State:       "MERGED",
+					Labels:      []string{"bug", "backport release/2.3"},
+					MergeCommit: "abc123",
+				},
+				managementsystem.PullRequest{
+					ID:          8,
+					Title:       "Add a new feature",
+					Source:      &dummyBranch{BranchName: "feature/new"},
+					Dest:        &dummyBranch{BranchName: "master"},
+					State:       "MERGED",
+					MergeCommit: "def456",
+				},
+			},
+		},
+	}
+}
+
+func TestBackportCommandShouldOpenPRForLabeledPR(t *testing.T) {
+	repository := newBackportRepository()
+
+	useDefaultReviewers := false
+	command.BackportCommand(context.Background(), project.Project{DefaultBranch: "master", UseDefaultReviewers: &useDefaultReviewers}, &dummySourceControl{}, repository, map[string]string{})
+
+	if len(repository.OpenedPullRequestTitles) != 1 || repository.OpenedPullRequestTitles[0] != "[release/2.3] Fix the cat feeder" {
+		t.Logf("Should have opened one backport pull request, opened %q", repository.OpenedPullRequestTitles)
+		t.Fail()
+	}
+}
+
+func TestBackportCommandShouldSkipAlreadyBackportedPR(t *testing.T) {
+	repository := newBackportRepository()
+	repository.MergedPrs["release/2.3"] = []managementsystem.PullRequest{
+		managementsystem.PullRequest{
+			ID:     9,
+			Source: &dummyBranch{BranchName: "lure_backport_7_into_release_2_3"},
+			State:  "MERGED",
+		},
+	}
+
+	useDefaultReviewers := false
+	command.BackportCommand(context.Background(), project.Project{DefaultBranch: "master", UseDefaultReviewers: &useDefaultReviewers}, &dummySourceControl{}, repository, map[string]string{})
+
+	if repository.OpenPullRequestCalled {
+		t.Log("Should not backport a pull request twice")
+		t.Fail()
+	}
+}
+
+func TestBackportCommandShouldCommentOnceOnConflict(t *testing.T) {
+	repository := newBackportRepository()
+	sourceControl := &dummySourceControl{Conflicts: []string{"feeder.go"}}
+
+	useDefaultReviewers := false
+	for i := 0; i < 2; i++ {
+		command.BackportCommand(context.Background(), project.Project{DefaultBranch: "master", UseDefaultReviewers: &useDefaultReviewers}, sourceControl, repository, map[string]string{})
+	}
+
+	if repository.OpenPullRequestCalled || len(repository.Comments) != 1 || !strings.Contains(repository.Comments[0], "- feeder.go") {
+		t.Logf("Should have commented the conflicts once, commented %q", repository.Comments)
+		t.Fail()
+	}
+}
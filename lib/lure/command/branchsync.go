@@ -1,65 +1,277 @@
 package command
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
 
 	"github.com/coveooss/lure/lib/lure/project"
+	"github.com/coveooss/lure/lib/lure/repositorymanagementsystem"
+	"github.com/coveooss/lure/lib/lure/vcs"
 
 	"github.com/coveooss/lure/lib/lure/log"
 )
 
-func SynchronizedBranchesCommand(project project.Project, sourceControl sourceControl, repository Repository, args map[string]string) error {
+func SynchronizedBranchesCommand(ctx context.Context, project project.Project, sourceControl sourceControl, repository Repository, args map[string]string) error {
+	branches, err := getBranchChain(args)
+	if err != nil {
+		return err
+	}
+	waitForUpstream := args["waitForUpstream"] != "false"
+	options := newPullRequestOptions(project, args)
+	ticketRegex := newTicketRegex(args)
+
+	for i := 0; i < len(branches)-1; i++ {
+		toBranch := branches[i+1]
+
+		fromBranches, err := expandBranchGlob(ctx, sourceControl, branches[i])
+		if err != nil {
+			return err
+		}
+
+		upToDate := true
+		for _, fromBranch := range fromBranches {
+			synchronized, err := synchronizedBranches(ctx, project, sourceControl, repository, fromBranch, toBranch, options, ticketRegex)
+			if err != nil {
+				return err
+			}
+			upToDate = upToDate && synchronized
+		}
+
+		if !upToDate && waitForUpstream && i+2 < len(branches) {
+			log.For(ctx).Infof("Waiting for %s to be synchronized before synchronizing it into %s", toBranch, branches[i+2])
+			break
+		}
+	}
+
+	return nil
+}
+
+// getBranchChain returns the ordered branches to synchronize, from the 'branches' list or the 'from' and 'to' pair
+func getBranchChain(args map[string]string) ([]string, error) {
+	if branchList, ok := args["branches"]; ok {
+		branches := splitList(branchList)
+		if len(branches) < 2 {
+			return nil, errors.New("Argument 'branches' must list at least two branches")
+		}
+		return branches, nil
+	}
+
 	fromBranch, ok := args["from"]
 	if !ok {
-		return errors.New("Missing argument 'from'")
+		return nil, errors.New("Missing argument 'from'")
 	}
 	toBranch, ok := args["to"]
 	if !ok {
-		return errors.New("Missing argument 'to'")
+		return nil, errors.New("Missing argument 'to'")
 	}
 
-	return synchronizedBranches(project, sourceControl, repository, fromBranch, toBranch)
+	return []string{fromBranch, toBranch}, nil
 }
 
-func synchronizedBranches(project project.Project, sourceControl sourceControl, repository Repository, fromBranch string, toBranch string) error {
-	if _, err := sourceControl.Update(toBranch); err != nil {
-		return err
+// expandBranchGlob returns the active branches matching a glob like release/*, or the branch itself when it isn't a glob
+func expandBranchGlob(ctx context.Context, sourceControl sourceControl, branch string) ([]string, error) {
+	if !strings.ContainsAny(branch, "*?[") {
+		return []string{branch}, nil
 	}
 
-	if _, err := sourceControl.Update(fromBranch); err != nil {
-		return err
+	activeBranches, err := sourceControl.ActiveBranches(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	commits, err := sourceControl.CommitsBetween(toBranch, fromBranch)
+	var branches []string
+	for _, activeBranch := range activeBranches {
+		if matched, _ := path.Match(branch, activeBranch); matched && !contains(branches, activeBranch) {
+			branches = append(branches, activeBranch)
+		}
+	}
+	sort.Strings(branches)
+
+	if len(branches) == 0 {
+		log.For(ctx).Infof("No branch matches %s", branch)
+	}
+	return branches, nil
+}
+
+// synchronizedBranches proposes to merge fromBranch into toBranch. It returns true when there is nothing left to merge.
+func synchronizedBranches(ctx context.Context, project project.Project, sourceControl sourceControl, repository Repository, fromBranch string, toBranch string, options repositorymanagementsystem.PullRequestOptions, ticketRegex *regexp.Regexp) (bool, error) {
+	if _, err := sourceControl.Update(ctx, toBranch); err != nil {
+		return false, err
+	}
+
+	if _, err := sourceControl.Update(ctx, fromBranch); err != nil {
+		return false, err
+	}
+
+	commits, err := sourceControl.CommitsBetween(ctx, toBranch, fromBranch)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	if len(commits) == 0 {
-		log.Logger.Infof("Branches %s and %s are identical\n", fromBranch, toBranch)
-		return nil
+		log.For(ctx).Infof("Branches %s and %s are identical\n", fromBranch, toBranch)
+		return true, nil
 	}
-	log.Logger.Infof("Found %d commits in %s missing from %s: %s\n", len(commits), fromBranch, toBranch, commits)
+	log.For(ctx).Infof("Found %d commits in %s missing from %s: %s\n", len(commits), fromBranch, toBranch, commits)
 
-	mergeBranch := "lure_merge_" + fromBranch + "_into_" + toBranch + "_" + commits[len(commits)-1]
+	missingCommits, err := sourceControl.Log(ctx, toBranch, fromBranch)
+	if err != nil {
+		return false, err
+	}
+	missingCommits = withoutSyncMerges(missingCommits)
 
-	if _, err := sourceControl.Branch(mergeBranch); err != nil {
-		return err
+	mergeBranchPrefix := sourceControl.SanitizeBranchName("lure_merge_" + fromBranch + "_into_" + toBranch + "_")
+	mergeBranch := sourceControl.SanitizeBranchName(mergeBranchPrefix + commits[len(commits)-1])
+
+	pullRequests, err := repository.GetPullRequests(ctx, project.Owner, project.Name, false)
+	if err != nil {
+		return false, err
+	}
+
+	var syncPR *repositorymanagementsystem.PullRequest
+	for i, pr := range pullRequests {
+		if pr.State == "DECLINED" && pr.Source.GetName() == mergeBranch {
+			log.For(ctx).Infof("The PR merging %s into %s was declined for these commits, skipping.", fromBranch, toBranch)
+			return false, nil
+		}
+		if pr.State == "OPEN" && strings.HasPrefix(pr.Source.GetName(), mergeBranchPrefix) && pr.Dest.GetName() == toBranch {
+			syncPR = &pullRequests[i]
+		}
+	}
+
+	title := fmt.Sprintf("Merge %s into %s", fromBranch, toBranch)
+
+	if syncPR != nil {
+		mergeBranch = syncPR.Source.GetName()
+		log.For(ctx).Infof("Updating the existing PR '%s' from branch %s", syncPR.Title, mergeBranch)
+
+		if _, err := sourceControl.Update(ctx, mergeBranch); err != nil {
+			return false, err
+		}
+		if _, err := sourceControl.Merge(ctx, fromBranch, fmt.Sprintf("Merge %s into %s", fromBranch, mergeBranch)); err != nil {
+			sourceControl.AbortMerge(ctx)
+			return false, err
+		}
+	} else {
+		if _, err := sourceControl.Branch(ctx, mergeBranch); err != nil {
+			return false, err
+		}
+	}
+
+	conflicts, err := mergeDestinationBranch(ctx, sourceControl, toBranch, mergeBranch)
+	if err != nil {
+		return false, err
+	}
+
+	description := describeCommits(fromBranch, toBranch, missingCommits, ticketRegex)
+	if len(conflicts) > 0 {
+		log.For(ctx).Warnf("Merging %s into %s conflicts on %d files", toBranch, mergeBranch, len(conflicts))
+		description += fmt.Sprintf("\nMerging %s into %s conflicts on these files, they must be resolved manually:\n\n- %s\n", toBranch, fromBranch, strings.Join(conflicts, "\n- "))
 	}
 
 	if os.Getenv("DRY_RUN") == "1" {
-		log.Logger.Info("Running in DryRun mode, not doing the pull request nor pushing the changes")
+		log.For(ctx).Info("Running in DryRun mode, not doing the pull request nor pushing the changes")
 	} else {
-		if _, err := sourceControl.Push(); err != nil {
-			return err
+		if _, err := sourceControl.Push(ctx); err != nil {
+			return false, err
 		}
 
-		if err := repository.CreatePullRequest(mergeBranch, toBranch, project.Owner, project.Name, fmt.Sprintf("Merge %s into %s", fromBranch, toBranch), "", *project.UseDefaultReviewers); err != nil {
-			return err
+		if syncPR != nil {
+			return false, repository.UpdatePullRequest(ctx, project.Owner, project.Name, syncPR.ID, title, description)
+		}
+
+		if err := repository.CreatePullRequest(ctx, mergeBranch, toBranch, project.Owner, project.Name, title, description, options); err != nil {
+			return false, err
 		}
 	}
 
-	return nil
+	return false, nil
+}
+
+// lureMergeRegex matches the messages of the merges lure makes in its sync branches
+var lureMergeRegex = regexp.MustCompile(`^Merge \S+ into lure_merge_`)
+
+// newTicketRegex matches the issue numbers referenced by commit messages and the tickets of the projects listed by the
+// 'ticketKeys' argument, like CAT-42 for CAT. Other words like UTF-8 are not tickets.
+func newTicketRegex(args map[string]string) *regexp.Regexp {
+	pattern := `(?:^|\s)#[0-9]+\b`
+	if keys := splitList(args["ticketKeys"]); len(keys) > 0 {
+		for i, key := range keys {
+			keys[i] = regexp.QuoteMeta(key)
+		}
+		pattern = `\b(?:` + strings.Join(keys, "|") + `)-[0-9]+\b|` + pattern
+	}
+	return regexp.MustCompile(pattern)
+}
+
+// withoutSyncMerges drops the merges lure made in earlier sync branches, which are not worth describing
+func withoutSyncMerges(commits []vcs.Commit) []vcs.Commit {
+	var others []vcs.Commit
+	for _, commit := range commits {
+		if lureMergeRegex.MatchString(commit.Message) {
+			continue
+		}
+		others = append(others, commit)
+	}
+	return others
+}
+
+// describeCommits lists the commits to merge with their authors, then the tickets they reference
+func describeCommits(fromBranch string, toBranch string, commits []vcs.Commit, ticketRegex *regexp.Regexp) string {
+	var description strings.Builder
+	if len(commits) == 0 {
+		fmt.Fprintf(&description, "Only merges of earlier syncs of %s are missing from %s.\n", fromBranch, toBranch)
+		return description.String()
+	}
+	fmt.Fprintf(&description, "Commits of %s missing from %s:\n\n", fromBranch, toBranch)
+
+	var tickets []string
+	referenced := map[string]bool{}
+	for _, commit := range commits {
+		fmt.Fprintf(&description, "- %s %s (%s)\n", commit.ShortHash, commit.Subject(), commit.Author)
+
+		for _, match := range ticketRegex.FindAllString(commit.Message, -1) {
+			ticket := strings.TrimSpace(match)
+			if !referenced[ticket] {
+				referenced[ticket] = true
+				tickets = append(tickets, ticket)
+			}
+		}
+	}
+
+	if len(tickets) > 0 {
+		fmt.Fprintf(&description, "\nReferenced tickets: %s\n", strings.Join(tickets, ", "))
+	}
+	return description.String()
+}
+
+// mergeDestinationBranch merges toBranch into the sync branch so conflicts are found locally.
+// On conflict, the merge is aborted and the conflicting files are returned.
+func mergeDestinationBranch(ctx context.Context, sourceControl sourceControl, toBranch string, mergeBranch string) ([]string, error) {
+	_, mergeErr := sourceControl.Merge(ctx, toBranch, fmt.Sprintf("Merge %s into %s", toBranch, mergeBranch))
+	if mergeErr == nil {
+		return nil, nil
+	}
+	if !errors.Is(mergeErr, vcs.ErrConflict) {
+		sourceControl.AbortMerge(ctx)
+		return nil, mergeErr
+	}
+
+	conflicts, err := sourceControl.ConflictedFiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := sourceControl.AbortMerge(ctx); err != nil {
+		return nil, err
+	}
+	if len(conflicts) == 0 {
+		return nil, mergeErr
+	}
+	return conflicts, nil
 }
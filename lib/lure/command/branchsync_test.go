@@ -0,0 +1,180 @@
+package command_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/coveooss/lure/lib/lure/command"
+	"github.com/coveooss/lure/lib/lure/project"
+	managementsystem "github.com/coveooss/lure/lib/lure/repositorymanagementsystem"
+	"github.com/coveooss/lure/lib/lure/vcs"
+)
+
+func TestSynchronizedBranchesCommandShouldOpenPR(t *testing.T) {
+	repository := &dummyRepository{}
+
+	useDefaultReviewers := false
+	err := command.SynchronizedBranchesCommand(context.Background(), project.Project{UseDefaultReviewers: &useDefaultReviewers}, &dummySourceControl{}, repository, map[string]string{"from": "staging", "to": "develop"})
+
+	if err != nil || !repository.OpenPullRequestCalled {
+		t.Logf("Should have opened a pull request: %v", err)
+		t.Fail()
+	}
+}
+
+func TestSynchronizedBranchesCommandShouldUpdateExistingPR(t *testing.T) {
+	existingPrs := []managementsystem.PullRequest{
+		managementsystem.PullRequest{
+			ID:     12,
+			Title:  "Merge staging into develop",
+			Source: &dummyBranch{BranchName: "lure_merge_staging_into_develop_oldcommit"},
+			Dest:   &dummyBranch{BranchName: "develop"},
+			State:  "OPEN",
+		},
+	}
+	repository := &dummyRepository{ExistingPrs: existingPrs}
+
+	useDefaultReviewers := false
+	command.SynchronizedBranchesCommand(context.Background(), project.Project{UseDefaultReviewers: &useDefaultReviewers}, &dummySourceControl{}, repository, map[string]string{"from": "staging", "to": "develop"})
+
+	if repository.OpenPullRequestCalled || repository.UpdatedPullRequestID != 12 {
+		t.Log("Should have updated the existing pull request instead of opening a new one")
+		t.Fail()
+	}
+}
+
+func TestSynchronizedBranchesCommandShouldRespectDeclinedPR(t *testing.T) {
+	existingPrs := []managementsystem.PullRequest{
+		managementsystem.PullRequest{
+			ID:     12,
+			Title:  "Merge staging into develop",
+			Source: &dummyBranch{BranchName: "lure_merge_staging_into_develop_watev"},
+			Dest:   &dummyBranch{BranchName: "develop"},
+			State:  "DECLINED",
+		},
+	}
+	repository := &dummyRepository{ExistingPrs: existingPrs}
+
+	useDefaultReviewers := false
+	command.SynchronizedBranchesCommand(context.Background(), project.Project{UseDefaultReviewers: &useDefaultReviewers}, &dummySourceControl{}, repository, map[string]string{"from": "staging", "to": "develop"})
+
+	if repository.OpenPullRequestCalled {
+		t.Log("Should not open a pull request for declined commits")
+		t.Fail()
+	}
+}
+
+func TestSynchronizedBranchesCommandShouldNameTheMergeBranchAfterTheNewestMissingCommit(t *testing.T) {
+	for declined, expectPR := range map[string]bool{"newest": false, "oldest": true} {
+		existingPrs := []managementsystem.PullRequest{
+			{
+				ID:     12,
+				Title:  "Merge staging into develop",
+				Source: &dummyBranch{BranchName: "lure_merge_staging_into_develop_" + declined},
+				Dest:   &dummyBranch{BranchName: "develop"},
+				State:  "DECLINED",
+			},
+		}
+		repository := &dummyRepository{ExistingPrs: existingPrs}
+
+		useDefaultReviewers := false
+		command.SynchronizedBranchesCommand(context.Background(), project.Project{UseDefaultReviewers: &useDefaultReviewers}, &dummySourceControl{Missing: []string{"oldest", "newest"}}, repository, map[string]string{"from": "staging", "to": "develop"})
+
+		if repository.OpenPullRequestCalled != expectPR {
+			t.Errorf("With the PR of the %s commit declined, expected a new PR to be opened: %t", declined, expectPR)
+		}
+	}
+}
+
+func TestSynchronizedBranchesCommandShouldListConflicts(t *testing.T) {
+	repository := &dummyRepository{}
+
+	useDefaultReviewers := false
+	command.SynchronizedBranchesCommand(context.Background(), project.Project{UseDefaultReviewers: &useDefaultReviewers}, &dummySourceControl{Conflicts: []string{"pom.xml", "src/main.go"}}, repository, map[string]string{"from": "staging", "to": "develop"})
+
+	if !strings.Contains(repository.OpenedPullRequestBody, "- pom.xml\n- src/main.go") {
+		t.Logf("Description should list the conflicting files: %s", repository.OpenedPullRequestBody)
+		t.Fail()
+	}
+}
+
+func TestSynchronizedBranchesCommandShouldWaitForUpstreamSync(t *testing.T) {
+	repository := &dummyRepository{}
+
+	useDefaultReviewers := false
+	command.SynchronizedBranchesCommand(context.Background(), project.Project{UseDefaultReviewers: &useDefaultReviewers}, &dummySourceControl{}, repository, map[string]string{"branches": "staging,develop,master"})
+
+	if len(repository.OpenedPullRequestTitles) != 1 || repository.OpenedPullRequestTitles[0] != "Merge staging into develop" {
+		t.Logf("Should only have synchronized the first pair, opened %q", repository.OpenedPullRequestTitles)
+		t.Fail()
+	}
+}
+
+func TestSynchronizedBranchesCommandShouldSyncEveryPairWhenNotWaiting(t *testing.T) {
+	repository := &dummyRepository{}
+
+	useDefaultReviewers := false
+	sourceControl := &dummySourceControl{Branches: []string{"release/2.3", "release/2.4", "staging", "develop"}}
+	command.SynchronizedBranchesCommand(context.Background(), project.Project{UseDefaultReviewers: &useDefaultReviewers}, sourceControl, repository, map[string]string{"branches": "release/*,staging,develop", "waitForUpstream": "false"})
+
+	expected := []string{"Merge release/2.3 into staging", "Merge release/2.4 into staging", "Merge staging into develop"}
+	if strings.Join(repository.OpenedPullRequestTitles, ",") != strings.Join(expected, ",") {
+		t.Logf("Should have synchronized every pair, opened %q", repository.OpenedPullRequestTitles)
+		t.Fail()
+	}
+}
+
+func TestSynchronizedBranchesCommandShouldDescribeMissingCommits(t *testing.T) {
+	repository := &dummyRepository{}
+	sourceControl := &dummySourceControl{Commits: []vcs.Commit{
+		{ShortHash: "1a2b3c4", Author: "Jane Doe", Email: "jane@example.com", Message: "Fix the feeder schedule\n\nFixes CAT-42 and #7"},
+		{ShortHash: "5d6e7f8", Author: "lure", Email: "lure@example.com", Message: "Merge develop into lure_merge_staging_into_develop_1a2b3c4"},
+		{ShortHash: "9a0b1c2", Author: "lure", Email: "lure@example.com", Message: "Update left-pad to 1.3.0"},
+		{ShortHash: "3d4e5f6", Author: "John Roe", Email: "john@example.com", Message: "Feed the cats twice, see CAT-42\n\nDecode the UTF-8 schedule of PLAT-7"},
+	}}
+
+	err := command.SynchronizedBranchesCommand(context.Background(), project.Project{Author: project.Author{Email: "lure@example.com"}}, sourceControl, repository, map[string]string{"from": "staging", "to": "develop", "ticketKeys": "CAT, PLAT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "Commits of staging missing from develop:\n\n" +
+		"- 1a2b3c4 Fix the feeder schedule (Jane Doe)\n" +
+		"- 9a0b1c2 Update left-pad to 1.3.0 (lure)\n" +
+		"- 3d4e5f6 Feed the cats twice, see CAT-42 (John Roe)\n" +
+		"\nReferenced tickets: CAT-42, #7, PLAT-7\n"
+	if repository.OpenedPullRequestBody != expected {
+		t.Errorf("Unexpected description %q", repository.OpenedPullRequestBody)
+	}
+}
+
+func TestSynchronizedBranchesCommandShouldOnlyReferenceTheTicketsOfTheListedKeys(t *testing.T) {
+	repository := &dummyRepository{}
+	sourceControl := &dummySourceControl{Commits: []vcs.Commit{
+		{ShortHash: "1a2b3c4", Author: "Jane Doe", Email: "jane@example.com", Message: "Hash the feeder with SHA-256\n\nSince 2020-06-01, fixes #7"},
+	}}
+
+	err := command.SynchronizedBranchesCommand(context.Background(), project.Project{}, sourceControl, repository, map[string]string{"from": "staging", "to": "develop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasSuffix(repository.OpenedPullRequestBody, "\nReferenced tickets: #7\n") {
+		t.Errorf("Should only reference #7: %q", repository.OpenedPullRequestBody)
+	}
+}
+
+func TestSynchronizedBranchesCommandShouldSyncTheMergesOfLure(t *testing.T) {
+	repository := &dummyRepository{}
+	sourceControl := &dummySourceControl{Commits: []vcs.Commit{
+		{ShortHash: "5d6e7f8", Author: "lure", Email: "lure@example.com", Message: "Merge staging into lure_merge_staging_into_develop_1a2b3c4"},
+	}}
+
+	err := command.SynchronizedBranchesCommand(context.Background(), project.Project{}, sourceControl, repository, map[string]string{"from": "staging", "to": "develop"})
+
+	expected := "Only merges of earlier syncs of staging are missing from develop.\n"
+	if err != nil || !repository.OpenPullRequestCalled || repository.OpenedPullRequestBody != expected {
+		t.Errorf("Should sync the merges of lure without listing them: %v %q", err, repository.OpenedPullRequestBody)
+	}
+}
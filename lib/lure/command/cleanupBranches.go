@@ -0,0 +1,105 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/coveooss/lure/lib/lure/log"
+	"github.com/coveooss/lure/lib/lure/project"
+	"github.com/coveooss/lure/lib/lure/repositorymanagementsystem"
+)
+
+const defaultCleanupMinimumAge = 24 * time.Hour
+
+type cleanupOptions struct {
+	minimumAge time.Duration
+	allowlist  []string
+	reportOnly bool
+}
+
+// newCleanupOptions reads the minimumAge, allowlist and reportOnly args of cleanupBranches and updateDependencies
+func newCleanupOptions(args map[string]string) (cleanupOptions, error) {
+	options := cleanupOptions{minimumAge: defaultCleanupMinimumAge}
+
+	if minimumAge, ok := args["minimumAge"]; ok {
+		duration, err := time.ParseDuration(minimumAge)
+		if err != nil {
+			return options, fmt.Errorf("Invalid argument 'minimumAge': %s", err)
+		}
+		options.minimumAge = duration
+	}
+	options.allowlist = splitList(args["allowlist"])
+	options.reportOnly = args["reportOnly"] == "true"
+	return options, nil
+}
+
+func CleanupBranchesCommand(ctx context.Context, project project.Project, sourceControl sourceControl, repository Repository, args map[string]string) error {
+	options, err := newCleanupOptions(args)
+	if err != nil {
+		return err
+	}
+	return cleanupBranches(ctx, project, sourceControl, repository, options)
+}
+
+// cleanupBranches closes the lure branches with no open PR, older than the minimum age and not in the allowlist
+func cleanupBranches(ctx context.Context, project project.Project, sourceControl sourceControl, repository Repository, options cleanupOptions) error {
+	log.For(ctx).Info("Cleaning up lure branches with no associated PRs.")
+
+	branches, err := sourceControl.ActiveBranches(ctx)
+	if err != nil {
+		return err
+	}
+	existingPRs, err := repository.GetPullRequests(ctx, project.Owner, project.Name, false)
+	if err != nil {
+		return err
+	}
+
+	var deadBranches []string
+	for _, branch := range branches {
+		// the metadata of pull requests is not trusted, anyone can write it in a description
+		if !strings.HasPrefix(branch, project.BranchPrefix) || !isBranchDead(branch, existingPRs) {
+			continue
+		}
+		if matchesAnyGlob(options.allowlist, branch) {
+			log.For(ctx).Infof("Branch '%s' is in the allowlist, keeping it.", branch)
+			continue
+		}
+
+		if options.minimumAge > 0 {
+			date, err := sourceControl.BranchDate(ctx, branch)
+			if err != nil {
+				log.For(ctx).Errorf("Could not get the date of branch '%s', keeping it: %s", branch, err)
+				continue
+			}
+			if time.Since(date) < options.minimumAge {
+				log.For(ctx).Infof("Branch '%s' is more recent than %s, keeping it.", branch, options.minimumAge)
+				continue
+			}
+		}
+
+		deadBranches = append(deadBranches, branch)
+	}
+
+	if len(deadBranches) > 0 {
+		if options.reportOnly || os.Getenv("DRY_RUN") == "1" {
+			log.For(ctx).Infof("Running in report only mode. Branches would of been closed: %s", strings.Join(deadBranches, ", "))
+		} else if err := sourceControl.CloseBranches(ctx, deadBranches); err != nil {
+			return err
+		}
+	}
+	log.For(ctx).Info("Lure branches clean up done.")
+
+	return nil
+}
+
+func isBranchDead(branch string, existingPRs []repositorymanagementsystem.PullRequest) bool {
+	for _, pr := range existingPRs {
+		if pr.State == "OPEN" && branch == pr.Source.GetName() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,81 @@
+package command_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coveooss/lure/lib/lure/command"
+	"github.com/coveooss/lure/lib/lure/project"
+	managementsystem "github.com/coveooss/lure/lib/lure/repositorymanagementsystem"
+)
+
+func TestCleanupBranchesCommandShouldOnlyCloseOldDeadBranches(t *testing.T) {
+	sourceControl := &dummySourceControl{
+		Branches: []string{"master", "lure-old", "lure-recent", "lure-open", "lure-keep"},
+		BranchDates: map[string]time.Time{
+			"lure-recent": time.Now().Add(-time.Minute),
+		},
+	}
+	repository := &dummyRepository{
+		ExistingPrs: []managementsystem.PullRequest{
+			managementsystem.PullRequest{
+				ID:     1,
+				Source: &dummyBranch{BranchName: "lure-open"},
+				State:  "OPEN",
+			},
+		},
+	}
+
+	command.CleanupBranchesCommand(context.Background(), project.Project{BranchPrefix: "lure-"}, sourceControl, repository, map[string]string{"minimumAge": "24h", "allowlist": "lure-keep*"})
+
+	if strings.Join(sourceControl.ClosedBranches, ",") != "lure-old" {
+		t.Logf("Should only have closed lure-old, closed %q", sourceControl.ClosedBranches)
+		t.Fail()
+	}
+}
+
+func TestCleanupBranchesCommandShouldNotCloseInReportOnlyMode(t *testing.T) {
+	sourceControl := &dummySourceControl{Branches: []string{"lure-old"}}
+
+	command.CleanupBranchesCommand(context.Background(), project.Project{BranchPrefix: "lure-"}, sourceControl, &dummyRepository{}, map[string]string{"reportOnly": "true"})
+
+	if len(sourceControl.ClosedBranches) != 0 {
+		t.Log("Should not close branches in report only mode")
+		t.Fail()
+	}
+}
+
+func TestCleanupBranchesCommandShouldNotTrustTheMetadataOfOtherBranches(t *testing.T) {
+	sourceControl := &dummySourceControl{Branches: []string{"master", "deps-lodash", "feature"}}
+	repository := &dummyRepository{
+		ExistingPrs: []managementsystem.PullRequest{
+			managementsystem.PullRequest{
+				ID:       1,
+				Source:   &dummyBranch{BranchName: "deps-lodash"},
+				State:    "DECLINED",
+				Metadata: &managementsystem.Metadata{Type: "npm", Module: "lodash", To: "4.17.20"},
+			},
+		},
+	}
+
+	command.CleanupBranchesCommand(context.Background(), project.Project{BranchPrefix: "lure-"}, sourceControl, repository, map[string]string{"minimumAge": "0s"})
+
+	if len(sourceControl.ClosedBranches) != 0 {
+		t.Errorf("Should not close a branch of another prefix from the metadata of its PR, closed %q", sourceControl.ClosedBranches)
+	}
+}
+
+func TestCheckForUpdatesJobCommandShouldCleanUpWithItsArgs(t *testing.T) {
+	sourceControl := &dummySourceControl{Branches: []string{"lure-lodash-4_17_21"}}
+
+	err := command.CheckForUpdatesJobCommand(context.Background(), project.Project{BranchPrefix: "lure-", SkipPackageManager: map[string]bool{"mvn": true}}, sourceControl, &dummyRepository{}, map[string]string{"reportOnly": "true"}, &dummyVersionControl{}, &dummyVersionControl{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sourceControl.ClosedBranches) != 0 {
+		t.Errorf("Should only have reported the branches, closed %q", sourceControl.ClosedBranches)
+	}
+}
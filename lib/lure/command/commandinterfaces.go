@@ -1,31 +1,59 @@
 package command
 
 import (
+	"context"
+	"time"
+
 	"github.com/coveooss/lure/lib/lure/project"
 	managementsystem "github.com/coveooss/lure/lib/lure/repositorymanagementsystem"
 	"github.com/coveooss/lure/lib/lure/vcs"
 )
 
 type sourceControl interface {
-	Update(string) (string, error)
-	CommitsBetween(string, string) ([]string, error)
-	Branch(string) (string, error)
-	SoftBranch(string) (string, error)
-	Push() (string, error)
+	Update(context.Context, string) (string, error)
+	Clean(context.Context) error
+	CommitsBetween(context.Context, string, string) ([]string, error)
+	Log(context.Context, string, string) ([]vcs.Commit, error)
+	Branch(context.Context, string) (string, error)
+	SoftBranch(context.Context, string) (string, error)
+	Push(context.Context) (string, error)
 	WorkingPath() string
-	ActiveBranches() ([]string, error)
-	CloseBranch(string) error
+	ActiveBranches(context.Context) ([]string, error)
+	CloseBranch(context.Context, string) error
+	CloseBranches(context.Context, []string) error
+	BranchDate(context.Context, string) (time.Time, error)
 	LocalPath() string
 	SanitizeBranchName(string) string
-	Commit(string) (string, error)
+	Commit(context.Context, string) (string, error)
+	Merge(context.Context, string, string) (string, error)
+	ConflictedFiles(context.Context) ([]string, error)
+	AbortMerge(context.Context) error
+	CherryPick(context.Context, string) (string, error)
+	AbortCherryPick(context.Context) error
+}
+
+// worktrees is implemented by the VCS able to check out the clone in other directories, to update modules in parallel
+type worktrees interface {
+	AddWorktree(context.Context, string) (vcs.SourceControl, error)
+	RemoveWorktree(context.Context, string) error
 }
 
 type Repository interface {
 	GetURL() string
 
-	CreatePullRequest(sourceBranch string, destBranch string, owner string, repo string, title string, description string, useDefaultReviewers bool) error
-	GetPullRequests(string, string, bool) ([]managementsystem.PullRequest, error)
-	DeclinePullRequest(string, string, int) error
+	CreatePullRequest(ctx context.Context, sourceBranch string, destBranch string, owner string, repo string, title string, description string, options managementsystem.PullRequestOptions) error
+	GetPullRequests(context.Context, string, string, bool) ([]managementsystem.PullRequest, error)
+	DeclinePullRequest(context.Context, string, string, int) error
+	UpdatePullRequest(ctx context.Context, owner string, repo string, pullRequestID int, title string, description string) error
+	GetMergedPullRequests(ctx context.Context, owner string, repo string, destBranch string) ([]managementsystem.PullRequest, error)
+	CommentPullRequest(ctx context.Context, owner string, repo string, pullRequestID int, comment string) error
+	GetPullRequestComments(ctx context.Context, owner string, repo string, pullRequestID int) ([]string, error)
+	GetPullRequestStatus(ctx context.Context, owner string, repo string, pullRequest managementsystem.PullRequest) (managementsystem.BuildStatus, error)
+	MergePullRequest(ctx context.Context, owner string, repo string, pullRequestID int) error
+
+	FindIssue(ctx context.Context, owner string, repo string, title string) (*managementsystem.Issue, error)
+	CreateIssue(ctx context.Context, owner string, repo string, title string, body string) error
+	UpdateIssue(ctx context.Context, owner string, repo string, issueID int, body string) error
 }
 
-type Func func(project project.Project, sourceControl vcs.SourceControl, repository Repository, args map[string]string) error
+type Func func(ctx context.Context, project project.Project, sourceControl vcs.SourceControl, repository Repository, args map[string]string) error
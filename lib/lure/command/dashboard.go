@@ -0,0 +1,180 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/coveooss/lure/lib/lure/log"
+	"github.com/coveooss/lure/lib/lure/project"
+	"github.com/coveooss/lure/lib/lure/repositorymanagementsystem"
+)
+
+const defaultDashboardTitle = "Lure Dependency Dashboard"
+
+// checkedRetryRegex matches the declined updates a person asked lure to retry by checking their box
+var checkedRetryRegex = regexp.MustCompile(`(?m)^\s*- \[[xX]\] .*<!-- retry:(\S+) -->`)
+
+func DashboardCommand(ctx context.Context, project project.Project, sourceControl sourceControl, repository Repository, args map[string]string, mvn outdatedGetter, npm outdatedGetter) error {
+	return dashboard(ctx, project, sourceControl, repository, getDashboardTitle(args), mvn, npm)
+}
+
+func getDashboardTitle(args map[string]string) string {
+	if title := args["title"]; title != "" {
+		return title
+	}
+	return defaultDashboardTitle
+}
+
+// getDashboardRetries returns the branch version prefixes of the declined updates checked for retry in the project dashboard.
+// Without a dashboard command in the project, nothing is retried.
+func getDashboardRetries(ctx context.Context, project project.Project, repository Repository) map[string]bool {
+	retries := map[string]bool{}
+
+	for _, cmd := range project.Commands {
+		if cmd.Name != "dashboard" {
+			continue
+		}
+
+		issue, err := repository.FindIssue(ctx, project.Owner, project.Name, getDashboardTitle(cmd.Args))
+		if err != nil {
+			log.For(ctx).Warnf("Could not read the dashboard, declined updates won't be retried: %s", err)
+			return retries
+		}
+		if issue != nil {
+			for _, match := range checkedRetryRegex.FindAllStringSubmatch(issue.Body, -1) {
+				retries[match[1]] = true
+			}
+		}
+	}
+
+	return retries
+}
+
+func dashboard(ctx context.Context, project project.Project, sourceControl sourceControl, repository Repository, title string, mvn outdatedGetter, npm outdatedGetter) error {
+	log.For(ctx).Infof("switching to default branch: %s", project.DefaultBranch)
+	if _, err := sourceControl.Update(ctx, project.DefaultBranch); err != nil {
+		return fmt.Errorf("Error: \"Could not switch to branch %s\" %s", project.DefaultBranch, err)
+	}
+
+	modules, errs := getOutdatedModules(ctx, project, sourceControl, mvn, npm)
+
+	pullRequests, err := repository.GetPullRequests(ctx, project.Owner, project.Name, false)
+	if err != nil {
+		return err
+	}
+	pullRequests = withTrustedMetadata(project, pullRequests)
+
+	issue, err := repository.FindIssue(ctx, project.Owner, project.Name, title)
+	if err != nil {
+		return err
+	}
+	previousRetries := map[string]bool{}
+	if issue != nil {
+		for _, match := range checkedRetryRegex.FindAllStringSubmatch(issue.Body, -1) {
+			previousRetries[match[1]] = true
+		}
+	}
+
+	var pending, open, declined, ignored, failures []string
+
+	for _, pr := range pullRequests {
+		if pr.State == "OPEN" && strings.HasPrefix(pr.Source.GetName(), project.BranchPrefix) {
+			open = append(open, fmt.Sprintf("- #%d %s", pr.ID, pr.Title))
+		}
+	}
+
+	for _, module := range modules {
+		dependencyBranchPrefix, dependencyBranchVersionPrefix := getDependencyBranchPrefixes(module, project, sourceControl)
+
+		var hasOpenPR bool
+		var declinedPR *repositorymanagementsystem.PullRequest
+		for i, pr := range pullRequests {
+			if !isPullRequestForVersion(pr, module, dependencyBranchPrefix, dependencyBranchVersionPrefix) {
+				continue
+			}
+			if pr.State == "OPEN" {
+				hasOpenPR = true
+			} else if declinedPR == nil {
+				declinedPR = &pullRequests[i]
+			}
+		}
+
+		switch {
+		case hasOpenPR:
+		case declinedPR != nil:
+			box := " "
+			if previousRetries[dependencyBranchVersionPrefix] {
+				box = "x"
+			}
+			declined = append(declined, fmt.Sprintf("- [%s] #%d %s <!-- retry:%s -->", box, declinedPR.ID, declinedPR.Title, dependencyBranchVersionPrefix))
+		default:
+			pending = append(pending, fmt.Sprintf("- %s %s %s -> %s", module.Type, getDependencyName(module), module.Current, module.Latest))
+		}
+	}
+
+	for packageManager, skipped := range project.SkipPackageManager {
+		if skipped {
+			ignored = append(ignored, fmt.Sprintf("- %s is configured to be skipped", packageManager))
+		}
+	}
+
+	for packageManager, err := range errs {
+		failures = append(failures, fmt.Sprintf("- %s: %s", packageManager, err))
+	}
+
+	body := renderDashboard([]dashboardSection{
+		{"Pending updates", "", pending},
+		{"Open pull requests", "", open},
+		{"Declined pull requests", "Check a box to have lure open the pull request again on its next run.", declined},
+		{"Ignored", "", ignored},
+		{"Errors", "", failures},
+	})
+
+	if os.Getenv("DRY_RUN") == "1" {
+		log.For(ctx).Infof("Running in DryRun mode, not updating the dashboard:\n%s", body)
+		return nil
+	}
+
+	if issue == nil {
+		log.For(ctx).Infof("Creating dashboard '%s'", title)
+		return repository.CreateIssue(ctx, project.Owner, project.Name, title, body)
+	}
+
+	if issue.Body == body {
+		log.For(ctx).Info("Dashboard is up to date")
+		return nil
+	}
+
+	log.For(ctx).Infof("Updating dashboard '%s'", title)
+	return repository.UpdateIssue(ctx, project.Owner, project.Name, issue.ID, body)
+}
+
+type dashboardSection struct {
+	title       string
+	description string
+	lines       []string
+}
+
+func renderDashboard(sections []dashboardSection) string {
+	var body strings.Builder
+	body.WriteString("This issue lists the dependency updates lure knows about. It is updated on every lure run.\n")
+
+	for _, section := range sections {
+		body.WriteString(fmt.Sprintf("\n## %s\n\n", section.title))
+		if len(section.lines) == 0 {
+			body.WriteString("None\n")
+			continue
+		}
+		if section.description != "" {
+			body.WriteString(section.description + "\n\n")
+		}
+		sort.Strings(section.lines)
+		body.WriteString(strings.Join(section.lines, "\n") + "\n")
+	}
+
+	return body.String()
+}
@@ -0,0 +1,164 @@
+package command
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/coveooss/lure/lib/lure/log"
+	"github.com/coveooss/lure/lib/lure/project"
+	"github.com/coveooss/lure/lib/lure/repositorymanagementsystem"
+	"github.com/coveooss/lure/lib/lure/versionManager"
+)
+
+type autoMergePolicy struct {
+	updateTypes     []string
+	dependencyTypes []string
+	modules         []string
+}
+
+// newAutoMergePolicy reads the autoMerge* args of updateDependencies. Without autoMergeUpdateTypes nothing is auto-merged.
+func newAutoMergePolicy(args map[string]string) autoMergePolicy {
+	return autoMergePolicy{
+		updateTypes:     splitList(args["autoMergeUpdateTypes"]),
+		dependencyTypes: splitList(args["autoMergeDependencyTypes"]),
+		modules:         splitList(args["autoMergeModules"]),
+	}
+}
+
+func (policy autoMergePolicy) matches(module versionManager.ModuleVersion) bool {
+	if len(policy.updateTypes) == 0 {
+		return false
+	}
+	if !contains(policy.updateTypes, module.UpdateType()) {
+		return false
+	}
+	if len(policy.dependencyTypes) > 0 && !contains(policy.dependencyTypes, module.DependencyType) {
+		return false
+	}
+	if len(policy.modules) > 0 && !matchesAnyGlob(policy.modules, module.Module) {
+		return false
+	}
+	return true
+}
+
+// currentAutoMergePolicy returns the policy of the autoMerge* args of mergeReady, or else of the updateDependencies
+// command of the project. A pull request flagged under an older policy is only merged if the current one allows it.
+func currentAutoMergePolicy(project project.Project, args map[string]string) autoMergePolicy {
+	if _, ok := args["autoMergeUpdateTypes"]; ok {
+		return newAutoMergePolicy(args)
+	}
+	for _, cmd := range project.Commands {
+		if cmd.Name == "updateDependencies" {
+			return newAutoMergePolicy(cmd.Args)
+		}
+	}
+	return autoMergePolicy{}
+}
+
+// metadataModule returns the module updated by a pull request, as described by its metadata
+func metadataModule(metadata repositorymanagementsystem.Metadata) versionManager.ModuleVersion {
+	module := versionManager.ModuleVersion{
+		Type:           metadata.Type,
+		Module:         metadata.Module,
+		Current:        metadata.From,
+		Latest:         metadata.To,
+		Wanted:         metadata.To,
+		DependencyType: metadata.DependencyType,
+	}
+	if metadata.PackageModule != "" {
+		module.Module = metadata.PackageModule
+		module.Name = metadata.Module
+	}
+	return module
+}
+
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAnyGlob(globs []string, value string) bool {
+	for _, glob := range globs {
+		if matched, _ := path.Match(glob, value); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func MergeReadyCommand(ctx context.Context, project project.Project, sourceControl sourceControl, repository Repository, args map[string]string) error {
+	return mergeReady(ctx, project, repository, currentAutoMergePolicy(project, args))
+}
+
+// mergeReady merges the open lure pull requests flagged for auto-merge, and still allowed by the policy, once all
+// their checks passed
+func mergeReady(ctx context.Context, project project.Project, repository Repository, policy autoMergePolicy) error {
+	pullRequests, err := repository.GetPullRequests(ctx, project.Owner, project.Name, true)
+	if err != nil {
+		return err
+	}
+
+	for _, pr := range pullRequests {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !isAutoMergeCandidate(project, pr) {
+			continue
+		}
+		if !policy.matches(metadataModule(*pr.Metadata)) {
+			log.For(ctx).Infof("PR '%s' no longer qualifies for auto-merge.", pr.Title)
+			continue
+		}
+
+		status, err := repository.GetPullRequestStatus(ctx, project.Owner, project.Name, pr)
+		if err != nil {
+			log.For(ctx).Errorf("Could not get the status of PR '%s': %s", pr.Title, err)
+			continue
+		}
+		if status != repositorymanagementsystem.BuildSuccessful {
+			log.For(ctx).Infof("PR '%s' is not ready to be merged, checks are %s.", pr.Title, status)
+			continue
+		}
+
+		if os.Getenv("DRY_RUN") == "1" {
+			log.For(ctx).Infof("Running in DryRun mode. PR '%s' would of been merged.", pr.Title)
+			continue
+		}
+
+		log.For(ctx).Infof("Merging PR '%s'.", pr.Title)
+		if err := repository.MergePullRequest(ctx, project.Owner, project.Name, pr.ID); err != nil {
+			log.For(ctx).Errorf("Could not merge PR '%s': %s", pr.Title, err)
+		}
+	}
+
+	return nil
+}
+
+// isAutoMergeCandidate tells whether pr is an open lure pull request flagged for auto-merge. The metadata is only
+// trusted on the branches of lure, anyone can write it in a description or name the branch of a fork like them.
+func isAutoMergeCandidate(project project.Project, pr repositorymanagementsystem.PullRequest) bool {
+	if pr.State != "OPEN" || pr.FromFork || !strings.HasPrefix(pr.Source.GetName(), project.BranchPrefix) {
+		return false
+	}
+	// pull requests flagged before lure recorded their module can't be checked against the current policy
+	if pr.Metadata == nil {
+		return false
+	}
+	return pr.Metadata.AutoMerge
+}
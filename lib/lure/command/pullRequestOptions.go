@@ -0,0 +1,40 @@
+package command
+
+import (
+	"strconv"
+
+	"github.com/coveooss/lure/lib/lure"
+	"github.com/coveooss/lure/lib/lure/project"
+	"github.com/coveooss/lure/lib/lure/repositorymanagementsystem"
+)
+
+// newPullRequestOptions adds the labels and assignees args of the command to the pull request settings of the project.
+// The milestone and draft args override the ones of the project.
+func newPullRequestOptions(project project.Project, args map[string]string) repositorymanagementsystem.PullRequestOptions {
+	options := repositorymanagementsystem.PullRequestOptions{
+		UseDefaultReviewers: project.UseDefaultReviewers == nil || *project.UseDefaultReviewers,
+		Labels:              append(append([]string{}, project.PullRequest.Labels...), splitList(args["labels"])...),
+		Assignees:           append(append([]string{}, project.PullRequest.Assignees...), splitList(args["assignees"])...),
+		Milestone:           project.PullRequest.Milestone,
+		Draft:               project.PullRequest.Draft,
+	}
+	if milestone, ok := args["milestone"]; ok {
+		options.Milestone = milestone
+	}
+	if draft, err := strconv.ParseBool(args["draft"]); err == nil {
+		options.Draft = draft
+	}
+	return options
+}
+
+// withTemplatedLabels renders the labels like the commit message, e.g. to label updates with {{.type}}
+func withTemplatedLabels(options repositorymanagementsystem.PullRequestOptions, data map[string]interface{}) repositorymanagementsystem.PullRequestOptions {
+	labels := make([]string, 0, len(options.Labels))
+	for _, label := range options.Labels {
+		if label = lure.Tprintf(label, data); label != "" {
+			labels = append(labels, label)
+		}
+	}
+	options.Labels = labels
+	return options
+}
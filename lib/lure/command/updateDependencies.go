@@ -1,22 +1,30 @@
 package command
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"reflect"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/coveooss/lure/lib/lure"
 	"github.com/coveooss/lure/lib/lure/log"
 	"github.com/coveooss/lure/lib/lure/project"
 	"github.com/coveooss/lure/lib/lure/repositorymanagementsystem"
+	"github.com/coveooss/lure/lib/lure/vcs"
 	"github.com/coveooss/lure/lib/lure/versionManager"
 
 	"github.com/vsekhar/govtil/guid"
 )
 
 type outdatedGetter interface {
-	GetOutdated(path string) ([]versionManager.ModuleVersion, error)
+	GetOutdated(ctx context.Context, path string) ([]versionManager.ModuleVersion, error)
 }
 
 // This part interesting
@@ -39,199 +47,345 @@ func appendIfMissing(modules []versionManager.ModuleVersion, modulesToAdd []vers
 	return modules
 }
 
-func CheckForUpdatesJobCommand(project project.Project, sourceControl sourceControl, repository Repository, args map[string]string, mvn outdatedGetter, npm outdatedGetter) error {
-	return checkForUpdatesJob(project, sourceControl, repository, args["commitMessage"], args["pullRequestDescription"], mvn, npm)
+func CheckForUpdatesJobCommand(ctx context.Context, project project.Project, sourceControl sourceControl, repository Repository, args map[string]string, mvn outdatedGetter, npm outdatedGetter) error {
+	parallelModules := 1
+	if value, ok := args["parallelModules"]; ok {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			return fmt.Errorf("Invalid argument 'parallelModules': %q is not a positive number", value)
+		}
+		parallelModules = parsed
+	}
+	cleanup, err := newCleanupOptions(args)
+	if err != nil {
+		return err
+	}
+
+	return checkForUpdatesJob(ctx, project, sourceControl, repository, args["commitMessage"], args["pullRequestDescription"], newAutoMergePolicy(args), newPullRequestOptions(project, args), parallelModules, cleanup, mvn, npm)
 }
 
-func checkForUpdatesJob(project project.Project, sourceControl sourceControl, repository Repository, commitMessage string, description string, mvn outdatedGetter, npm outdatedGetter) error {
-	log.Logger.Infof("switching to default branch: %s", project.DefaultBranch)
-	if _, err := sourceControl.Update(project.DefaultBranch); err != nil {
+func checkForUpdatesJob(ctx context.Context, project project.Project, clone sourceControl, repository Repository, commitMessage string, description string, autoMerge autoMergePolicy, pullRequestOptions repositorymanagementsystem.PullRequestOptions, parallelModules int, cleanup cleanupOptions, mvn outdatedGetter, npm outdatedGetter) error {
+	log.For(ctx).Infof("switching to default branch: %s", project.DefaultBranch)
+	if _, err := clone.Update(ctx, project.DefaultBranch); err != nil {
 		return fmt.Errorf("Error: \"Could not switch to branch %s\" %s", project.DefaultBranch, err)
 	}
 
+	modulesToUpdate, errs := getOutdatedModules(ctx, project, clone, mvn, npm)
+
+	if errs["npm"] != nil && errs["mvn"] != nil {
+		return errs["npm"]
+	}
+
+	log.For(ctx).Infof("Modules to update : %q", modulesToUpdate)
+
+	ignoreDeclinedPRs := os.Getenv("IGNORE_DECLINED_PR") == "1"
+	pullRequests, err := repository.GetPullRequests(ctx, project.Owner, project.Name, ignoreDeclinedPRs)
+	if err != nil {
+		return err
+	}
+	pullRequests = withTrustedMetadata(project, pullRequests)
+
+	retries := getDashboardRetries(ctx, project, repository)
+
+	update := func(ctx context.Context, checkout sourceControl, moduleToUpdate versionManager.ModuleVersion) error {
+		return updateModule(ctx, moduleToUpdate, project, checkout, repository, pullRequests, commitMessage, description, autoMerge, pullRequestOptions, retries)
+	}
+	if err := updateModules(ctx, clone, modulesToUpdate, parallelModules, update); err != nil {
+		return err
+	}
+	// the next commands start from a clean checkout as well
+	if err := clone.Clean(ctx); err != nil {
+		return err
+	}
+
+	err = cleanupBranches(ctx, project, clone, repository, cleanup)
+	if err != nil {
+		return err
+	}
+
+	log.For(ctx).Infof("Check for updates done.")
+
+	return nil
+}
+
+// updateModules updates the modules one after the other, or parallelModules at a time in worktrees of the clone when
+// its VCS has them. The first error stops the updates.
+func updateModules(ctx context.Context, clone sourceControl, modules []versionManager.ModuleVersion, parallelModules int, update func(context.Context, sourceControl, versionManager.ModuleVersion) error) error {
+	if parallelModules > len(modules) {
+		parallelModules = len(modules)
+	}
+	repo, ok := clone.(worktrees)
+	if !ok || parallelModules < 2 {
+		for _, moduleToUpdate := range modules {
+			// the failures of a module are skipped, but not the ones of a cancelled run
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := update(ctx, clone, moduleToUpdate); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var copies []vcs.SourceControl
+	for i := 0; i < parallelModules; i++ {
+		dir := fmt.Sprintf("%s-worktree-%d", clone.LocalPath(), i)
+		// removed even when the run was cancelled
+		defer repo.RemoveWorktree(context.Background(), dir)
+		worktree, err := repo.AddWorktree(ctx, dir)
+		if err != nil {
+			return fmt.Errorf("Error: \"Could not add worktree %s\" %w", dir, err)
+		}
+		copies = append(copies, worktree)
+	}
+
+	jobs := make(chan versionManager.ModuleVersion)
+	errs := make(chan error, len(copies))
+	var wg sync.WaitGroup
+	for _, worktree := range copies {
+		wg.Add(1)
+		go func(worktree vcs.SourceControl) {
+			defer wg.Done()
+			for moduleToUpdate := range jobs {
+				if ctx.Err() != nil {
+					continue
+				}
+				if err := update(log.WithPrefix(ctx, moduleToUpdate.Module), worktree, moduleToUpdate); err != nil {
+					errs <- err
+					cancel()
+				}
+			}
+		}(worktree)
+	}
+
+feed:
+	for _, moduleToUpdate := range modules {
+		select {
+		case jobs <- moduleToUpdate:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	close(errs)
+
+	if err := <-errs; err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// getOutdatedModules lists the outdated modules of every package manager not configured to be skipped.
+// Errors are returned by package manager name.
+func getOutdatedModules(ctx context.Context, project project.Project, sourceControl sourceControl, mvn outdatedGetter, npm outdatedGetter) ([]versionManager.ModuleVersion, map[string]error) {
 	modulesToUpdate := make([]versionManager.ModuleVersion, 0, 0)
+	errs := map[string]error{}
 
-	var npmError error
 	if project.SkipPackageManager == nil || project.SkipPackageManager["npm"] != true {
-		var outdatedModule []versionManager.ModuleVersion
-		outdatedModule, npmError = npm.GetOutdated(sourceControl.WorkingPath())
+		outdatedModule, npmError := npm.GetOutdated(ctx, sourceControl.WorkingPath())
 
 		if npmError != nil {
-			log.Logger.Warn("Npm could not get the Outdated dependency, but wasn't configured to be skipped")
+			log.For(ctx).Warn("Npm could not get the Outdated dependency, but wasn't configured to be skipped")
+			errs["npm"] = npmError
 		}
 
 		modulesToUpdate = appendIfMissing(modulesToUpdate, outdatedModule)
 	}
 
-	var mvnError error
 	if project.SkipPackageManager == nil || project.SkipPackageManager["mvn"] != true {
-		var outdatedModule []versionManager.ModuleVersion
-		outdatedModule, mvnError = mvn.GetOutdated(sourceControl.WorkingPath())
+		outdatedModule, mvnError := mvn.GetOutdated(ctx, sourceControl.WorkingPath())
 
 		if mvnError != nil {
-			log.Logger.Warn("Mvn could not get the Outdated dependency, but wasn't configured to be skipped")
+			log.For(ctx).Warn("Mvn could not get the Outdated dependency, but wasn't configured to be skipped")
+			errs["mvn"] = mvnError
 		}
 
 		modulesToUpdate = appendIfMissing(modulesToUpdate, outdatedModule)
 	}
 
-	if npmError != nil && mvnError != nil {
-		if npmError != nil {
-			return npmError
-		} else if mvnError != nil {
-			return mvnError
-		}
+	return modulesToUpdate, errs
+}
+
+func getDependencyName(moduleToUpdate versionManager.ModuleVersion) string {
+	if moduleToUpdate.Name != "" {
+		return moduleToUpdate.Name
 	}
+	return moduleToUpdate.Module
+}
+
+// getDependencyBranchPrefixes returns the branch prefix shared by all the updates of a module and the one of its latest version
+func getDependencyBranchPrefixes(moduleToUpdate versionManager.ModuleVersion, project project.Project, sourceControl sourceControl) (string, string) {
+	branchPrefix := project.BranchPrefix
+	if branchPrefix == "" {
+		branchPrefix = "lure-"
+	}
+	dependencyBranchPrefix := sourceControl.SanitizeBranchName(branchPrefix + getDependencyName(moduleToUpdate))
+	dependencyBranchVersionPrefix := sourceControl.SanitizeBranchName(dependencyBranchPrefix + "-" + moduleToUpdate.Latest)
+	return dependencyBranchPrefix, dependencyBranchVersionPrefix
+}
 
-	log.Logger.Infof("Modules to update : %q", modulesToUpdate)
+// branchGUIDSuffixLen is the length of the "-<guid>" suffix of update branches
+const branchGUIDSuffixLen = 37
 
-	ignoreDeclinedPRs := os.Getenv("IGNORE_DECLINED_PR") == "1"
-	pullRequests, err := repository.GetPullRequests(project.Owner, project.Name, ignoreDeclinedPRs)
-	if err != nil {
-		return err
+// withTrustedMetadata drops the metadata of the pull requests from forks or whose branch lacks the prefix of the
+// project. Anyone can write a metadata block in a description, it only describes the branches of lure.
+func withTrustedMetadata(project project.Project, pullRequests []repositorymanagementsystem.PullRequest) []repositorymanagementsystem.PullRequest {
+	for i, pr := range pullRequests {
+		if pr.Metadata != nil && (pr.FromFork || !strings.HasPrefix(pr.Source.GetName(), project.BranchPrefix)) {
+			pullRequests[i].Metadata = nil
+		}
 	}
+	return pullRequests
+}
 
-	for _, moduleToUpdate := range modulesToUpdate {
-		updateModule(moduleToUpdate, project, sourceControl, repository, pullRequests, commitMessage, description)
+// isPullRequestForModule tells whether pr updates the module to any version.
+// PRs opened before lure recorded its metadata are recognized from their branch name.
+func isPullRequestForModule(pr repositorymanagementsystem.PullRequest, moduleToUpdate versionManager.ModuleVersion, dependencyBranchPrefix string) bool {
+	if pr.Metadata != nil {
+		return pr.Metadata.Type == moduleToUpdate.Type && pr.Metadata.Module == getDependencyName(moduleToUpdate)
 	}
+	return strings.HasPrefix(pr.Source.GetName(), dependencyBranchPrefix+"-")
+}
 
-	err = closeOldBranchesWithoutOpenPR(project, sourceControl, repository)
-	if err != nil {
-		return err
+// isPullRequestForVersion tells whether pr updates the module to its latest version
+func isPullRequestForVersion(pr repositorymanagementsystem.PullRequest, moduleToUpdate versionManager.ModuleVersion, dependencyBranchPrefix string, dependencyBranchVersionPrefix string) bool {
+	if pr.Metadata != nil {
+		return isPullRequestForModule(pr, moduleToUpdate, dependencyBranchPrefix) && pr.Metadata.To == moduleToUpdate.Latest
 	}
 
-	log.Logger.Infof("Check for updates done.")
+	branchName := pr.Source.GetName()
+	if !strings.HasPrefix(branchName, dependencyBranchPrefix) || len(branchName) < branchGUIDSuffixLen {
+		return false
+	}
+	return branchName[:(len(branchName)-branchGUIDSuffixLen)] == dependencyBranchVersionPrefix
+}
 
-	return nil
+// configHash identifies the project configuration a pull request was opened with
+func configHash(project project.Project) string {
+	data, _ := json.Marshal(project)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:12]
 }
 
-func updateModule(moduleToUpdate versionManager.ModuleVersion, project project.Project, sourceControl sourceControl, repository Repository, existingPRs []repositorymanagementsystem.PullRequest, commitMessage string, description string) {
-	var dependencyName string
-	if moduleToUpdate.Name != "" {
-		dependencyName = moduleToUpdate.Name
-	} else {
-		dependencyName = moduleToUpdate.Module
-	}
+// updateModule opens the pull request updating a module. It only returns the errors the next modules would run into,
+// the module being skipped on the others.
+func updateModule(ctx context.Context, moduleToUpdate versionManager.ModuleVersion, project project.Project, sourceControl sourceControl, repository Repository, existingPRs []repositorymanagementsystem.PullRequest, commitMessage string, description string, autoMerge autoMergePolicy, pullRequestOptions repositorymanagementsystem.PullRequestOptions, retries map[string]bool) error {
+	dependencyName := getDependencyName(moduleToUpdate)
 
 	title := fmt.Sprintf("Update %s dependency %s to version %s", moduleToUpdate.Type, dependencyName, moduleToUpdate.Latest)
 
-	branchPrefix := project.BranchPrefix
-	if branchPrefix == "" {
-		branchPrefix = "lure-"
-	}
-	dependencyBranchPrefix := sourceControl.SanitizeBranchName(branchPrefix + dependencyName)
-	dependencyBranchVersionPrefix := sourceControl.SanitizeBranchName(dependencyBranchPrefix + "-" + moduleToUpdate.Latest)
+	dependencyBranchPrefix, dependencyBranchVersionPrefix := getDependencyBranchPrefixes(moduleToUpdate, project, sourceControl)
 	branchGUID, _ := guid.V4()
-	suffixGUIDlen := len(branchGUID.String()) + 1
 	var branch = sourceControl.SanitizeBranchName(dependencyBranchVersionPrefix + "-" + branchGUID.String())
 
 	var openPRAlreadyExists = false
 	var declinedPRAlreadyExists = false
 	for _, pr := range existingPRs {
-		if !openPRAlreadyExists && strings.HasPrefix(pr.Source.GetName(), dependencyBranchPrefix) {
-			previouslyOpennedPrName := pr.Source.GetName()[:(len(pr.Source.GetName()) - suffixGUIDlen)]
-			hasPRForSpecificVersionOpen := previouslyOpennedPrName == dependencyBranchVersionPrefix
-			if hasPRForSpecificVersionOpen {
-				if pr.State == "OPEN" {
-					log.Logger.Infof("There already is an open PR for: '%s'. The branch name is: %s.", title, pr.Source.GetName())
-					openPRAlreadyExists = true
-				} else {
-					log.Logger.Infof("There was a declined PR for: '%s'. The branch name is: %s.", title, pr.Source.GetName())
-					declinedPRAlreadyExists = true
-				}
-				continue
+		if !openPRAlreadyExists && isPullRequestForVersion(pr, moduleToUpdate, dependencyBranchPrefix, dependencyBranchVersionPrefix) {
+			if pr.State == "OPEN" {
+				log.For(ctx).Infof("There already is an open PR for: '%s'. The branch name is: %s.", title, pr.Source.GetName())
+				openPRAlreadyExists = true
+			} else if retries[dependencyBranchVersionPrefix] {
+				log.For(ctx).Infof("There was a declined PR for: '%s', but a retry was requested from the dashboard. The branch name is: %s.", title, pr.Source.GetName())
+			} else {
+				log.For(ctx).Infof("There was a declined PR for: '%s'. The branch name is: %s.", title, pr.Source.GetName())
+				declinedPRAlreadyExists = true
 			}
+			continue
 		}
 
-		if pr.State == "OPEN" && strings.HasPrefix(pr.Source.GetName(), dependencyBranchPrefix) {
+		if pr.State == "OPEN" && isPullRequestForModule(pr, moduleToUpdate, dependencyBranchPrefix) {
 			if os.Getenv("DRY_RUN") == "1" {
-				log.Logger.Infof("Running in DryRun mode. PR '%s' made for older version would be declined.", pr.Title)
+				log.For(ctx).Infof("Running in DryRun mode. PR '%s' made for older version would be declined.", pr.Title)
 			} else {
-				log.Logger.Infof("Declining PR '%s' made for older version.", pr.Title)
-				repository.DeclinePullRequest(project.Owner, project.Name, pr.ID)
+				log.For(ctx).Infof("Declining PR '%s' made for older version.", pr.Title)
+				if err := repository.DeclinePullRequest(ctx, project.Owner, project.Name, pr.ID); err != nil {
+					log.For(ctx).Errorf("\"Could not decline the PR '%s'\" %s", pr.Title, err)
+				}
 			}
 		}
 	}
 	if openPRAlreadyExists || declinedPRAlreadyExists {
-		return
+		return nil
 	}
 
-	log.Logger.Infof("switching %s to default branch: %s", sourceControl.LocalPath(), project.DefaultBranch)
-	if _, err := sourceControl.Update(project.DefaultBranch); err != nil {
-		log.Logger.Fatalf("\"Could not switch to branch %s\" %s", project.DefaultBranch, err)
+	// a branch only holds the change of its module, not what the previous module left when it failed
+	if err := sourceControl.Clean(ctx); err != nil {
+		return fmt.Errorf("Error: \"Could not clean %s\" %w", sourceControl.LocalPath(), err)
+	}
+	log.For(ctx).Infof("switching %s to default branch: %s", sourceControl.LocalPath(), project.DefaultBranch)
+	if _, err := sourceControl.Update(ctx, project.DefaultBranch); err != nil {
+		return fmt.Errorf("Error: \"Could not switch to branch %s\" %w", project.DefaultBranch, err)
 	}
 
-	hasChanges, _ := moduleToUpdate.ModuleUpdater.UpdateDependency(sourceControl.WorkingPath(), moduleToUpdate)
+	hasChanges, updateErr := moduleToUpdate.ModuleUpdater.UpdateDependency(ctx, sourceControl.WorkingPath(), moduleToUpdate)
 
 	if hasChanges == false {
-		log.Logger.Warnf("An update was available for %s but Lure could not update it", dependencyName)
-		return
+		log.For(ctx).Warnf("An update was available for %s but Lure could not update it", dependencyName)
+		return nil
 	}
 
-	log.Logger.Infof("Creating branch %s", branch)
-	if _, err := sourceControl.SoftBranch(branch); err != nil {
-		log.Logger.Errorf("\"Could not create branch\" %s", err)
-		return
+	options := withTemplatedLabels(pullRequestOptions, map[string]interface{}{"module": moduleToUpdate.Module, "version": moduleToUpdate.Latest, "type": moduleToUpdate.Type})
+	if updateErr != nil {
+		log.For(ctx).Warnf("%s was updated but its verification failed, the pull request will be a draft: %s", dependencyName, updateErr)
+		options.Draft = true
 	}
 
-	if _, err := sourceControl.Commit(lure.Tprintf(commitMessage, map[string]interface{}{"module": moduleToUpdate.Module, "version": moduleToUpdate.Latest})); err != nil {
-		log.Logger.Errorf("\"Could not commit\" %s", err)
-		return
+	log.For(ctx).Infof("Creating branch %s", branch)
+	if _, err := sourceControl.SoftBranch(ctx, branch); errors.Is(err, vcs.ErrBranchExists) {
+		log.For(ctx).Warnf("Branch %s already exists, skipping %s", branch, dependencyName)
+		return nil
+	} else if err != nil {
+		log.For(ctx).Errorf("\"Could not create branch\" %s", err)
+		return nil
+	}
+
+	if _, err := sourceControl.Commit(ctx, lure.Tprintf(commitMessage, map[string]interface{}{"module": moduleToUpdate.Module, "version": moduleToUpdate.Latest})); errors.Is(err, vcs.ErrNothingToCommit) {
+		log.For(ctx).Warnf("Updating %s changed no file, skipping it", dependencyName)
+		return nil
+	} else if err != nil {
+		log.For(ctx).Errorf("\"Could not commit\" %s", err)
+		return nil
 	}
 
 	if os.Getenv("DRY_RUN") == "1" {
-		log.Logger.Info("Running in DryRun mode, not doing the pull request nor pushing the changes for ", branch)
+		log.For(ctx).Info("Running in DryRun mode, not doing the pull request nor pushing the changes for ", branch)
 	} else {
-		log.Logger.Info("Pushing changes")
-		if _, err := sourceControl.Push(); err != nil {
-			log.Logger.Fatalf("\"Could not push\" %s", err)
-			return
+		log.For(ctx).Info("Pushing changes")
+		if _, err := sourceControl.Push(ctx); errors.Is(err, vcs.ErrAuth) {
+			return fmt.Errorf("Error: \"Could not push\" %w", err)
+		} else if err != nil {
+			log.For(ctx).Errorf("\"Could not push\" %s", err)
+			return nil
 		}
 
-		log.Logger.Infof("Creating PR")
+		log.For(ctx).Infof("Creating PR")
 
 		description := lure.Tprintf(description, map[string]interface{}{"module": moduleToUpdate.Module, "version": moduleToUpdate.Latest})
-		repository.CreatePullRequest(branch, project.DefaultBranch, project.Owner, project.Name, title, description, *project.UseDefaultReviewers)
-	}
-}
-
-func closeOldBranchesWithoutOpenPR(project project.Project, sourceControl sourceControl, repository Repository) error {
-	log.Logger.Info("Cleaning up lure branches with no associated PRs.")
-
-	branchPrefix := project.BranchPrefix
-	branches, err := sourceControl.ActiveBranches()
-	if err != nil {
-		return err
-	}
-	existingPRs, err := repository.GetPullRequests(project.Owner, project.Name, false)
-	if err != nil {
-		return err
-	}
-
-	for _, branch := range branches {
-		if strings.HasPrefix(branch, branchPrefix) {
-			if isBranchDead(branch, existingPRs) {
-				if os.Getenv("DRY_RUN") == "1" {
-					log.Logger.Infof("Running in DryRun mode. Branch '%s' would of been closed.", branch)
-				} else {
-					if err := sourceControl.CloseBranch(branch); err != nil {
-						println(err)
-						return err
-					}
-				}
-			}
+		metadata := repositorymanagementsystem.Metadata{
+			Type:           moduleToUpdate.Type,
+			Module:         dependencyName,
+			From:           moduleToUpdate.Current,
+			To:             moduleToUpdate.Latest,
+			ConfigHash:     configHash(project),
+			DependencyType: moduleToUpdate.DependencyType,
 		}
-	}
-	log.Logger.Info("Lure branches clean up done.")
-
-	return nil
-}
-
-func isBranchDead(branch string, existingPRs []repositorymanagementsystem.PullRequest) bool {
-	for _, pr := range existingPRs {
-		if pr.State == "OPEN" && branch == pr.Source.GetName() {
-			return false
+		if dependencyName != moduleToUpdate.Module {
+			metadata.PackageModule = moduleToUpdate.Module
+		}
+		if autoMerge.matches(moduleToUpdate) {
+			log.For(ctx).Infof("%s qualifies for auto-merge", title)
+			metadata.AutoMerge = true
+		}
+		description += "\n\n" + repositorymanagementsystem.EncodeMetadata(metadata)
+		if err := repository.CreatePullRequest(ctx, branch, project.DefaultBranch, project.Owner, project.Name, title, description, options); err != nil {
+			log.For(ctx).Errorf("\"Could not create the pull request\" %s", err)
 		}
 	}
-	return true
+	return nil
 }
@@ -1,45 +1,91 @@
 package command_test
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"regexp"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/coveooss/lure/lib/lure/versionManager"
 
 	"github.com/coveooss/lure/lib/lure/command"
 	"github.com/coveooss/lure/lib/lure/project"
 	managementsystem "github.com/coveooss/lure/lib/lure/repositorymanagementsystem"
+	"github.com/coveooss/lure/lib/lure/vcs"
 )
 
 type dummySourceControl struct {
+	CommitError    error
+	Missing        []string
+	Cleans         int
+	PushError      error
+	Pushes         int
+	Commits        []vcs.Commit
+	Conflicts      []string
+	Branches       []string
+	BranchDates    map[string]time.Time
+	ClosedBranches []string
 }
 
-func (d *dummySourceControl) Update(string) (string, error) {
+func (d *dummySourceControl) Update(context.Context, string) (string, error) {
 	return "watev", nil
 }
 
-func (d *dummySourceControl) CommitsBetween(string, string) ([]string, error) {
+func (d *dummySourceControl) Clean(context.Context) error {
+	d.Cleans++
+	return nil
+}
+
+func (d *dummySourceControl) CommitsBetween(context.Context, string, string) ([]string, error) {
+	if d.Missing != nil {
+		return d.Missing, nil
+	}
 	return []string{"watev"}, nil
 }
 
-func (d *dummySourceControl) Branch(string) (string, error) {
-	return "watev", nil
+func (d *dummySourceControl) Log(context.Context, string, string) ([]vcs.Commit, error) {
+	if d.Commits != nil {
+		return d.Commits, nil
+	}
+	return []vcs.Commit{{Hash: "watev", ShortHash: "watev", Message: "watev"}}, nil
 }
-func (d *dummySourceControl) SoftBranch(string) (string, error) {
+
+func (d *dummySourceControl) Branch(context.Context, string) (string, error) {
 	return "watev", nil
 }
-func (d *dummySourceControl) Push() (string, error) {
+func (d *dummySourceControl) SoftBranch(context.Context, string) (string, error) {
 	return "watev", nil
 }
+func (d *dummySourceControl) Push(context.Context) (string, error) {
+	d.Pushes++
+	return "watev", d.PushError
+}
 func (d *dummySourceControl) WorkingPath() string {
 	return "watev"
 }
-func (d *dummySourceControl) ActiveBranches() ([]string, error) {
+func (d *dummySourceControl) ActiveBranches(context.Context) ([]string, error) {
+	if d.Branches != nil {
+		return d.Branches, nil
+	}
 	return []string{"watev"}, nil
 }
-func (d *dummySourceControl) CloseBranch(string) error {
+func (d *dummySourceControl) CloseBranch(context.Context, string) error {
+	return nil
+}
+func (d *dummySourceControl) CloseBranches(ctx context.Context, branches []string) error {
+	d.ClosedBranches = append(d.ClosedBranches, branches...)
 	return nil
 }
+func (d *dummySourceControl) BranchDate(ctx context.Context, branch string) (time.Time, error) {
+	if date, ok := d.BranchDates[branch]; ok {
+		return date, nil
+	}
+	return time.Now().Add(-30 * 24 * time.Hour), nil
+}
 func (d *dummySourceControl) LocalPath() string {
 	return "watev"
 }
@@ -48,24 +94,102 @@ func (d *dummySourceControl) SanitizeBranchName(branchName string) string {
 	safe := reg.ReplaceAllString(branchName, "_")
 	return safe
 }
-func (d *dummySourceControl) Commit(string) (string, error) {
+func (d *dummySourceControl) Commit(context.Context, string) (string, error) {
+	return "watev", d.CommitError
+}
+func (d *dummySourceControl) Merge(context.Context, string, string) (string, error) {
+	if len(d.Conflicts) > 0 {
+		return "", &vcs.Error{Kind: vcs.ErrConflict, Err: errors.New("conflict")}
+	}
+	return "watev", nil
+}
+func (d *dummySourceControl) ConflictedFiles(context.Context) ([]string, error) {
+	return d.Conflicts, nil
+}
+func (d *dummySourceControl) AbortMerge(context.Context) error {
+	return nil
+}
+func (d *dummySourceControl) CherryPick(context.Context, string) (string, error) {
+	if len(d.Conflicts) > 0 {
+		return "", &vcs.Error{Kind: vcs.ErrConflict, Err: errors.New("conflict")}
+	}
+	return "watev", nil
+}
+func (d *dummySourceControl) AbortCherryPick(context.Context) error {
+	return nil
+}
+func (d *dummySourceControl) RemotePath() string {
+	return "watev"
+}
+func (d *dummySourceControl) Cmd(context.Context, ...string) (string, error) {
 	return "watev", nil
 }
+func (d *dummySourceControl) Clone(context.Context) error {
+	return nil
+}
+func (d *dummySourceControl) GetName() string {
+	return "dummy"
+}
+
+// dummyWorktrees hands out a dummySourceControl per worktree
+type dummyWorktrees struct {
+	*dummySourceControl
+	mutex   sync.Mutex
+	Added   map[string]*dummySourceControl
+	Removed []string
+}
+
+func (d *dummyWorktrees) AddWorktree(ctx context.Context, dir string) (vcs.SourceControl, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	worktree := &dummySourceControl{}
+	d.Added[dir] = worktree
+	return worktree, nil
+}
+func (d *dummyWorktrees) RemoveWorktree(ctx context.Context, dir string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.Removed = append(d.Removed, dir)
+	return nil
+}
 
 type dummyRepository struct {
-	ExistingPrs           []managementsystem.PullRequest
-	OpenPullRequestCalled bool
+	mutex                    sync.Mutex
+	ExistingPrs              []managementsystem.PullRequest
+	OpenPullRequestCalled    bool
+	OpenedPullRequestOptions managementsystem.PullRequestOptions
+	OpenedPullRequestBody    string
+	OpenedPullRequestTitles  []string
+	UpdatedPullRequestID     int
+	BuildStatus              managementsystem.BuildStatus
+	MergedPullRequestIDs     []int
+	DeclinedPullRequestIDs   []int
+	Issue                    *managementsystem.Issue
+	MergedPrs                map[string][]managementsystem.PullRequest
+	Comments                 []string
 }
 
-func (d *dummyRepository) CreatePullRequest(sourceBranch string, destBranch string, owner string, repo string, title string, description string, useDefaultReviewers bool) error {
+func (d *dummyRepository) CreatePullRequest(ctx context.Context, sourceBranch string, destBranch string, owner string, repo string, title string, description string, options managementsystem.PullRequestOptions) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
 	d.OpenPullRequestCalled = true
+	d.OpenedPullRequestOptions = options
+	d.OpenedPullRequestTitles = append(d.OpenedPullRequestTitles, title)
+	d.OpenedPullRequestBody = description
 	return nil
 }
-func (d *dummyRepository) GetPullRequests(string, string, bool) ([]managementsystem.PullRequest, error) {
+func (d *dummyRepository) GetPullRequests(context.Context, string, string, bool) ([]managementsystem.PullRequest, error) {
 	return d.ExistingPrs, nil
 }
 
-func (d *dummyRepository) DeclinePullRequest(string, string, int) error {
+func (d *dummyRepository) DeclinePullRequest(ctx context.Context, owner string, repo string, pullRequestID int) error {
+	d.DeclinedPullRequestIDs = append(d.DeclinedPullRequestIDs, pullRequestID)
+	return nil
+}
+
+func (d *dummyRepository) UpdatePullRequest(ctx context.Context, owner string, repo string, pullRequestID int, title string, description string) error {
+	d.UpdatedPullRequestID = pullRequestID
+	d.OpenedPullRequestBody = description
 	return nil
 }
 
@@ -73,19 +197,56 @@ func (d *dummyRepository) GetURL() string {
 	return ""
 }
 
+func (d *dummyRepository) GetPullRequestStatus(context.Context, string, string, managementsystem.PullRequest) (managementsystem.BuildStatus, error) {
+	return d.BuildStatus, nil
+}
+
+func (d *dummyRepository) MergePullRequest(ctx context.Context, owner string, repo string, pullRequestID int) error {
+	d.MergedPullRequestIDs = append(d.MergedPullRequestIDs, pullRequestID)
+	return nil
+}
+
+func (d *dummyRepository) GetMergedPullRequests(ctx context.Context, owner string, repo string, destBranch string) ([]managementsystem.PullRequest, error) {
+	return d.MergedPrs[destBranch], nil
+}
+
+func (d *dummyRepository) CommentPullRequest(ctx context.Context, owner string, repo string, pullRequestID int, comment string) error {
+	d.Comments = append(d.Comments, comment)
+	return nil
+}
+
+func (d *dummyRepository) GetPullRequestComments(ctx context.Context, owner string, repo string, pullRequestID int) ([]string, error) {
+	return d.Comments, nil
+}
+
+func (d *dummyRepository) FindIssue(ctx context.Context, owner string, repo string, title string) (*managementsystem.Issue, error) {
+	return d.Issue, nil
+}
+
+func (d *dummyRepository) CreateIssue(ctx context.Context, owner string, repo string, title string, body string) error {
+	d.Issue = &managementsystem.Issue{ID: 1, Title: title, Body: body}
+	return nil
+}
+
+func (d *dummyRepository) UpdateIssue(ctx context.Context, owner string, repo string, issueID int, body string) error {
+	d.Issue.Body = body
+	return nil
+}
+
 type dummyVersionControl struct {
 	ModuleToReturn       []versionManager.ModuleVersion
 	GetOutdatedError     error
 	GetOutdatedWasCalled bool
+	UpdateError          error
 }
 
-func (d *dummyVersionControl) GetOutdated(path string) ([]versionManager.ModuleVersion, error) {
+func (d *dummyVersionControl) GetOutdated(ctx context.Context, path string) ([]versionManager.ModuleVersion, error) {
 	d.GetOutdatedWasCalled = true
 	return d.ModuleToReturn, d.GetOutdatedError
 }
 
-func (d *dummyVersionControl) UpdateDependency(path string, moduleVersion versionManager.ModuleVersion) (bool, error) {
-	return true, nil
+func (d *dummyVersionControl) UpdateDependency(ctx context.Context, path string, moduleVersion versionManager.ModuleVersion) (bool, error) {
+	return true, d.UpdateError
 }
 
 type dummyBranch struct {
@@ -126,7 +287,7 @@ func TestCheckForUpdatesJobCommandShouldNotOpenPRWhenPRAlreadyExists(t *testing.
 	repository := &dummyRepository{ExistingPrs: existingPrs}
 
 	useDefaultReviewers := false
-	command.CheckForUpdatesJobCommand(project.Project{SkipPackageManager: skipPackageManageConfiguration, UseDefaultReviewers: &useDefaultReviewers}, &dummySourceControl{}, repository, make(map[string]string), mvn, &dummyVersionControl{})
+	command.CheckForUpdatesJobCommand(context.Background(), project.Project{SkipPackageManager: skipPackageManageConfiguration, UseDefaultReviewers: &useDefaultReviewers}, &dummySourceControl{}, repository, make(map[string]string), mvn, &dummyVersionControl{})
 
 	if repository.OpenPullRequestCalled {
 		t.Log("Should not open a pull request")
@@ -165,7 +326,7 @@ func TestCheckForUpdatesJobCommandShouldNotOpenPRWhenDecliendPRExists(t *testing
 	repository := &dummyRepository{ExistingPrs: existingPrs}
 
 	useDefaultReviewers := false
-	command.CheckForUpdatesJobCommand(project.Project{SkipPackageManager: skipPackageManageConfiguration, UseDefaultReviewers: &useDefaultReviewers}, &dummySourceControl{}, repository, make(map[string]string), mvn, &dummyVersionControl{})
+	command.CheckForUpdatesJobCommand(context.Background(), project.Project{SkipPackageManager: skipPackageManageConfiguration, UseDefaultReviewers: &useDefaultReviewers}, &dummySourceControl{}, repository, make(map[string]string), mvn, &dummyVersionControl{})
 
 	if repository.OpenPullRequestCalled {
 		t.Log("Should not open a pull request")
@@ -203,7 +364,7 @@ func TestCheckForUpdatesJobCommandWithDecliendPRWithSamePrefix(t *testing.T) {
 	repository := &dummyRepository{ExistingPrs: existingPrs}
 
 	useDefaultReviewers := false
-	command.CheckForUpdatesJobCommand(project.Project{SkipPackageManager: skipPackageManageConfiguration, UseDefaultReviewers: &useDefaultReviewers}, &dummySourceControl{}, repository, make(map[string]string), mvn, &dummyVersionControl{})
+	command.CheckForUpdatesJobCommand(context.Background(), project.Project{SkipPackageManager: skipPackageManageConfiguration, UseDefaultReviewers: &useDefaultReviewers}, &dummySourceControl{}, repository, make(map[string]string), mvn, &dummyVersionControl{})
 
 	if !repository.OpenPullRequestCalled {
 		t.Log("Should have opened a pull request with the latest version")
@@ -211,6 +372,90 @@ func TestCheckForUpdatesJobCommandWithDecliendPRWithSamePrefix(t *testing.T) {
 	}
 }
 
+func TestCheckForUpdatesJobCommandShouldRecordMetadataInPR(t *testing.T) {
+
+	skipPackageManageConfiguration := make(map[string]bool)
+	skipPackageManageConfiguration["mvn"] = true
+
+	npm := &dummyVersionControl{}
+	npm.ModuleToReturn = []versionManager.ModuleVersion{
+		versionManager.ModuleVersion{
+			ModuleUpdater: npm,
+			Type:          "npm",
+			Module:        "lodash",
+			Current:       "4.17.15",
+			Latest:        "4.17.20",
+			Wanted:        "4.17.20",
+		},
+	}
+
+	repository := &dummyRepository{}
+
+	useDefaultReviewers := false
+	command.CheckForUpdatesJobCommand(context.Background(), project.Project{SkipPackageManager: skipPackageManageConfiguration, UseDefaultReviewers: &useDefaultReviewers}, &dummySourceControl{}, repository, make(map[string]string), &dummyVersionControl{}, npm)
+
+	metadata := managementsystem.ParseMetadata(repository.OpenedPullRequestBody)
+	if metadata == nil || metadata.Type != "npm" || metadata.Module != "lodash" || metadata.From != "4.17.15" || metadata.To != "4.17.20" || metadata.ConfigHash == "" {
+		t.Errorf("Should have recorded the update in the description, got %+v", metadata)
+	}
+}
+
+func TestCheckForUpdatesJobCommandShouldMatchPRsByMetadata(t *testing.T) {
+
+	skipPackageManageConfiguration := make(map[string]bool)
+	skipPackageManageConfiguration["mvn"] = true
+
+	npm := &dummyVersionControl{}
+	npm.ModuleToReturn = []versionManager.ModuleVersion{
+		versionManager.ModuleVersion{
+			ModuleUpdater: npm,
+			Type:          "npm",
+			Module:        "lodash",
+			Current:       "4.17.15",
+			Latest:        "4.17.20",
+			Wanted:        "4.17.20",
+		},
+	}
+
+	existingPrs := []managementsystem.PullRequest{
+		managementsystem.PullRequest{
+			ID:       32,
+			Title:    "Update npm dependency lodash to version 4.17.19",
+			Source:   &dummyBranch{BranchName: "lure-lodash-4_17_19-71334c00-b060-4830-86c0-c7077545712d"},
+			Dest:     &dummyBranch{BranchName: "master"},
+			State:    "OPEN",
+			Metadata: &managementsystem.Metadata{Type: "npm", Module: "lodash", To: "4.17.19"},
+		},
+		managementsystem.PullRequest{
+			ID:       33,
+			Title:    "Update npm dependency lodash.merge to version 4.6.2",
+			Source:   &dummyBranch{BranchName: "lure-lodash-merge-4_6_2-71334c00-b060-4830-86c0-c7077545712d"},
+			Dest:     &dummyBranch{BranchName: "master"},
+			State:    "OPEN",
+			Metadata: &managementsystem.Metadata{Type: "npm", Module: "lodash.merge", To: "4.6.2"},
+		},
+		managementsystem.PullRequest{
+			ID:       34,
+			Title:    "Update npm dependency lodash to version 4.17.20",
+			Source:   &dummyBranch{BranchName: "renamed-branch"},
+			Dest:     &dummyBranch{BranchName: "master"},
+			State:    "DECLINED",
+			Metadata: &managementsystem.Metadata{Type: "npm", Module: "lodash", To: "4.17.20"},
+		},
+	}
+	repository := &dummyRepository{ExistingPrs: existingPrs}
+
+	useDefaultReviewers := false
+	command.CheckForUpdatesJobCommand(context.Background(), project.Project{SkipPackageManager: skipPackageManageConfiguration, UseDefaultReviewers: &useDefaultReviewers}, &dummySourceControl{}, repository, make(map[string]string), &dummyVersionControl{}, npm)
+
+	if repository.OpenPullRequestCalled {
+		t.Error("Should not have opened a pull request for a declined version")
+	}
+	if fmt.Sprint(repository.DeclinedPullRequestIDs) != "[32]" {
+		t.Errorf("Should only have declined the PR of the older lodash version, declined %v", repository.DeclinedPullRequestIDs)
+	}
+}
+
 func TestCheckForUpdatesJobCommandShouldOpenAPRWhenNoPRWasOpenedForThis(t *testing.T) {
 
 	skipPackageManageConfiguration := make(map[string]bool)
@@ -241,7 +486,7 @@ func TestCheckForUpdatesJobCommandShouldOpenAPRWhenNoPRWasOpenedForThis(t *testi
 	repository := &dummyRepository{ExistingPrs: existingPrs}
 
 	useDefaultReviewers := false
-	command.CheckForUpdatesJobCommand(project.Project{SkipPackageManager: skipPackageManageConfiguration, UseDefaultReviewers: &useDefaultReviewers}, &dummySourceControl{}, repository, make(map[string]string), mvn, &dummyVersionControl{})
+	command.CheckForUpdatesJobCommand(context.Background(), project.Project{SkipPackageManager: skipPackageManageConfiguration, UseDefaultReviewers: &useDefaultReviewers}, &dummySourceControl{}, repository, make(map[string]string), mvn, &dummyVersionControl{})
 
 	if !repository.OpenPullRequestCalled {
 		t.Log("Should have opened a pull request with the latest version")
@@ -261,10 +506,468 @@ func TestNpmFailureShouldNotPreventMvnUpdate(t *testing.T) {
 	repository := &dummyRepository{}
 
 	useDefaultReviewers := false
-	command.CheckForUpdatesJobCommand(project.Project{SkipPackageManager: skipPackageManageConfiguration, UseDefaultReviewers: &useDefaultReviewers}, &dummySourceControl{}, repository, make(map[string]string), mvn, npm)
+	command.CheckForUpdatesJobCommand(context.Background(), project.Project{SkipPackageManager: skipPackageManageConfiguration, UseDefaultReviewers: &useDefaultReviewers}, &dummySourceControl{}, repository, make(map[string]string), mvn, npm)
 
 	if !mvn.GetOutdatedWasCalled {
 		t.Log("Should have called GetOutdated for Mvn")
 		t.Fail()
 	}
 }
+
+func TestCheckForUpdatesJobCommandShouldFlagPRForAutoMergeWhenPolicyMatches(t *testing.T) {
+
+	skipPackageManageConfiguration := make(map[string]bool)
+	skipPackageManageConfiguration["mvn"] = true
+
+	npm := &dummyVersionControl{}
+	npm.ModuleToReturn = []versionManager.ModuleVersion{
+		versionManager.ModuleVersion{
+			ModuleUpdater:  npm,
+			Type:           "npm",
+			Module:         "eslint",
+			Current:        "7.1.0",
+			Latest:         "7.1.2",
+			Wanted:         "7.1.2",
+			DependencyType: "devDependencies",
+		},
+	}
+
+	repository := &dummyRepository{}
+
+	args := map[string]string{
+		"autoMergeUpdateTypes":     "patch",
+		"autoMergeDependencyTypes": "devDependencies",
+		"autoMergeModules":         "eslint*",
+	}
+
+	useDefaultReviewers := false
+	command.CheckForUpdatesJobCommand(context.Background(), project.Project{SkipPackageManager: skipPackageManageConfiguration, UseDefaultReviewers: &useDefaultReviewers}, &dummySourceControl{}, repository, args, &dummyVersionControl{}, npm)
+
+	if metadata := managementsystem.ParseMetadata(repository.OpenedPullRequestBody); metadata == nil || !metadata.AutoMerge {
+		t.Log("Should have flagged the pull request for auto-merge")
+		t.Fail()
+	}
+}
+
+func TestCheckForUpdatesJobCommandShouldNotFlagMajorUpdateForAutoMerge(t *testing.T) {
+
+	skipPackageManageConfiguration := make(map[string]bool)
+	skipPackageManageConfiguration["mvn"] = true
+
+	npm := &dummyVersionControl{}
+	npm.ModuleToReturn = []versionManager.ModuleVersion{
+		versionManager.ModuleVersion{
+			ModuleUpdater:  npm,
+			Type:           "npm",
+			Module:         "eslint",
+			Current:        "7.1.0",
+			Latest:         "8.0.0",
+			Wanted:         "8.0.0",
+			DependencyType: "devDependencies",
+		},
+	}
+
+	repository := &dummyRepository{}
+
+	useDefaultReviewers := false
+	command.CheckForUpdatesJobCommand(context.Background(), project.Project{SkipPackageManager: skipPackageManageConfiguration, UseDefaultReviewers: &useDefaultReviewers}, &dummySourceControl{}, repository, map[string]string{"autoMergeUpdateTypes": "patch,minor"}, &dummyVersionControl{}, npm)
+
+	if !repository.OpenPullRequestCalled || managementsystem.ParseMetadata(repository.OpenedPullRequestBody).AutoMerge {
+		t.Log("Should have opened a pull request without flagging it for auto-merge")
+		t.Fail()
+	}
+}
+
+func TestMergeReadyCommandShouldOnlyMergeFlaggedPRsWithSuccessfulChecks(t *testing.T) {
+
+	existingPrs := []managementsystem.PullRequest{
+		managementsystem.PullRequest{
+			ID:          1,
+			Description: "eslint version 7.1.2 is now available!",
+			Metadata:    &managementsystem.Metadata{Type: "npm", Module: "eslint", From: "7.1.1", To: "7.1.2", AutoMerge: true},
+			Source:      &dummyBranch{BranchName: "lure-eslint-7_1_2-71334c00-b060-4830-86c0-c7077545712d"},
+			State:       "OPEN",
+		},
+		managementsystem.PullRequest{
+			ID:          2,
+			Description: "react version 17.0.0 is now available!",
+			Source:      &dummyBranch{BranchName: "lure-react-17_0_0-71334c00-b060-4830-86c0-c7077545712d"},
+			State:       "OPEN",
+		},
+		managementsystem.PullRequest{
+			ID:          3,
+			Description: "<!-- lure:autoMerge -->",
+			Source:      &dummyBranch{BranchName: "lure-lodash-4_17_21-71334c00-b060-4830-86c0-c7077545712d"},
+			State:       "OPEN",
+		},
+	}
+	repository := &dummyRepository{ExistingPrs: existingPrs, BuildStatus: managementsystem.BuildSuccessful}
+
+	command.MergeReadyCommand(context.Background(), project.Project{BranchPrefix: "lure-"}, &dummySourceControl{}, repository, map[string]string{"autoMergeUpdateTypes": "patch"})
+
+	if len(repository.MergedPullRequestIDs) != 1 || repository.MergedPullRequestIDs[0] != 1 {
+		t.Logf("Should have merged only PR 1, merged %v", repository.MergedPullRequestIDs)
+		t.Fail()
+	}
+}
+
+func TestMergeReadyCommandShouldNotTrustTheMetadataOfOtherBranches(t *testing.T) {
+	existingPrs := []managementsystem.PullRequest{
+		{
+			ID:       1,
+			Metadata: &managementsystem.Metadata{Type: "npm", Module: "eslint", From: "7.1.1", To: "7.1.2", AutoMerge: true},
+			Source:   &dummyBranch{BranchName: "feature/forged-metadata"},
+			State:    "OPEN",
+		},
+	}
+	repository := &dummyRepository{ExistingPrs: existingPrs, BuildStatus: managementsystem.BuildSuccessful}
+
+	command.MergeReadyCommand(context.Background(), project.Project{BranchPrefix: "lure-"}, &dummySourceControl{}, repository, map[string]string{"autoMergeUpdateTypes": "patch"})
+
+	if len(repository.MergedPullRequestIDs) != 0 {
+		t.Errorf("Should not merge a branch of another prefix flagged by its metadata, merged %v", repository.MergedPullRequestIDs)
+	}
+}
+
+func TestMergeReadyCommandShouldNotTrustTheMetadataOfForks(t *testing.T) {
+	existingPrs := []managementsystem.PullRequest{
+		{
+			ID:       1,
+			Metadata: &managementsystem.Metadata{Type: "npm", Module: "eslint", From: "7.1.1", To: "7.1.2", AutoMerge: true},
+			Source:   &dummyBranch{BranchName: "lure-eslint-7_1_2-71334c00-b060-4830-86c0-c7077545712d"},
+			State:    "OPEN",
+			FromFork: true,
+		},
+	}
+	repository := &dummyRepository{ExistingPrs: existingPrs, BuildStatus: managementsystem.BuildSuccessful}
+
+	command.MergeReadyCommand(context.Background(), project.Project{BranchPrefix: "lure-"}, &dummySourceControl{}, repository, map[string]string{"autoMergeUpdateTypes": "patch"})
+
+	if len(repository.MergedPullRequestIDs) != 0 {
+		t.Errorf("Should not merge the branch of a fork named like a branch of lure, merged %v", repository.MergedPullRequestIDs)
+	}
+}
+
+func TestMergeReadyCommandShouldNotMergeWhileChecksAreRunning(t *testing.T) {
+
+	existingPrs := []managementsystem.PullRequest{
+		managementsystem.PullRequest{
+			ID:       1,
+			Metadata: &managementsystem.Metadata{Type: "npm", Module: "eslint", From: "7.1.1", To: "7.1.2", AutoMerge: true},
+			Source:   &dummyBranch{BranchName: "lure-eslint-7_1_2-71334c00-b060-4830-86c0-c7077545712d"},
+			State:    "OPEN",
+		},
+	}
+	repository := &dummyRepository{ExistingPrs: existingPrs, BuildStatus: managementsystem.BuildInProgress}
+
+	command.MergeReadyCommand(context.Background(), project.Project{BranchPrefix: "lure-"}, &dummySourceControl{}, repository, map[string]string{"autoMergeUpdateTypes": "patch"})
+
+	if len(repository.MergedPullRequestIDs) != 0 {
+		t.Log("Should not merge a pull request with checks in progress")
+		t.Fail()
+	}
+}
+
+func TestMergeReadyCommandShouldApplyTheCurrentPolicyOfUpdateDependencies(t *testing.T) {
+	existingPrs := []managementsystem.PullRequest{
+		{
+			ID:       1,
+			Metadata: &managementsystem.Metadata{Type: "npm", Module: "eslint", From: "7.1.1", To: "7.2.0", AutoMerge: true, DependencyType: "devDependencies"},
+			Source:   &dummyBranch{BranchName: "lure-eslint-7_2_0-71334c00-b060-4830-86c0-c7077545712d"},
+			State:    "OPEN",
+		},
+		{
+			ID:       2,
+			Metadata: &managementsystem.Metadata{Type: "npm", Module: "jest", From: "26.0.0", To: "26.0.1", AutoMerge: true, DependencyType: "devDependencies"},
+			Source:   &dummyBranch{BranchName: "lure-jest-26_0_1-71334c00-b060-4830-86c0-c7077545712d"},
+			State:    "OPEN",
+		},
+		{
+			ID:       3,
+			Metadata: &managementsystem.Metadata{Type: "npm", Module: "react", From: "17.0.0", To: "17.0.1", AutoMerge: true, DependencyType: "dependencies"},
+			Source:   &dummyBranch{BranchName: "lure-react-17_0_1-71334c00-b060-4830-86c0-c7077545712d"},
+			State:    "OPEN",
+		},
+	}
+	repository := &dummyRepository{ExistingPrs: existingPrs, BuildStatus: managementsystem.BuildSuccessful}
+	commands := []project.Command{{Name: "updateDependencies", Args: map[string]string{"autoMergeUpdateTypes": "patch", "autoMergeDependencyTypes": "devDependencies"}}}
+
+	command.MergeReadyCommand(context.Background(), project.Project{BranchPrefix: "lure-", Commands: commands}, &dummySourceControl{}, repository, map[string]string{})
+
+	if len(repository.MergedPullRequestIDs) != 1 || repository.MergedPullRequestIDs[0] != 2 {
+		t.Errorf("Should have merged only the patch of a devDependency, merged %v", repository.MergedPullRequestIDs)
+	}
+}
+
+func TestCheckForUpdatesJobCommandShouldRetryDeclinedPRCheckedInDashboard(t *testing.T) {
+
+	skipPackageManageConfiguration := make(map[string]bool)
+	skipPackageManageConfiguration["mvn"] = false
+
+	mvn := &dummyVersionControl{}
+	mvn.ModuleToReturn = []versionManager.ModuleVersion{
+		versionManager.ModuleVersion{
+			ModuleUpdater: mvn,
+			Module:        "yolo",
+			Current:       "1.2.1",
+			Latest:        "1.2.3",
+			Wanted:        "1.2.3",
+			Name:          "swag",
+		},
+	}
+
+	existingPrs := []managementsystem.PullRequest{
+		managementsystem.PullRequest{
+			ID:     32,
+			Title:  "Update maven dependency yolo.swag to version 1.2.3",
+			Source: &dummyBranch{BranchName: "lure-swag-1_2_3-71334c00-b060-4830-86c0-c7077545712d"},
+			Dest:   &dummyBranch{BranchName: "irrelevant"},
+			State:  "DECLINED",
+		},
+	}
+	repository := &dummyRepository{
+		ExistingPrs: existingPrs,
+		Issue:       &managementsystem.Issue{ID: 1, Body: "- [x] #32 Update maven dependency yolo.swag to version 1.2.3 <!-- retry:lure-swag-1_2_3 -->"},
+	}
+
+	useDefaultReviewers := false
+	commands := []project.Command{project.Command{Name: "dashboard"}}
+	command.CheckForUpdatesJobCommand(context.Background(), project.Project{SkipPackageManager: skipPackageManageConfiguration, UseDefaultReviewers: &useDefaultReviewers, Commands: commands}, &dummySourceControl{}, repository, make(map[string]string), mvn, &dummyVersionControl{})
+
+	if !repository.OpenPullRequestCalled {
+		t.Log("Should have opened the pull request again")
+		t.Fail()
+	}
+}
+
+func TestDashboardCommandShouldListPendingAndDeclinedUpdates(t *testing.T) {
+
+	skipPackageManageConfiguration := make(map[string]bool)
+	skipPackageManageConfiguration["npm"] = true
+
+	mvn := &dummyVersionControl{}
+	mvn.ModuleToReturn = []versionManager.ModuleVersion{
+		versionManager.ModuleVersion{
+			ModuleUpdater: mvn,
+			Type:          "maven",
+			Module:        "yolo",
+			Current:       "1.2.1",
+			Latest:        "1.2.3",
+			Name:          "swag",
+		},
+		versionManager.ModuleVersion{
+			ModuleUpdater: mvn,
+			Type:          "maven",
+			Module:        "other",
+			Current:       "2.0.0",
+			Latest:        "2.1.0",
+		},
+	}
+
+	existingPrs := []managementsystem.PullRequest{
+		managementsystem.PullRequest{
+			ID:     32,
+			Title:  "Update maven dependency swag to version 1.2.3",
+			Source: &dummyBranch{BranchName: "lure-swag-1_2_3-71334c00-b060-4830-86c0-c7077545712d"},
+			Dest:   &dummyBranch{BranchName: "irrelevant"},
+			State:  "DECLINED",
+		},
+	}
+	repository := &dummyRepository{ExistingPrs: existingPrs}
+
+	command.DashboardCommand(context.Background(), project.Project{BranchPrefix: "lure-", SkipPackageManager: skipPackageManageConfiguration}, &dummySourceControl{}, repository, map[string]string{}, mvn, &dummyVersionControl{})
+
+	if repository.Issue == nil {
+		t.Fatal("Should have created the dashboard issue")
+	}
+	for _, expected := range []string{"- maven other 2.0.0 -> 2.1.0", "- [ ] #32 Update maven dependency swag to version 1.2.3 <!-- retry:lure-swag-1_2_3 -->", "- npm is configured to be skipped"} {
+		if !strings.Contains(repository.Issue.Body, expected) {
+			t.Logf("Dashboard should contain '%s':\n%s", expected, repository.Issue.Body)
+			t.Fail()
+		}
+	}
+}
+
+func TestCheckForUpdatesJobCommandShouldApplyPullRequestOptions(t *testing.T) {
+
+	skipPackageManageConfiguration := make(map[string]bool)
+	skipPackageManageConfiguration["mvn"] = true
+
+	npm := &dummyVersionControl{}
+	npm.ModuleToReturn = []versionManager.ModuleVersion{
+		versionManager.ModuleVersion{
+			ModuleUpdater: npm,
+			Type:          "npm",
+			Module:        "eslint",
+			Current:       "7.1.0",
+			Latest:        "7.1.2",
+			Wanted:        "7.1.2",
+		},
+	}
+
+	repository := &dummyRepository{}
+
+	useDefaultReviewers := true
+	projectConfig := project.Project{
+		SkipPackageManager:  skipPackageManageConfiguration,
+		UseDefaultReviewers: &useDefaultReviewers,
+		PullRequest:         project.PullRequest{Labels: []string{"lure"}, Assignees: []string{"owner"}, Milestone: "Q3"},
+	}
+	args := map[string]string{"labels": "dependencies, {{.type}}", "milestone": "Q4"}
+	command.CheckForUpdatesJobCommand(context.Background(), projectConfig, &dummySourceControl{}, repository, args, &dummyVersionControl{}, npm)
+
+	options := repository.OpenedPullRequestOptions
+	if fmt.Sprint(options.Labels) != "[lure dependencies npm]" || fmt.Sprint(options.Assignees) != "[owner]" || options.Milestone != "Q4" {
+		t.Errorf("Should have merged the options of the project and the command, got %+v", options)
+	}
+	if !options.UseDefaultReviewers || options.Draft {
+		t.Errorf("Unexpected reviewers or draft flag %+v", options)
+	}
+}
+
+func TestCheckForUpdatesJobCommandShouldOpenDraftWhenVerificationFails(t *testing.T) {
+
+	skipPackageManageConfiguration := make(map[string]bool)
+	skipPackageManageConfiguration["mvn"] = true
+
+	npm := &dummyVersionControl{UpdateError: errors.New("npm ci failed")}
+	npm.ModuleToReturn = []versionManager.ModuleVersion{
+		versionManager.ModuleVersion{
+			ModuleUpdater: npm,
+			Type:          "npm",
+			Module:        "eslint",
+			Current:       "7.1.0",
+			Latest:        "7.1.2",
+			Wanted:        "7.1.2",
+		},
+	}
+
+	repository := &dummyRepository{}
+
+	useDefaultReviewers := false
+	command.CheckForUpdatesJobCommand(context.Background(), project.Project{SkipPackageManager: skipPackageManageConfiguration, UseDefaultReviewers: &useDefaultReviewers}, &dummySourceControl{}, repository, make(map[string]string), &dummyVersionControl{}, npm)
+
+	if !repository.OpenPullRequestCalled || !repository.OpenedPullRequestOptions.Draft {
+		t.Log("Should have opened a draft pull request")
+		t.Fail()
+	}
+}
+
+func npmUpdates(modules ...string) *dummyVersionControl {
+	npm := &dummyVersionControl{}
+	for _, module := range modules {
+		npm.ModuleToReturn = append(npm.ModuleToReturn, versionManager.ModuleVersion{
+			ModuleUpdater: npm,
+			Type:          "npm",
+			Module:        module,
+			Current:       "1.0.0",
+			Latest:        "1.0.1",
+			Wanted:        "1.0.1",
+		})
+	}
+	return npm
+}
+
+func TestCheckForUpdatesJobCommandShouldSkipModulesWithNothingToCommit(t *testing.T) {
+	sourceControl := &dummySourceControl{CommitError: &vcs.Error{Kind: vcs.ErrNothingToCommit, Err: errors.New("exit status 1")}}
+	repository := &dummyRepository{}
+
+	err := command.CheckForUpdatesJobCommand(context.Background(), project.Project{SkipPackageManager: map[string]bool{"mvn": true}}, sourceControl, repository, map[string]string{}, &dummyVersionControl{}, npmUpdates("left-pad", "lodash"))
+
+	if err != nil {
+		t.Errorf("Should have skipped the modules, got %s", err)
+	}
+	if sourceControl.Pushes != 0 || repository.OpenPullRequestCalled {
+		t.Error("Should not have pushed nor opened pull requests without changes")
+	}
+}
+
+func TestCheckForUpdatesJobCommandShouldStopOnAuthFailure(t *testing.T) {
+	sourceControl := &dummySourceControl{PushError: &vcs.Error{Kind: vcs.ErrAuth, Err: errors.New("exit status 128")}}
+	repository := &dummyRepository{}
+
+	err := command.CheckForUpdatesJobCommand(context.Background(), project.Project{SkipPackageManager: map[string]bool{"mvn": true}}, sourceControl, repository, map[string]string{}, &dummyVersionControl{}, npmUpdates("left-pad", "lodash"))
+
+	if !errors.Is(err, vcs.ErrAuth) {
+		t.Errorf("Should have returned the authentication failure, got %v", err)
+	}
+	if sourceControl.Pushes != 1 || repository.OpenPullRequestCalled {
+		t.Errorf("Should have stopped at the first push, %d were attempted", sourceControl.Pushes)
+	}
+}
+
+func TestCheckForUpdatesJobCommandShouldGoOnAfterRejectedPush(t *testing.T) {
+	sourceControl := &dummySourceControl{PushError: &vcs.Error{Err: errors.New("exit status 1"), Stderr: "! [rejected] (fetch first)"}}
+	repository := &dummyRepository{}
+
+	err := command.CheckForUpdatesJobCommand(context.Background(), project.Project{SkipPackageManager: map[string]bool{"mvn": true}}, sourceControl, repository, map[string]string{}, &dummyVersionControl{}, npmUpdates("left-pad", "lodash"))
+
+	if err != nil {
+		t.Errorf("Should have gone on with the next modules, got %s", err)
+	}
+	if sourceControl.Pushes != 2 {
+		t.Errorf("Should have pushed both modules, %d were attempted", sourceControl.Pushes)
+	}
+}
+
+func TestCheckForUpdatesJobCommandShouldStopWhenCancelled(t *testing.T) {
+	sourceControl := &dummySourceControl{}
+	repository := &dummyRepository{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := command.CheckForUpdatesJobCommand(ctx, project.Project{SkipPackageManager: map[string]bool{"mvn": true}}, sourceControl, repository, map[string]string{}, &dummyVersionControl{}, npmUpdates("left-pad", "lodash"))
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Should have returned the cancellation, got %v", err)
+	}
+	if sourceControl.Pushes != 0 || repository.OpenPullRequestCalled {
+		t.Errorf("Should not have updated any module, %d pushes were attempted", sourceControl.Pushes)
+	}
+}
+
+func TestCheckForUpdatesJobCommandShouldUpdateModulesInParallelWorktrees(t *testing.T) {
+	sourceControl := &dummyWorktrees{dummySourceControl: &dummySourceControl{}, Added: map[string]*dummySourceControl{}}
+	repository := &dummyRepository{}
+
+	err := command.CheckForUpdatesJobCommand(context.Background(), project.Project{SkipPackageManager: map[string]bool{"mvn": true}}, sourceControl, repository, map[string]string{"parallelModules": "2"}, &dummyVersionControl{}, npmUpdates("left-pad", "lodash", "express"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sourceControl.Added) != 2 || len(sourceControl.Removed) != 2 {
+		t.Errorf("Expected 2 worktrees to be added then removed, got %d and %q", len(sourceControl.Added), sourceControl.Removed)
+	}
+	pushes := 0
+	for _, worktree := range sourceControl.Added {
+		pushes += worktree.Pushes
+	}
+	if pushes != 3 || sourceControl.Pushes != 0 {
+		t.Errorf("Expected the 3 modules to be pushed from the worktrees, got %d and %d from the clone", pushes, sourceControl.Pushes)
+	}
+	if len(repository.OpenedPullRequestTitles) != 3 {
+		t.Errorf("Expected 3 pull requests, got %q", repository.OpenedPullRequestTitles)
+	}
+}
+
+func TestCheckForUpdatesJobCommandShouldRejectInvalidParallelModules(t *testing.T) {
+	err := command.CheckForUpdatesJobCommand(context.Background(), project.Project{}, &dummySourceControl{}, &dummyRepository{}, map[string]string{"parallelModules": "many"}, &dummyVersionControl{}, &dummyVersionControl{})
+
+	if err == nil || !strings.Contains(err.Error(), "parallelModules") {
+		t.Errorf("Should have rejected the argument, got %v", err)
+	}
+}
+
+func TestCheckForUpdatesJobCommandShouldCleanTheCheckoutBeforeEachModule(t *testing.T) {
+	sourceControl := &dummySourceControl{CommitError: errors.New("pom.xml is half written")}
+	repository := &dummyRepository{}
+
+	err := command.CheckForUpdatesJobCommand(context.Background(), project.Project{SkipPackageManager: map[string]bool{"mvn": true}}, sourceControl, repository, map[string]string{}, &dummyVersionControl{}, npmUpdates("left-pad", "lodash"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sourceControl.Cleans != 3 {
+		t.Errorf("Expected a clean before each module and one when done, got %d", sourceControl.Cleans)
+	}
+}
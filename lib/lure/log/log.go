@@ -1,11 +1,56 @@
 package log
 
 import (
+	"context"
+
 	"github.com/sirupsen/logrus"
 )
 
 var Logger *logrus.Logger
 
+const prefixField = "prefix"
+
+type contextKey struct{}
+
 func init() {
 	Logger = logrus.New()
+	Logger.Formatter = prefixFormatter{Logger.Formatter}
+}
+
+// WithPrefix returns ctx with a prefix added to the messages logged through For, like the project or module they
+// are about. Prefixes add up, so the logs of the projects and modules updated in parallel stay readable.
+func WithPrefix(ctx context.Context, prefix string) context.Context {
+	entry := For(ctx)
+	existing, _ := entry.Data[prefixField].(string)
+	return context.WithValue(ctx, contextKey{}, entry.WithField(prefixField, existing+"["+prefix+"] "))
+}
+
+// For returns the logger of ctx, adding its prefix to the messages
+func For(ctx context.Context) *logrus.Entry {
+	if entry, ok := ctx.Value(contextKey{}).(*logrus.Entry); ok {
+		return entry
+	}
+	return logrus.NewEntry(Logger)
+}
+
+// prefixFormatter moves the prefix of an entry from its fields to the start of its message
+type prefixFormatter struct {
+	logrus.Formatter
+}
+
+func (formatter prefixFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	prefix, ok := entry.Data[prefixField].(string)
+	if !ok {
+		return formatter.Formatter.Format(entry)
+	}
+
+	prefixed := *entry
+	prefixed.Message = prefix + entry.Message
+	prefixed.Data = make(logrus.Fields, len(entry.Data))
+	for key, value := range entry.Data {
+		if key != prefixField {
+			prefixed.Data[key] = value
+		}
+	}
+	return formatter.Formatter.Format(&prefixed)
 }
@@ -2,16 +2,50 @@ package os
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
 	"os/exec"
+	"time"
 
 	"github.com/coveooss/lure/lib/lure/log"
 )
 
-func Execute(pwd string, command string, params ...string) (string, error) {
-	log.Logger.Tracef("%s %q", command, params)
+// ExecError is a command that failed. It keeps its outputs, which tell why, and unwraps to the exec error.
+type ExecError struct {
+	Command  string
+	ExitCode int // -1 when the command could not run
+	Stdout   string
+	Stderr   string
+	Err      error
+}
+
+// Error leaves the arguments out, they may hold credentials
+func (e *ExecError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Command, e.Err)
+}
+
+func (e *ExecError) Unwrap() error {
+	return e.Err
+}
+
+// KillDelay is how long a cancelled command is given to exit after being asked to before it is killed
+var KillDelay = 10 * time.Second
+
+func Execute(ctx context.Context, pwd string, command string, params ...string) (string, error) {
+	return ExecuteWithEnv(ctx, pwd, nil, command, params...)
+}
+
+// ExecuteWithEnv runs the command with variables added to the environment, which are not logged as they may hold secrets
+func ExecuteWithEnv(ctx context.Context, pwd string, env []string, command string, params ...string) (string, error) {
+	log.For(ctx).Tracef("%s %q", command, params)
 
 	cmd := exec.Command(command, params...)
 	cmd.Dir = pwd
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
 
 	var buff bytes.Buffer
 	var stderr bytes.Buffer
@@ -19,14 +53,50 @@ func Execute(pwd string, command string, params ...string) (string, error) {
 	cmd.Stdout = &buff
 	cmd.Stderr = &stderr
 
-	if err := cmd.Run(); err != nil {
-		log.Logger.Error(stderr.String())
-		return "", err
+	if err := Run(ctx, cmd); err != nil {
+		log.For(ctx).Error(stderr.String())
+		exitCode := -1
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			exitCode = exitErr.ExitCode()
+		}
+		return "", &ExecError{Command: command, ExitCode: exitCode, Stdout: buff.String(), Stderr: stderr.String(), Err: err}
 	}
 
 	out := buff.String()
 
-	log.Logger.Tracef("\t%s\n", out)
+	log.For(ctx).Tracef("\t%s\n", out)
 
 	return out, nil
 }
+
+// Run runs the command until it exits or ctx is done. A cancelled command and the processes it started
+// are asked to stop, then killed if they are still running after KillDelay.
+func Run(ctx context.Context, cmd *exec.Cmd) error {
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		select {
+		case <-exited:
+			return
+		case <-ctx.Done():
+		}
+		interruptProcessGroup(cmd.Process)
+		select {
+		case <-exited:
+		case <-time.After(KillDelay):
+			killProcessGroup(cmd.Process)
+		}
+	}()
+
+	err := cmd.Wait()
+	close(exited)
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
@@ -0,0 +1,23 @@
+//go:build !windows
+// +build !windows
+
+package os
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts the command in its own process group, so it can be stopped along with its children
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func interruptProcessGroup(process *os.Process) {
+	syscall.Kill(-process.Pid, syscall.SIGTERM)
+}
+
+func killProcessGroup(process *os.Process) {
+	syscall.Kill(-process.Pid, syscall.SIGKILL)
+}
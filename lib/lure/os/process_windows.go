@@ -0,0 +1,15 @@
+package os
+
+import (
+	"os"
+	"os/exec"
+)
+
+// Windows has no process group signals, the command itself is killed right away
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func interruptProcessGroup(process *os.Process) {
+	process.Kill()
+}
+
+func killProcessGroup(process *os.Process) {}
@@ -1,13 +1,57 @@
 package project
 
+// Command is a step of a project. Its timeout is a duration like "30m", the step having no deadline when empty.
 type Command struct {
-	Name string            `json:"name"`
-	Args map[string]string `json:"args"`
+	Name    string            `json:"name"`
+	Args    map[string]string `json:"args"`
+	Timeout string            `json:"timeout,omitempty"`
+}
+
+// PullRequest holds the settings of the pull requests created by every command of a project
+type PullRequest struct {
+	Labels    []string `json:"labels,omitempty"`
+	Assignees []string `json:"assignees,omitempty"`
+	Milestone string   `json:"milestone,omitempty"`
+	Draft     bool     `json:"draft,omitempty"`
+}
+
+// Clone holds the settings of the git clones, to speed up large repositories
+type Clone struct {
+	Depth          int    `json:"depth,omitempty"`
+	Filter         string `json:"filter,omitempty"`
+	SparseCheckout bool   `json:"sparseCheckout,omitempty"`
+}
+
+// Author is the identity of the commits made by lure, the one configured on the host is used when empty
+type Author struct {
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email,omitempty"`
+}
+
+// Signing holds the key signing the commits made by lure
+type Signing struct {
+	Format      string `json:"format,omitempty"`
+	Key         string `json:"key,omitempty"`
+	HgExtension string `json:"hgExtension,omitempty"`
 }
 
 type Project struct {
 	Vcs                 string          `json:"vcs"`
+	GitBackend          string          `json:"gitBackend,omitempty"`
+	HgBranchMode        string          `json:"hgBranchMode,omitempty"`
+	Clone               Clone           `json:"clone,omitempty"`
+	CloneProtocol       string          `json:"cloneProtocol,omitempty"`
+	SSHURL              string          `json:"sshURL,omitempty"`
+	SSHKeyPath          string          `json:"sshKeyPath,omitempty"`
+	KnownHosts          string          `json:"knownHosts,omitempty"`
+	CredentialHelper    bool            `json:"credentialHelper,omitempty"`
+	Author              Author          `json:"author,omitempty"`
+	Signing             Signing         `json:"signing,omitempty"`
 	Host                string          `json:"host,omitempty"`
+	BaseURL             string          `json:"baseURL,omitempty"`
+	APIURL              string          `json:"apiURL,omitempty"`
+	Proxy               string          `json:"proxy,omitempty"`
+	CACertificates      []string        `json:"caCertificates,omitempty"`
 	Owner               string          `json:"owner"`
 	Name                string          `json:"name"`
 	DefaultBranch       string          `json:"defaultBranch"`
@@ -16,6 +60,9 @@ type Project struct {
 	BasePath            string          `json:"basePath"`
 	SkipPackageManager  map[string]bool `json:"skipPackageManager"`
 	UseDefaultReviewers *bool           `json:"useDefaultReviewers"`
+	ReviewerTeams       []string        `json:"reviewerTeams,omitempty"`
+	PullRequest         PullRequest     `json:"pullRequest,omitempty"`
+	Timeout             string          `json:"timeout,omitempty"`
 	Commands            []Command       `json:"commands"`
 }
 
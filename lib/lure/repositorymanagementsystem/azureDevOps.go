@@ -0,0 +1,495 @@
+package repositorymanagementsystem
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/coveooss/lure/lib/lure/log"
+	"github.com/coveooss/lure/lib/lure/project"
+	"github.com/coveooss/lure/lib/lure/vcs"
+)
+
+const (
+	defaultAzureDevOpsURL     = "https://dev.azure.com"
+	azureDevOpsAPIVersion     = "6.0"
+	azureDevOpsPageSize       = 100
+	requiredReviewersPolicyID = "fd2167ab-b0be-447a-8ec8-39368250530e"
+)
+
+// AzureDevOps is the provider for Azure DevOps Repos. The owner is the organization/project pair.
+type AzureDevOps struct {
+	URL            string
+	baseURL        string
+	authentication vcs.Authentication
+	transport      http.RoundTripper
+}
+
+type azureDevOpsReviewer struct {
+	ID         string `json:"id"`
+	UniqueName string `json:"uniqueName,omitempty"`
+	IsRequired bool   `json:"isRequired,omitempty"`
+}
+
+type azureDevOpsCommit struct {
+	CommitID string `json:"commitId"`
+}
+
+type azureDevOpsPullRequest struct {
+	PullRequestID         int                   `json:"pullRequestId,omitempty"`
+	Title                 string                `json:"title"`
+	Description           string                `json:"description"`
+	Status                string                `json:"status,omitempty"`
+	SourceRefName         string                `json:"sourceRefName"`
+	TargetRefName         string                `json:"targetRefName"`
+	Reviewers             []azureDevOpsReviewer `json:"reviewers"`
+	LastMergeSourceCommit *azureDevOpsCommit    `json:"lastMergeSourceCommit,omitempty"`
+	LastMergeCommit       *azureDevOpsCommit    `json:"lastMergeCommit,omitempty"`
+	Labels                []struct {
+		Name string `json:"name"`
+	} `json:"labels,omitempty"`
+}
+
+type azureDevOpsPolicy struct {
+	IsEnabled bool `json:"isEnabled"`
+	Type      struct {
+		ID string `json:"id"`
+	} `json:"type"`
+	Settings struct {
+		RequiredReviewerIds []string `json:"requiredReviewerIds"`
+		Scope               []struct {
+			RepositoryID string `json:"repositoryId"`
+			RefName      string `json:"refName"`
+			MatchKind    string `json:"matchKind"`
+		} `json:"scope"`
+	} `json:"settings"`
+}
+
+var errAzureDevOpsIssues = errors.New("Azure DevOps issues are work items, which are not supported")
+
+func NewAzureDevOps(authentication vcs.Authentication, project project.Project) (AzureDevOps, error) {
+	transport, err := NewTransport(project)
+	if err != nil {
+		return AzureDevOps{}, err
+	}
+
+	baseURL := strings.TrimSuffix(project.BaseURL, "/")
+	if baseURL == "" {
+		baseURL = defaultAzureDevOpsURL
+	}
+
+	return AzureDevOps{
+		URL:            baseURL + "/" + azureDevOpsOwnerPath(project.Owner) + "/_git/" + url.PathEscape(project.Name),
+		baseURL:        baseURL,
+		authentication: authentication,
+		transport:      transport,
+	}, nil
+}
+
+// azureDevOpsOwnerPath escapes the organization and the project, which can contain spaces
+func azureDevOpsOwnerPath(owner string) string {
+	parts := strings.SplitN(owner, "/", 2)
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+func (azure AzureDevOps) GetURL() string {
+	return azure.URL
+}
+
+func (azure AzureDevOps) repositoryPath(owner string, repo string) string {
+	return "/" + azureDevOpsOwnerPath(owner) + "/_apis/git/repositories/" + url.PathEscape(repo)
+}
+
+func (azure AzureDevOps) pullRequestPath(owner string, repo string, pullRequestID int) string {
+	return fmt.Sprintf("%s/pullrequests/%d", azure.repositoryPath(owner, repo), pullRequestID)
+}
+
+func (azure AzureDevOps) GetPullRequests(ctx context.Context, owner string, repo string, ignoreDeclinedPRs bool) ([]PullRequest, error) {
+	log.For(ctx).Info("Retrieving pull requests")
+
+	statuses := []string{"active"}
+	if !ignoreDeclinedPRs {
+		statuses = append(statuses, "abandoned")
+	}
+
+	var pullRequests []PullRequest
+	for _, status := range statuses {
+		azurePRs, err := azure.listPullRequests(ctx, owner, repo, url.Values{"searchCriteria.status": {status}})
+		if err != nil {
+			log.For(ctx).Error("Error getting PR Requests")
+			return nil, err
+		}
+		for _, azurePR := range azurePRs {
+			pullRequests = append(pullRequests, azurePR.toPullRequest())
+		}
+	}
+	log.For(ctx).Infof("Found %d PRs.", len(pullRequests))
+
+	return pullRequests, nil
+}
+
+// GetMergedPullRequests returns the most recently completed pull requests into destBranch
+func (azure AzureDevOps) GetMergedPullRequests(ctx context.Context, owner string, repo string, destBranch string) ([]PullRequest, error) {
+	query := url.Values{
+		"searchCriteria.status":        {"completed"},
+		"searchCriteria.targetRefName": {"refs/heads/" + destBranch},
+		"$top":                         {fmt.Sprint(azureDevOpsPageSize)},
+	}
+
+	var response struct {
+		Value []azureDevOpsPullRequest `json:"value"`
+	}
+	if err := azure.sendApiRequest(ctx, "GET", azure.repositoryPath(owner, repo)+"/pullrequests", query, nil, &response); err != nil {
+		log.For(ctx).Error("Error getting merged PR Requests")
+		return nil, err
+	}
+
+	var pullRequests []PullRequest
+	for _, azurePR := range response.Value {
+		pullRequests = append(pullRequests, azurePR.toPullRequest())
+	}
+	return pullRequests, nil
+}
+
+// listPullRequests skips through the results until a page is not full
+func (azure AzureDevOps) listPullRequests(ctx context.Context, owner string, repo string, query url.Values) ([]azureDevOpsPullRequest, error) {
+	query.Set("$top", fmt.Sprint(azureDevOpsPageSize))
+
+	var pullRequests []azureDevOpsPullRequest
+	for skip := 0; ; skip += azureDevOpsPageSize {
+		query.Set("$skip", fmt.Sprint(skip))
+
+		var response struct {
+			Value []azureDevOpsPullRequest `json:"value"`
+		}
+		if err := azure.sendApiRequest(ctx, "GET", azure.repositoryPath(owner, repo)+"/pullrequests", query, nil, &response); err != nil {
+			return nil, err
+		}
+		pullRequests = append(pullRequests, response.Value...)
+
+		if len(response.Value) < azureDevOpsPageSize {
+			return pullRequests, nil
+		}
+	}
+}
+
+func (azurePR azureDevOpsPullRequest) toPullRequest() PullRequest {
+	var state string
+	switch azurePR.Status {
+	case "completed":
+		state = "MERGED"
+	case "abandoned":
+		state = "DECLINED"
+	default:
+		state = "OPEN"
+	}
+
+	var reviewers []user
+	for _, reviewer := range azurePR.Reviewers {
+		reviewers = append(reviewers, user{reviewer.UniqueName})
+	}
+
+	var labels []string
+	for _, label := range azurePR.Labels {
+		labels = append(labels, label.Name)
+	}
+
+	var mergeCommit string
+	if azurePR.LastMergeCommit != nil {
+		mergeCommit = azurePR.LastMergeCommit.CommitID
+	}
+
+	return PullRequest{
+		ID:          azurePR.PullRequestID,
+		Title:       azurePR.Title,
+		Description: azurePR.Description,
+		Metadata:    ParseMetadata(azurePR.Description),
+		Source: &source{
+			Branch: branch{
+				Name: strings.TrimPrefix(azurePR.SourceRefName, "refs/heads/"),
+			},
+		},
+		Dest: &dest{
+			Branch: branch{
+				Name: strings.TrimPrefix(azurePR.TargetRefName, "refs/heads/"),
+			},
+		},
+		CloseSourceBranch: true,
+		State:             state,
+		Reviewers:         reviewers,
+		Labels:            labels,
+		MergeCommit:       mergeCommit,
+	}
+}
+
+func (azure AzureDevOps) getPullRequest(ctx context.Context, owner string, repo string, pullRequestID int) (*azureDevOpsPullRequest, error) {
+	var azurePR azureDevOpsPullRequest
+	if err := azure.sendApiRequest(ctx, "GET", azure.pullRequestPath(owner, repo, pullRequestID), nil, nil, &azurePR); err != nil {
+		return nil, err
+	}
+	return &azurePR, nil
+}
+
+func (azure AzureDevOps) CreatePullRequest(ctx context.Context, sourceBranch string, destBranch string, owner string, repo string, title string, description string, options PullRequestOptions) error {
+	azurePR := azureDevOpsPullRequest{
+		Title:         title,
+		Description:   description,
+		SourceRefName: "refs/heads/" + sourceBranch,
+		TargetRefName: "refs/heads/" + destBranch,
+		Reviewers:     []azureDevOpsReviewer{},
+	}
+
+	if options.UseDefaultReviewers {
+		reviewerIDs, err := azure.getRequiredReviewers(ctx, owner, repo, azurePR.TargetRefName)
+		if err != nil {
+			log.For(ctx).Warnf("Could not get required reviewers: %s", err)
+		}
+		for _, reviewerID := range reviewerIDs {
+			azurePR.Reviewers = append(azurePR.Reviewers, azureDevOpsReviewer{ID: reviewerID, IsRequired: true})
+		}
+	}
+
+	var created azureDevOpsPullRequest
+	if err := azure.sendApiRequest(ctx, "POST", azure.repositoryPath(owner, repo)+"/pullrequests", nil, &azurePR, &created); err != nil {
+		log.For(ctx).Error("Error creating PR Request")
+		return err
+	}
+
+	log.For(ctx).Infof("Created Pull Request %d", created.PullRequestID)
+
+	return nil
+}
+
+// getRequiredReviewers returns the reviewers required by the enabled branch policies applying to targetRef
+func (azure AzureDevOps) getRequiredReviewers(ctx context.Context, owner string, repo string, targetRef string) ([]string, error) {
+	var repository struct {
+		ID string `json:"id"`
+	}
+	if err := azure.sendApiRequest(ctx, "GET", azure.repositoryPath(owner, repo), nil, nil, &repository); err != nil {
+		return nil, err
+	}
+
+	// the policies are paged, each page telling the continuation token of the next one
+	var policies []azureDevOpsPolicy
+	query := url.Values{}
+	for {
+		var page struct {
+			Value []azureDevOpsPolicy `json:"value"`
+		}
+		header, err := azure.sendRequest(ctx, "GET", "/"+azureDevOpsOwnerPath(owner)+"/_apis/policy/configurations", query, nil, &page)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, page.Value...)
+
+		continuationToken := header.Get("x-ms-continuationtoken")
+		if continuationToken == "" {
+			break
+		}
+		query.Set("continuationToken", continuationToken)
+	}
+
+	var reviewerIDs []string
+	seen := map[string]bool{}
+	for _, policy := range policies {
+		if !policy.IsEnabled || policy.Type.ID != requiredReviewersPolicyID {
+			continue
+		}
+		for _, scope := range policy.Settings.Scope {
+			if scope.RepositoryID != "" && !strings.EqualFold(scope.RepositoryID, repository.ID) {
+				continue
+			}
+			if scope.RefName != "" && scope.RefName != targetRef && !(scope.MatchKind == "prefix" && strings.HasPrefix(targetRef, scope.RefName)) {
+				continue
+			}
+			for _, reviewerID := range policy.Settings.RequiredReviewerIds {
+				if !seen[reviewerID] {
+					seen[reviewerID] = true
+					reviewerIDs = append(reviewerIDs, reviewerID)
+				}
+			}
+			break
+		}
+	}
+	return reviewerIDs, nil
+}
+
+// DeclinePullRequest abandons the pull request
+func (azure AzureDevOps) DeclinePullRequest(ctx context.Context, owner string, repo string, pullRequestID int) error {
+	body := map[string]interface{}{"status": "abandoned"}
+
+	if err := azure.sendApiRequest(ctx, "PATCH", azure.pullRequestPath(owner, repo, pullRequestID), nil, body, nil); err != nil {
+		log.For(ctx).Error("Error declining PR Request")
+		return err
+	}
+
+	log.For(ctx).Infof("Declined PR number %d", pullRequestID)
+
+	return nil
+}
+
+func (azure AzureDevOps) UpdatePullRequest(ctx context.Context, owner string, repo string, pullRequestID int, title string, description string) error {
+	body := map[string]interface{}{"title": title, "description": description}
+
+	if err := azure.sendApiRequest(ctx, "PATCH", azure.pullRequestPath(owner, repo, pullRequestID), nil, body, nil); err != nil {
+		log.For(ctx).Error("Error updating PR Request")
+		return err
+	}
+	return nil
+}
+
+// MergePullRequest completes the pull request at its current source commit
+func (azure AzureDevOps) MergePullRequest(ctx context.Context, owner string, repo string, pullRequestID int) error {
+	azurePR, err := azure.getPullRequest(ctx, owner, repo, pullRequestID)
+	if err != nil {
+		return err
+	}
+
+	body := map[string]interface{}{
+		"status":                "completed",
+		"lastMergeSourceCommit": azurePR.LastMergeSourceCommit,
+	}
+	if err := azure.sendApiRequest(ctx, "PATCH", azure.pullRequestPath(owner, repo, pullRequestID), nil, body, nil); err != nil {
+		log.For(ctx).Error("Error merging PR Request")
+		return err
+	}
+
+	log.For(ctx).Infof("Merged PR number %d", pullRequestID)
+
+	return nil
+}
+
+// CommentPullRequest opens a new thread holding the comment
+func (azure AzureDevOps) CommentPullRequest(ctx context.Context, owner string, repo string, pullRequestID int, comment string) error {
+	body := map[string]interface{}{
+		"comments": []map[string]interface{}{{"content": comment, "commentType": "text"}},
+		"status":   "active",
+	}
+
+	if err := azure.sendApiRequest(ctx, "POST", azure.pullRequestPath(owner, repo, pullRequestID)+"/threads", nil, body, nil); err != nil {
+		log.For(ctx).Error("Error commenting PR Request")
+		return err
+	}
+	return nil
+}
+
+func (azure AzureDevOps) GetPullRequestComments(ctx context.Context, owner string, repo string, pullRequestID int) ([]string, error) {
+	var threads struct {
+		Value []struct {
+			Comments []struct {
+				Content     string `json:"content"`
+				CommentType string `json:"commentType"`
+			} `json:"comments"`
+		} `json:"value"`
+	}
+	if err := azure.sendApiRequest(ctx, "GET", azure.pullRequestPath(owner, repo, pullRequestID)+"/threads", nil, nil, &threads); err != nil {
+		log.For(ctx).Error("Error getting PR Request comments")
+		return nil, err
+	}
+
+	var comments []string
+	for _, thread := range threads.Value {
+		for _, comment := range thread.Comments {
+			if comment.CommentType == "text" {
+				comments = append(comments, comment.Content)
+			}
+		}
+	}
+	return comments, nil
+}
+
+func (azure AzureDevOps) GetPullRequestStatus(ctx context.Context, owner string, repo string, pullRequest PullRequest) (BuildStatus, error) {
+	var azureStatuses struct {
+		Value []struct {
+			State string `json:"state"`
+		} `json:"value"`
+	}
+	if err := azure.sendApiRequest(ctx, "GET", azure.pullRequestPath(owner, repo, pullRequest.ID)+"/statuses", nil, nil, &azureStatuses); err != nil {
+		log.For(ctx).Error("Error getting PR statuses")
+		return BuildNone, err
+	}
+
+	var statuses []BuildStatus
+	for _, status := range azureStatuses.Value {
+		switch status.State {
+		case "succeeded":
+			statuses = append(statuses, BuildSuccessful)
+		case "pending":
+			statuses = append(statuses, BuildInProgress)
+		case "failed", "error":
+			statuses = append(statuses, BuildFailed)
+		}
+	}
+	return aggregateBuildStatus(statuses), nil
+}
+
+func (azure AzureDevOps) FindIssue(ctx context.Context, owner string, repo string, title string) (*Issue, error) {
+	return nil, errAzureDevOpsIssues
+}
+
+func (azure AzureDevOps) CreateIssue(ctx context.Context, owner string, repo string, title string, body string) error {
+	return errAzureDevOpsIssues
+}
+
+func (azure AzureDevOps) UpdateIssue(ctx context.Context, owner string, repo string, issueID int, body string) error {
+	return errAzureDevOpsIssues
+}
+
+// sendApiRequest sends body encoded as json and decodes the response into result when it is not nil
+func (azure AzureDevOps) sendApiRequest(ctx context.Context, method string, path string, query url.Values, body interface{}, result interface{}) error {
+	_, err := azure.sendRequest(ctx, method, path, query, body, result)
+	return err
+}
+
+// sendRequest sends an API request like sendApiRequest and returns the headers of the response
+func (azure AzureDevOps) sendRequest(ctx context.Context, method string, path string, query url.Values, body interface{}, result interface{}) (http.Header, error) {
+	var reader io.Reader
+	if body != nil {
+		buf := &bytes.Buffer{}
+		if err := json.NewEncoder(buf).Encode(body); err != nil {
+			return nil, err
+		}
+		reader = buf
+	}
+
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("api-version", azureDevOpsAPIVersion)
+
+	request, err := http.NewRequestWithContext(ctx, method, azure.baseURL+path+"?"+query.Encode(), reader)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Add("Content-Type", "application/json")
+	azure.authentication.AuthenticateHTTPRequest(request.Header)
+
+	client := getHTTPClient(azure.transport)
+	resp, err := client.Do(request)
+	if err != nil {
+		log.For(ctx).Error(client.LogString())
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	log.For(ctx).Tracef("%s '%s' returned %d.", method, path, resp.StatusCode)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%s %s returned status code %s", method, path, resp.Status)
+	}
+
+	if result != nil {
+		return resp.Header, json.NewDecoder(resp.Body).Decode(result)
+	}
+	return resp.Header, nil
+}
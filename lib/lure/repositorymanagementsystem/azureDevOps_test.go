@@ -0,0 +1,121 @@
+package repositorymanagementsystem_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/coveooss/lure/lib/lure/project"
+	managementsystem "github.com/coveooss/lure/lib/lure/repositorymanagementsystem"
+	"github.com/coveooss/lure/lib/lure/vcs"
+)
+
+const azureDevOpsRepositoryPath = "/coveo/Cat Feeder/_apis/git/repositories/catfeeder"
+
+type fakeAzureDevOps struct {
+	*fakeAPI
+}
+
+func newFakeAzureDevOps(t *testing.T) *fakeAzureDevOps {
+	return &fakeAzureDevOps{newFakeAPI(t, "", func(w http.ResponseWriter, r *http.Request, route string) {
+		if r.URL.Query().Get("api-version") != "6.0" {
+			t.Errorf("Should send the api version, got %s", r.URL.RawQuery)
+		}
+
+		switch route {
+		case "GET " + azureDevOpsRepositoryPath + "/pullrequests":
+			switch r.URL.Query().Get("searchCriteria.status") {
+			case "active":
+				fmt.Fprint(w, `{"value": [{"pullRequestId": 1, "status": "active", "sourceRefName": "refs/heads/lure-a", "targetRefName": "refs/heads/master", "reviewers": [{"id": "r1", "uniqueName": "reviewer@coveo.com"}]}]}`)
+			case "abandoned":
+				fmt.Fprint(w, `{"value": [{"pullRequestId": 2, "status": "abandoned", "sourceRefName": "refs/heads/lure-b", "targetRefName": "refs/heads/master"}]}`)
+			default:
+				t.Errorf("Unexpected query %s", r.URL.RawQuery)
+			}
+		case "GET " + azureDevOpsRepositoryPath:
+			fmt.Fprint(w, `{"id": "REPO-ID"}`)
+		case "GET /coveo/Cat Feeder/_apis/policy/configurations":
+			switch r.URL.Query().Get("continuationToken") {
+			case "":
+				w.Header().Set("x-ms-continuationtoken", "2")
+				fmt.Fprint(w, `{"value": [
+					{"isEnabled": true, "type": {"id": "fd2167ab-b0be-447a-8ec8-39368250530e"}, "settings": {"requiredReviewerIds": ["r1"], "scope": [{"repositoryId": "repo-id", "refName": "refs/heads/master", "matchKind": "exact"}]}},
+					{"isEnabled": true, "type": {"id": "fd2167ab-b0be-447a-8ec8-39368250530e"}, "settings": {"requiredReviewerIds": ["r3"], "scope": [{"repositoryId": "other", "refName": "refs/heads/master", "matchKind": "exact"}]}}
+				]}`)
+			case "2":
+				fmt.Fprint(w, `{"value": [
+					{"isEnabled": true, "type": {"id": "fd2167ab-b0be-447a-8ec8-39368250530e"}, "settings": {"requiredReviewerIds": ["r1", "r2"], "scope": [{"repositoryId": null, "refName": "refs/heads/", "matchKind": "prefix"}]}},
+					{"isEnabled": false, "type": {"id": "fd2167ab-b0be-447a-8ec8-39368250530e"}, "settings": {"requiredReviewerIds": ["r4"], "scope": [{"refName": "refs/heads/master", "matchKind": "exact"}]}},
+					{"isEnabled": true, "type": {"id": "fa4e907d-c16b-4a4c-9dfa-4906e5d171dd"}, "settings": {"scope": [{"refName": "refs/heads/master", "matchKind": "exact"}]}}
+				]}`)
+			default:
+				t.Errorf("Unexpected continuation token %s", r.URL.RawQuery)
+			}
+		case "POST " + azureDevOpsRepositoryPath + "/pullrequests":
+			fmt.Fprint(w, `{"pullRequestId": 12}`)
+		default:
+			fmt.Fprint(w, `{}`)
+		}
+	})}
+}
+
+func (fake *fakeAzureDevOps) newAzureDevOps(t *testing.T) managementsystem.AzureDevOps {
+	azure, err := managementsystem.NewAzureDevOps(vcs.PersonalAccessTokenAuth{Token: "secret"}, project.Project{BaseURL: fake.server.URL, Owner: "coveo/Cat Feeder", Name: "catfeeder"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return azure
+}
+
+func TestAzureDevOpsGetURLUsesOrganizationAndProject(t *testing.T) {
+	azure, err := managementsystem.NewAzureDevOps(vcs.PersonalAccessTokenAuth{}, project.Project{Owner: "coveo/Cat Feeder", Name: "catfeeder"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if azure.GetURL() != "https://dev.azure.com/coveo/Cat%20Feeder/_git/catfeeder" {
+		t.Errorf("Unexpected URL %s", azure.GetURL())
+	}
+}
+
+func TestAzureDevOpsGetPullRequestsMapsAbandonedToDeclined(t *testing.T) {
+	fake := newFakeAzureDevOps(t)
+	defer fake.server.Close()
+
+	prs, err := fake.newAzureDevOps(t).GetPullRequests(context.Background(), "coveo/Cat Feeder", "catfeeder", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(prs) != 2 {
+		t.Fatalf("Should have returned the active and abandoned pull requests, got %d", len(prs))
+	}
+	if prs[0].ID != 1 || prs[0].State != "OPEN" || prs[0].Source.GetName() != "lure-a" || prs[0].Dest.GetName() != "master" {
+		t.Errorf("Unexpected first pull request %+v", prs[0])
+	}
+	if prs[1].State != "DECLINED" {
+		t.Errorf("Abandoned pull request should be declined %+v", prs[1])
+	}
+	if fake.token(0) != "Basic OnNlY3JldA==" {
+		t.Errorf("Should authenticate with the personal access token, got '%s'", fake.token(0))
+	}
+}
+
+func TestAzureDevOpsCreatePullRequestWithRequiredReviewersOfEveryPage(t *testing.T) {
+	fake := newFakeAzureDevOps(t)
+	defer fake.server.Close()
+
+	err := fake.newAzureDevOps(t).CreatePullRequest(context.Background(), "lure-a", "master", "coveo/Cat Feeder", "catfeeder", "Update a", "description", managementsystem.PullRequestOptions{UseDefaultReviewers: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := fake.received["POST "+azureDevOpsRepositoryPath+"/pullrequests"]
+	if body["sourceRefName"] != "refs/heads/lure-a" || body["targetRefName"] != "refs/heads/master" || body["title"] != "Update a" {
+		t.Errorf("Unexpected pull request %v", body)
+	}
+	if fmt.Sprint(body["reviewers"]) != "[map[id:r1 isRequired:true] map[id:r2 isRequired:true]]" {
+		t.Errorf("Should request the reviewers of the matching policies of both pages once, got %v", body["reviewers"])
+	}
+}
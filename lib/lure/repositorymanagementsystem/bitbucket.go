@@ -2,6 +2,7 @@ package repositorymanagementsystem
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -22,6 +23,10 @@ type BitBucket struct {
 	URL            string
 	apiURL         string
 	authentication vcs.Authentication
+	transport      http.RoundTripper
+	// wikiCloneOptions and wikiCommitOptions are used for the dashboard page of the wiki
+	wikiCloneOptions  vcs.CloneOptions
+	wikiCommitOptions vcs.CommitOptions
 }
 
 type pullRequestList struct {
@@ -53,32 +58,59 @@ type user struct {
 	Uuid string `json:"uuid"`
 }
 
+type commit struct {
+	Hash string `json:"hash"`
+}
+
 type pullRequest struct {
-	ID                int    `json:"id"`
-	Title             string `json:"title"`
-	Description       string `json:"description"`
-	Source            source `json:"source"`
-	Dest              source `json:"destination"`
-	CloseSourceBranch bool   `json:"close_source_branch"`
-	State             string `json:"state"`
-	Reviewers         []user `json:"reviewers"`
+	ID                int     `json:"id"`
+	Title             string  `json:"title"`
+	Description       string  `json:"description"`
+	Source            source  `json:"source"`
+	Dest              source  `json:"destination"`
+	CloseSourceBranch bool    `json:"close_source_branch"`
+	State             string  `json:"state"`
+	Reviewers         []user  `json:"reviewers"`
+	MergeCommit       *commit `json:"merge_commit"`
 }
 
-func NewBitbucket(authentication vcs.Authentication, project project.Project) BitBucket {
+const defaultBitbucketAPIURL = "https://api.bitbucket.org/2.0"
+
+func NewBitbucket(authentication vcs.Authentication, project project.Project) (BitBucket, error) {
+	transport, err := NewTransport(project)
+	if err != nil {
+		return BitBucket{}, err
+	}
+
+	apiURL := strings.TrimSuffix(project.APIURL, "/")
+	if apiURL == "" {
+		apiURL = defaultBitbucketAPIURL
+	}
+
 	return BitBucket{
 		URL:            "https://bitbucket.org/" + project.Owner + "/" + project.Name,
-		apiURL:         "https://api.bitbucket.org/2.0/repositories",
+		apiURL:         apiURL + "/repositories",
 		authentication: authentication,
-	}
+		transport:      transport,
+		wikiCloneOptions: vcs.CloneOptions{
+			Depth:          1,
+			Proxy:          project.Proxy,
+			CACertificates: project.CACertificates,
+		},
+		wikiCommitOptions: vcs.CommitOptions{
+			AuthorName:  project.Author.Name,
+			AuthorEmail: project.Author.Email,
+		},
+	}, nil
 }
 
 func (bitbucket BitBucket) GetURL() string {
 	return bitbucket.URL
 }
 
-func (bitbucket BitBucket) GetPullRequests(username string, repoSlug string, ignoreDeclinedPRs bool) ([]PullRequest, error) {
+func (bitbucket BitBucket) GetPullRequests(ctx context.Context, username string, repoSlug string, ignoreDeclinedPRs bool) ([]PullRequest, error) {
 
-	log.Logger.Info("Retrieving pull requests")
+	log.For(ctx).Info("Retrieving pull requests")
 
 	acceptedStates := "state=OPEN"
 	if !ignoreDeclinedPRs {
@@ -87,14 +119,14 @@ func (bitbucket BitBucket) GetPullRequests(username string, repoSlug string, ign
 
 	bitBucketPath := fmt.Sprintf("/%s/%s/pullrequests/?%s", username, repoSlug, acceptedStates)
 
-	prRequest, _ := bitbucket.createApiRequest("GET", bitBucketPath, nil)
+	prRequest, _ := bitbucket.createApiRequest(ctx, "GET", bitBucketPath, nil)
 	prRequest.Header.Add("Content-Type", "application/json")
 
 	var list pullRequestList
 
 	tmpList, e := bitbucket.getPRRequest(prRequest)
 	if e != nil {
-		log.Logger.Error(e.Error())
+		log.For(ctx).Error(e.Error())
 		return nil, e
 	}
 	list.PullRequest = append(list.PullRequest, tmpList.PullRequest...)
@@ -109,13 +141,13 @@ func (bitbucket BitBucket) GetPullRequests(username string, repoSlug string, ign
 			tmpList.Next = "" //Reset
 			tmpList, e = bitbucket.getPRRequest(prRequest)
 			if e != nil {
-				log.Logger.Error(e.Error())
+				log.For(ctx).Error(e.Error())
 				return nil, e
 			}
 			list.PullRequest = append(list.PullRequest, tmpList.PullRequest...)
 		}
 	}
-	log.Logger.Infof("Found %d PRs.", len(list.PullRequest))
+	log.For(ctx).Infof("Found %d PRs.", len(list.PullRequest))
 
 	pullRequests := []PullRequest{}
 	for _, bitBucketPr := range list.PullRequest {
@@ -123,6 +155,7 @@ func (bitbucket BitBucket) GetPullRequests(username string, repoSlug string, ign
 			ID:                bitBucketPr.ID,
 			Title:             bitBucketPr.Title,
 			Description:       bitBucketPr.Description,
+			Metadata:          ParseMetadata(bitBucketPr.Description),
 			Source:            &source{
 				Branch: branch{
 					Name: bitBucketPr.Source.GetName(),
@@ -142,18 +175,18 @@ func (bitbucket BitBucket) GetPullRequests(username string, repoSlug string, ign
 	return pullRequests, nil
 }
 
-func (bitbucket BitBucket) getDefaultReviewers(username string, repoSlug string) ([]user, error) {
+func (bitbucket BitBucket) getDefaultReviewers(ctx context.Context, username string, repoSlug string) ([]user, error) {
 
 	bitBucketPath := fmt.Sprintf("/%s/%s/default-reviewers", username, repoSlug)
 
-	request, _ := bitbucket.createApiRequest("GET", bitBucketPath, nil)
+	request, _ := bitbucket.createApiRequest(ctx, "GET", bitBucketPath, nil)
 	request.Header.Add("Content-Type", "application/json")
 
-	client := getHTTPClient()
+	client := getHTTPClient(bitbucket.transport)
 	resp, err := client.Do(request)
 
 	if err != nil || resp.StatusCode < 200 || resp.StatusCode > 299 {
-		log.Logger.Error("Error getting default reviewers", client.LogString())
+		log.For(ctx).Error("Error getting default reviewers", client.LogString())
 		return nil, errors.New("Something went wrong getting default reviewers, got status code " + resp.Status)
 	}
 
@@ -165,17 +198,17 @@ func (bitbucket BitBucket) getDefaultReviewers(username string, repoSlug string)
 
 	defer resp.Body.Close()
 
-	log.Logger.Tracef("Getting '%s' default reviewers returned %d: %d.", request.URL, resp.StatusCode, len(jsonresp.Values))
+	log.For(ctx).Tracef("Getting '%s' default reviewers returned %d: %d.", request.URL, resp.StatusCode, len(jsonresp.Values))
 
 	return jsonresp.Values, nil
 }
 
 func (bitbucket BitBucket) getPRRequest(prRequest *http.Request) (*pullRequestList, error) {
-	client := getHTTPClient()
+	client := getHTTPClient(bitbucket.transport)
 	resp, err := client.Do(prRequest)
 
 	if err != nil || resp.StatusCode < 200 || resp.StatusCode > 299 {
-		log.Logger.Error("Error getting PR Requests", client.LogString())
+		log.For(prRequest.Context()).Error("Error getting PR Requests", client.LogString())
 		return nil, errors.New("Something went wrong getting PR, got status code " + resp.Status)
 	}
 
@@ -184,19 +217,19 @@ func (bitbucket BitBucket) getPRRequest(prRequest *http.Request) (*pullRequestLi
 
 	defer resp.Body.Close()
 
-	log.Logger.Tracef("Getting '%s' PR returned %d.", prRequest.URL, resp.StatusCode)
+	log.For(prRequest.Context()).Tracef("Getting '%s' PR returned %d.", prRequest.URL, resp.StatusCode)
 	return &prList, nil
 }
 
-func (bitbucket BitBucket) CreatePullRequest(sourceBranch string, destBranch string, owner string, repo string, title string, description string, useDefaultReviewers bool) error {
+func (bitbucket BitBucket) CreatePullRequest(ctx context.Context, sourceBranch string, destBranch string, owner string, repo string, title string, description string, options PullRequestOptions) error {
 	reviewers := []user{}
-	if useDefaultReviewers {
-		reviewers, _ = bitbucket.getDefaultReviewers(owner, repo)
+	if options.UseDefaultReviewers {
+		reviewers, _ = bitbucket.getDefaultReviewers(ctx, owner, repo)
 	}
 
 	pr := PullRequest{
 		Title:       title,
-		Description: description,
+		Description: withUnsupportedOptions(description, options),
 		Source: &source{
 			Branch: branch{
 				Name: sourceBranch,
@@ -211,24 +244,29 @@ func (bitbucket BitBucket) CreatePullRequest(sourceBranch string, destBranch str
 		Reviewers:         reviewers,
 	}
 
+	body := struct {
+		PullRequest
+		Draft bool `json:"draft,omitempty"`
+	}{pr, options.Draft}
+
 	buf := &bytes.Buffer{}
-	json.NewEncoder(buf).Encode(&pr)
+	json.NewEncoder(buf).Encode(&body)
 
-	prRequest, err := bitbucket.createApiRequest("POST", fmt.Sprintf("/%s/%s/pullrequests/", owner, repo), buf)
+	prRequest, err := bitbucket.createApiRequest(ctx, "POST", fmt.Sprintf("/%s/%s/pullrequests/", owner, repo), buf)
 	if err != nil {
-		log.Logger.Error("Could not create a pull request")
+		log.For(ctx).Error("Could not create a pull request")
 		return err
 	}
 
 	prRequest.Header.Add("Content-Type", "application/json")
 
-	log.Logger.Tracef("%v", prRequest)
+	log.For(ctx).Tracef("%v", prRequest)
 
-	client := getHTTPClient()
+	client := getHTTPClient(bitbucket.transport)
 	resp, err := client.Do(prRequest)
 
 	if err != nil {
-		log.Logger.Error("Error getting PR Requests", client.LogString())
+		log.For(ctx).Error("Error getting PR Requests", client.LogString())
 		return err
 	}
 
@@ -239,24 +277,43 @@ func (bitbucket BitBucket) CreatePullRequest(sourceBranch string, destBranch str
 	return nil
 }
 
-func (bitbucket BitBucket) DeclinePullRequest(username string, repoSlug string, pullRequestID int) error {
+// withUnsupportedOptions lists the labels, the assignees and the milestone at the end of the description, Bitbucket
+// having none of them. The assignees are usernames while the reviewers are accounts, so they are not asked to review.
+func withUnsupportedOptions(description string, options PullRequestOptions) string {
+	var lines []string
+	if len(options.Labels) > 0 {
+		lines = append(lines, "Labels: "+strings.Join(options.Labels, ", "))
+	}
+	if len(options.Assignees) > 0 {
+		lines = append(lines, "Assignees: "+strings.Join(options.Assignees, ", "))
+	}
+	if options.Milestone != "" {
+		lines = append(lines, "Milestone: "+options.Milestone)
+	}
+	if len(lines) == 0 {
+		return description
+	}
+	return description + "\n\n" + strings.Join(lines, "\n")
+}
+
+func (bitbucket BitBucket) DeclinePullRequest(ctx context.Context, username string, repoSlug string, pullRequestID int) error {
 
 	bitBucketPath := fmt.Sprintf("/%s/%s/pullrequests/%d/decline", username, repoSlug, pullRequestID)
-	prRequest, err := bitbucket.createApiRequest("POST", bitBucketPath, strings.NewReader("{}"))
+	prRequest, err := bitbucket.createApiRequest(ctx, "POST", bitBucketPath, strings.NewReader("{}"))
 	if err != nil {
-		log.Logger.Error("Could not decline pull request")
+		log.For(ctx).Error("Could not decline pull request")
 		return err
 	}
 
 	prRequest.Header.Add("Content-Type", "application/json")
 
-	log.Logger.Tracef("%v", prRequest)
+	log.For(ctx).Tracef("%v", prRequest)
 
-	client := getHTTPClient()
+	client := getHTTPClient(bitbucket.transport)
 	resp, err := client.Do(prRequest)
 
 	if err != nil {
-		log.Logger.Error("Error declining PR Request", client.LogString())
+		log.For(ctx).Error("Error declining PR Request", client.LogString())
 		return err
 	}
 
@@ -267,10 +324,285 @@ func (bitbucket BitBucket) DeclinePullRequest(username string, repoSlug string,
 	return nil
 }
 
-func (bitbucket BitBucket) createApiRequest(method string, path string, body io.Reader) (*http.Request, error) {
+func (bitbucket BitBucket) GetPullRequestStatus(ctx context.Context, username string, repoSlug string, pullRequest PullRequest) (BuildStatus, error) {
+
+	bitBucketPath := fmt.Sprintf("/%s/%s/pullrequests/%d/statuses?pagelen=100", username, repoSlug, pullRequest.ID)
+
+	request, _ := bitbucket.createApiRequest(ctx, "GET", bitBucketPath, nil)
+	request.Header.Add("Content-Type", "application/json")
+
+	client := getHTTPClient(bitbucket.transport)
+	resp, err := client.Do(request)
+
+	if err != nil {
+		log.For(ctx).Error("Error getting PR statuses", client.LogString())
+		return BuildNone, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		log.For(ctx).Error("Error getting PR statuses", client.LogString())
+		return BuildNone, errors.New("Something went wrong getting PR statuses, got status code " + resp.Status)
+	}
+
+	type commitStatus struct {
+		State string `json:"state"`
+	}
+	type GetStatuses struct {
+		Values []commitStatus `json:"values"`
+	}
+	var jsonresp GetStatuses
+	json.NewDecoder(resp.Body).Decode(&jsonresp)
+
+	log.For(ctx).Tracef("Getting '%s' statuses returned %d: %d.", request.URL, resp.StatusCode, len(jsonresp.Values))
+
+	var statuses []BuildStatus
+	for _, status := range jsonresp.Values {
+		switch status.State {
+		case "SUCCESSFUL":
+			statuses = append(statuses, BuildSuccessful)
+		case "INPROGRESS":
+			statuses = append(statuses, BuildInProgress)
+		default:
+			statuses = append(statuses, BuildFailed)
+		}
+	}
+
+	return aggregateBuildStatus(statuses), nil
+}
+
+func (bitbucket BitBucket) MergePullRequest(ctx context.Context, username string, repoSlug string, pullRequestID int) error {
+
+	bitBucketPath := fmt.Sprintf("/%s/%s/pullrequests/%d/merge", username, repoSlug, pullRequestID)
+	prRequest, err := bitbucket.createApiRequest(ctx, "POST", bitBucketPath, strings.NewReader(`{"close_source_branch": true, "merge_strategy": "merge_commit"}`))
+	if err != nil {
+		log.For(ctx).Error("Could not merge pull request")
+		return err
+	}
+
+	prRequest.Header.Add("Content-Type", "application/json")
+
+	log.For(ctx).Tracef("%v", prRequest)
+
+	client := getHTTPClient(bitbucket.transport)
+	resp, err := client.Do(prRequest)
+
+	if err != nil {
+		log.For(ctx).Error("Error merging PR Request", client.LogString())
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		log.For(ctx).Error("Error merging PR Request", client.LogString())
+		return errors.New("Something went wrong merging PR, got status code " + resp.Status)
+	}
+
+	io.Copy(os.Stdout, resp.Body)
+
+	return nil
+}
+
+func (bitbucket BitBucket) UpdatePullRequest(ctx context.Context, username string, repoSlug string, pullRequestID int, title string, description string) error {
+	pr := struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+	}{title, description}
+
+	if err := bitbucket.sendApiRequest(ctx, "PUT", fmt.Sprintf("/%s/%s/pullrequests/%d", username, repoSlug, pullRequestID), &pr, nil); err != nil {
+		log.For(ctx).Error("Error updating PR Request")
+		return err
+	}
+	return nil
+}
+
+// GetMergedPullRequests returns the 50 most recently updated pull requests merged into destBranch.
+// Bitbucket has no labels on pull requests.
+func (bitbucket BitBucket) GetMergedPullRequests(ctx context.Context, username string, repoSlug string, destBranch string) ([]PullRequest, error) {
+	query := url.Values{}
+	query.Set("state", "MERGED")
+	query.Set("q", fmt.Sprintf("destination.branch.name = %q", destBranch))
+	query.Set("sort", "-updated_on")
+	query.Set("pagelen", "50")
+
+	var list pullRequestList
+	if err := bitbucket.sendApiRequest(ctx, "GET", fmt.Sprintf("/%s/%s/pullrequests?%s", username, repoSlug, query.Encode()), nil, &list); err != nil {
+		log.For(ctx).Error("Error getting merged PR Requests")
+		return nil, err
+	}
+
+	pullRequests := []PullRequest{}
+	for _, bitBucketPr := range list.PullRequest {
+		pr := PullRequest{
+			ID:          bitBucketPr.ID,
+			Title:       bitBucketPr.Title,
+			Description: bitBucketPr.Description,
+			Metadata:    ParseMetadata(bitBucketPr.Description),
+			Source: &source{
+				Branch: branch{
+					Name: bitBucketPr.Source.GetName(),
+				},
+			},
+			Dest: &dest{
+				Branch: branch{
+					Name: bitBucketPr.Dest.GetName(),
+				},
+			},
+			State: bitBucketPr.State,
+		}
+		if bitBucketPr.MergeCommit != nil {
+			pr.MergeCommit = bitBucketPr.MergeCommit.Hash
+		}
+		pullRequests = append(pullRequests, pr)
+	}
+	return pullRequests, nil
+}
+
+func (bitbucket BitBucket) CommentPullRequest(ctx context.Context, username string, repoSlug string, pullRequestID int, comment string) error {
+	body := struct {
+		Content bitbucketIssueContent `json:"content"`
+	}{bitbucketIssueContent{Raw: comment}}
+
+	if err := bitbucket.sendApiRequest(ctx, "POST", fmt.Sprintf("/%s/%s/pullrequests/%d/comments", username, repoSlug, pullRequestID), &body, nil); err != nil {
+		log.For(ctx).Error("Error commenting PR Request")
+		return err
+	}
+	return nil
+}
+
+func (bitbucket BitBucket) GetPullRequestComments(ctx context.Context, username string, repoSlug string, pullRequestID int) ([]string, error) {
+	type commentList struct {
+		Values []struct {
+			Content bitbucketIssueContent `json:"content"`
+		} `json:"values"`
+	}
+	var list commentList
+	if err := bitbucket.sendApiRequest(ctx, "GET", fmt.Sprintf("/%s/%s/pullrequests/%d/comments?pagelen=100", username, repoSlug, pullRequestID), nil, &list); err != nil {
+		log.For(ctx).Error("Error getting PR Request comments")
+		return nil, err
+	}
+
+	var comments []string
+	for _, comment := range list.Values {
+		comments = append(comments, comment.Content.Raw)
+	}
+	return comments, nil
+}
+
+type bitbucketIssueContent struct {
+	Raw string `json:"raw"`
+}
+
+type bitbucketIssue struct {
+	ID      int                   `json:"id,omitempty"`
+	Title   string                `json:"title,omitempty"`
+	Content bitbucketIssueContent `json:"content"`
+}
+
+// FindIssue returns the open issue with the given title, nil if there is none. When the issue tracker of the repository
+// is disabled, the issues are pages of its wiki.
+func (bitbucket BitBucket) FindIssue(ctx context.Context, username string, repoSlug string, title string) (*Issue, error) {
+	if tracker, err := bitbucket.hasIssueTracker(ctx, username, repoSlug); err != nil {
+		return nil, err
+	} else if !tracker {
+		return bitbucket.findWikiPage(ctx, username, repoSlug, title)
+	}
+
+	query := url.Values{}
+	query.Set("q", fmt.Sprintf(`title = %q AND (state = "new" OR state = "open")`, title))
+	bitBucketPath := fmt.Sprintf("/%s/%s/issues?%s", username, repoSlug, query.Encode())
+
+	type issueList struct {
+		Values []bitbucketIssue `json:"values"`
+	}
+	var list issueList
+	if err := bitbucket.sendApiRequest(ctx, "GET", bitBucketPath, nil, &list); err != nil {
+		log.For(ctx).Error("Error getting issues")
+		return nil, err
+	}
+
+	for _, issue := range list.Values {
+		if issue.Title == title {
+			return &Issue{ID: issue.ID, Title: issue.Title, Body: issue.Content.Raw}, nil
+		}
+	}
+	return nil, nil
+}
+
+func (bitbucket BitBucket) CreateIssue(ctx context.Context, username string, repoSlug string, title string, body string) error {
+	if tracker, err := bitbucket.hasIssueTracker(ctx, username, repoSlug); err != nil {
+		return err
+	} else if !tracker {
+		return bitbucket.writeWikiPage(ctx, username, repoSlug, wikiPageName(title), body)
+	}
+
+	issue := bitbucketIssue{Title: title, Content: bitbucketIssueContent{Raw: body}}
+	if err := bitbucket.sendApiRequest(ctx, "POST", fmt.Sprintf("/%s/%s/issues", username, repoSlug), &issue, nil); err != nil {
+		log.For(ctx).Error("Error creating issue")
+		return err
+	}
+	return nil
+}
+
+func (bitbucket BitBucket) UpdateIssue(ctx context.Context, username string, repoSlug string, issueID int, body string) error {
+	if tracker, err := bitbucket.hasIssueTracker(ctx, username, repoSlug); err != nil {
+		return err
+	} else if !tracker {
+		return bitbucket.updateWikiPage(ctx, username, repoSlug, issueID, body)
+	}
+
+	issue := bitbucketIssue{Content: bitbucketIssueContent{Raw: body}}
+	if err := bitbucket.sendApiRequest(ctx, "PUT", fmt.Sprintf("/%s/%s/issues/%d", username, repoSlug, issueID), &issue, nil); err != nil {
+		log.For(ctx).Error("Error updating issue")
+		return err
+	}
+	return nil
+}
+
+// sendApiRequest sends body encoded as json and decodes the response into result when it is not nil
+func (bitbucket BitBucket) sendApiRequest(ctx context.Context, method string, path string, body interface{}, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		buf := &bytes.Buffer{}
+		if err := json.NewEncoder(buf).Encode(body); err != nil {
+			return err
+		}
+		reader = buf
+	}
+
+	request, err := bitbucket.createApiRequest(ctx, method, path, reader)
+	if err != nil {
+		return err
+	}
+	request.Header.Add("Content-Type", "application/json")
+
+	client := getHTTPClient(bitbucket.transport)
+	resp, err := client.Do(request)
+	if err != nil {
+		log.For(ctx).Error(client.LogString())
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	log.For(ctx).Tracef("%s '%s' returned %d.", method, request.URL, resp.StatusCode)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s %s returned status code %s", method, path, resp.Status)
+	}
+
+	if result != nil {
+		return json.NewDecoder(resp.Body).Decode(result)
+	}
+	return nil
+}
+
+func (bitbucket BitBucket) createApiRequest(ctx context.Context, method string, path string, body io.Reader) (*http.Request, error) {
 	url := bitbucket.authentication.AuthenticateURL(bitbucket.apiURL + path)
 
-	request, err := http.NewRequest(method, url, body)
+	request, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
 		return nil, err
 	}
@@ -280,8 +612,9 @@ func (bitbucket BitBucket) createApiRequest(method string, path string, body io.
 	return request, err
 }
 
-func getHTTPClient() *pester.Client {
-	client := pester.New()
+// getHTTPClient retries the requests sent with the transport of the project
+func getHTTPClient(transport http.RoundTripper) *pester.Client {
+	client := pester.NewExtendedClient(&http.Client{Transport: transport})
 	client.MaxRetries = 10
 	client.Backoff = pester.ExponentialBackoff
 	client.RetryOnHTTP429 = true
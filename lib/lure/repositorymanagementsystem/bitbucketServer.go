@@ -0,0 +1,442 @@
+package repositorymanagementsystem
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/coveooss/lure/lib/lure/log"
+	"github.com/coveooss/lure/lib/lure/project"
+	"github.com/coveooss/lure/lib/lure/vcs"
+)
+
+// BitbucketServer is the provider for self-hosted Bitbucket Server and Data Center. The owner is the project key.
+type BitbucketServer struct {
+	URL            string
+	baseURL        string
+	authentication vcs.Authentication
+	transport      http.RoundTripper
+}
+
+type bitbucketServerRef struct {
+	ID           string `json:"id"`
+	DisplayID    string `json:"displayId,omitempty"`
+	LatestCommit string `json:"latestCommit,omitempty"`
+}
+
+type bitbucketServerUser struct {
+	Name string `json:"name"`
+	Slug string `json:"slug,omitempty"`
+}
+
+type bitbucketServerParticipant struct {
+	User bitbucketServerUser `json:"user"`
+}
+
+type bitbucketServerPullRequest struct {
+	ID          int                          `json:"id,omitempty"`
+	Version     int                          `json:"version"`
+	Title       string                       `json:"title"`
+	Description string                       `json:"description"`
+	State       string                       `json:"state,omitempty"`
+	FromRef     bitbucketServerRef           `json:"fromRef"`
+	ToRef       bitbucketServerRef           `json:"toRef"`
+	Reviewers   []bitbucketServerParticipant `json:"reviewers"`
+	Properties  struct {
+		MergeCommit struct {
+			ID string `json:"id"`
+		} `json:"mergeCommit"`
+	} `json:"properties"`
+}
+
+// bitbucketServerPage is the paging envelope of every Bitbucket Server list
+type bitbucketServerPage struct {
+	Values        json.RawMessage `json:"values"`
+	IsLastPage    bool            `json:"isLastPage"`
+	NextPageStart int             `json:"nextPageStart"`
+}
+
+var errBitbucketServerIssues = errors.New("Bitbucket Server has no issue tracker")
+
+func NewBitbucketServer(authentication vcs.Authentication, project project.Project) (BitbucketServer, error) {
+	transport, err := NewTransport(project)
+	if err != nil {
+		return BitbucketServer{}, err
+	}
+
+	baseURL := strings.TrimSuffix(project.BaseURL, "/")
+	if baseURL == "" {
+		return BitbucketServer{}, errors.New("Bitbucket Server needs the baseURL of the server")
+	}
+
+	return BitbucketServer{
+		URL:            baseURL + "/scm/" + project.Owner + "/" + project.Name + ".git",
+		baseURL:        baseURL,
+		authentication: authentication,
+		transport:      transport,
+	}, nil
+}
+
+func (server BitbucketServer) GetURL() string {
+	return server.URL
+}
+
+func (server BitbucketServer) repositoryPath(projectKey string, repoSlug string) string {
+	return fmt.Sprintf("/rest/api/1.0/projects/%s/repos/%s", url.PathEscape(projectKey), url.PathEscape(repoSlug))
+}
+
+func (server BitbucketServer) pullRequestPath(projectKey string, repoSlug string, pullRequestID int) string {
+	return fmt.Sprintf("%s/pull-requests/%d", server.repositoryPath(projectKey, repoSlug), pullRequestID)
+}
+
+func (server BitbucketServer) GetPullRequests(ctx context.Context, projectKey string, repoSlug string, ignoreDeclinedPRs bool) ([]PullRequest, error) {
+	log.For(ctx).Info("Retrieving pull requests")
+
+	states := []string{"OPEN"}
+	if !ignoreDeclinedPRs {
+		states = append(states, "DECLINED")
+	}
+
+	var pullRequests []PullRequest
+	for _, state := range states {
+		serverPRs, err := server.listPullRequests(ctx, projectKey, repoSlug, url.Values{"state": {state}})
+		if err != nil {
+			return nil, err
+		}
+		for _, serverPR := range serverPRs {
+			pullRequests = append(pullRequests, serverPR.toPullRequest())
+		}
+	}
+	log.For(ctx).Infof("Found %d PRs.", len(pullRequests))
+
+	return pullRequests, nil
+}
+
+// GetMergedPullRequests returns the 100 most recently updated pull requests merged into destBranch
+func (server BitbucketServer) GetMergedPullRequests(ctx context.Context, projectKey string, repoSlug string, destBranch string) ([]PullRequest, error) {
+	query := url.Values{
+		"state":  {"MERGED"},
+		"at":     {"refs/heads/" + destBranch},
+		"order":  {"NEWEST"},
+		"limit":  {"100"},
+		"direct": {"INCOMING"},
+	}
+
+	var page bitbucketServerPage
+	if err := server.sendApiRequest(ctx, "GET", server.repositoryPath(projectKey, repoSlug)+"/pull-requests?"+query.Encode(), nil, &page); err != nil {
+		log.For(ctx).Error("Error getting merged PR Requests")
+		return nil, err
+	}
+	var serverPRs []bitbucketServerPullRequest
+	if err := json.Unmarshal(page.Values, &serverPRs); err != nil {
+		return nil, err
+	}
+
+	var pullRequests []PullRequest
+	for _, serverPR := range serverPRs {
+		pullRequests = append(pullRequests, serverPR.toPullRequest())
+	}
+	return pullRequests, nil
+}
+
+// listPullRequests follows the isLastPage/nextPageStart paging
+func (server BitbucketServer) listPullRequests(ctx context.Context, projectKey string, repoSlug string, query url.Values) ([]bitbucketServerPullRequest, error) {
+	query.Set("limit", "100")
+
+	var pullRequests []bitbucketServerPullRequest
+	for start := 0; ; {
+		query.Set("start", strconv.Itoa(start))
+
+		var page bitbucketServerPage
+		if err := server.sendApiRequest(ctx, "GET", server.repositoryPath(projectKey, repoSlug)+"/pull-requests?"+query.Encode(), nil, &page); err != nil {
+			log.For(ctx).Error("Error getting PR Requests")
+			return nil, err
+		}
+
+		var pagePullRequests []bitbucketServerPullRequest
+		if err := json.Unmarshal(page.Values, &pagePullRequests); err != nil {
+			return nil, err
+		}
+		pullRequests = append(pullRequests, pagePullRequests...)
+
+		if page.IsLastPage || len(pagePullRequests) == 0 {
+			return pullRequests, nil
+		}
+		start = page.NextPageStart
+	}
+}
+
+func (serverPR bitbucketServerPullRequest) toPullRequest() PullRequest {
+	var reviewers []user
+	for _, reviewer := range serverPR.Reviewers {
+		reviewers = append(reviewers, user{reviewer.User.Name})
+	}
+
+	return PullRequest{
+		ID:          serverPR.ID,
+		Title:       serverPR.Title,
+		Description: serverPR.Description,
+		Metadata:    ParseMetadata(serverPR.Description),
+		Source: &source{
+			Branch: branch{
+				Name: serverPR.FromRef.DisplayID,
+			},
+		},
+		Dest: &dest{
+			Branch: branch{
+				Name: serverPR.ToRef.DisplayID,
+			},
+		},
+		CloseSourceBranch: true,
+		State:             serverPR.State,
+		Reviewers:         reviewers,
+		MergeCommit:       serverPR.Properties.MergeCommit.ID,
+	}
+}
+
+func (server BitbucketServer) getPullRequest(ctx context.Context, projectKey string, repoSlug string, pullRequestID int) (*bitbucketServerPullRequest, error) {
+	var serverPR bitbucketServerPullRequest
+	if err := server.sendApiRequest(ctx, "GET", server.pullRequestPath(projectKey, repoSlug, pullRequestID), nil, &serverPR); err != nil {
+		return nil, err
+	}
+	return &serverPR, nil
+}
+
+func (server BitbucketServer) CreatePullRequest(ctx context.Context, sourceBranch string, destBranch string, projectKey string, repoSlug string, title string, description string, options PullRequestOptions) error {
+	serverPR := bitbucketServerPullRequest{
+		Title:       title,
+		Description: description,
+		FromRef:     bitbucketServerRef{ID: "refs/heads/" + sourceBranch},
+		ToRef:       bitbucketServerRef{ID: "refs/heads/" + destBranch},
+		Reviewers:   []bitbucketServerParticipant{},
+	}
+
+	if options.UseDefaultReviewers {
+		reviewers, err := server.getDefaultReviewers(ctx, projectKey, repoSlug, serverPR.FromRef.ID, serverPR.ToRef.ID)
+		if err != nil {
+			log.For(ctx).Warnf("Could not get default reviewers: %s", err)
+		}
+		for _, reviewer := range reviewers {
+			serverPR.Reviewers = append(serverPR.Reviewers, bitbucketServerParticipant{User: bitbucketServerUser{Name: reviewer.Name}})
+		}
+	}
+
+	var created bitbucketServerPullRequest
+	if err := server.sendApiRequest(ctx, "POST", server.repositoryPath(projectKey, repoSlug)+"/pull-requests", &serverPR, &created); err != nil {
+		log.For(ctx).Error("Error creating PR Request")
+		return err
+	}
+
+	log.For(ctx).Infof("Created Pull Request %d", created.ID)
+
+	return nil
+}
+
+// getDefaultReviewers uses the default reviewers plugin API, which needs the repository id
+func (server BitbucketServer) getDefaultReviewers(ctx context.Context, projectKey string, repoSlug string, sourceRef string, targetRef string) ([]bitbucketServerUser, error) {
+	var repository struct {
+		ID int `json:"id"`
+	}
+	if err := server.sendApiRequest(ctx, "GET", server.repositoryPath(projectKey, repoSlug), nil, &repository); err != nil {
+		return nil, err
+	}
+
+	query := url.Values{
+		"sourceRepoId": {strconv.Itoa(repository.ID)},
+		"targetRepoId": {strconv.Itoa(repository.ID)},
+		"sourceRefId":  {sourceRef},
+		"targetRefId":  {targetRef},
+	}
+	reviewersPath := fmt.Sprintf("/rest/default-reviewers/1.0/projects/%s/repos/%s/reviewers?%s", url.PathEscape(projectKey), url.PathEscape(repoSlug), query.Encode())
+
+	var reviewers []bitbucketServerUser
+	if err := server.sendApiRequest(ctx, "GET", reviewersPath, nil, &reviewers); err != nil {
+		return nil, err
+	}
+	return reviewers, nil
+}
+
+// DeclinePullRequest declines the current version of the pull request
+func (server BitbucketServer) DeclinePullRequest(ctx context.Context, projectKey string, repoSlug string, pullRequestID int) error {
+	serverPR, err := server.getPullRequest(ctx, projectKey, repoSlug, pullRequestID)
+	if err != nil {
+		log.For(ctx).Error("Could not decline pull request")
+		return err
+	}
+
+	declinePath := fmt.Sprintf("%s/decline?version=%d", server.pullRequestPath(projectKey, repoSlug, pullRequestID), serverPR.Version)
+	if err := server.sendApiRequest(ctx, "POST", declinePath, struct{}{}, nil); err != nil {
+		log.For(ctx).Error("Error declining PR Request")
+		return err
+	}
+
+	log.For(ctx).Infof("Declined PR number %d", pullRequestID)
+
+	return nil
+}
+
+func (server BitbucketServer) UpdatePullRequest(ctx context.Context, projectKey string, repoSlug string, pullRequestID int, title string, description string) error {
+	serverPR, err := server.getPullRequest(ctx, projectKey, repoSlug, pullRequestID)
+	if err != nil {
+		return err
+	}
+
+	update := struct {
+		Version     int    `json:"version"`
+		Title       string `json:"title"`
+		Description string `json:"description"`
+	}{serverPR.Version, title, description}
+
+	if err := server.sendApiRequest(ctx, "PUT", server.pullRequestPath(projectKey, repoSlug, pullRequestID), &update, nil); err != nil {
+		log.For(ctx).Error("Error updating PR Request")
+		return err
+	}
+	return nil
+}
+
+func (server BitbucketServer) MergePullRequest(ctx context.Context, projectKey string, repoSlug string, pullRequestID int) error {
+	serverPR, err := server.getPullRequest(ctx, projectKey, repoSlug, pullRequestID)
+	if err != nil {
+		return err
+	}
+
+	mergePath := fmt.Sprintf("%s/merge?version=%d", server.pullRequestPath(projectKey, repoSlug, pullRequestID), serverPR.Version)
+	if err := server.sendApiRequest(ctx, "POST", mergePath, struct{}{}, nil); err != nil {
+		log.For(ctx).Error("Error merging PR Request")
+		return err
+	}
+
+	log.For(ctx).Infof("Merged PR number %d", pullRequestID)
+
+	return nil
+}
+
+func (server BitbucketServer) CommentPullRequest(ctx context.Context, projectKey string, repoSlug string, pullRequestID int, comment string) error {
+	body := struct {
+		Text string `json:"text"`
+	}{comment}
+
+	if err := server.sendApiRequest(ctx, "POST", server.pullRequestPath(projectKey, repoSlug, pullRequestID)+"/comments", &body, nil); err != nil {
+		log.For(ctx).Error("Error commenting PR Request")
+		return err
+	}
+	return nil
+}
+
+func (server BitbucketServer) GetPullRequestComments(ctx context.Context, projectKey string, repoSlug string, pullRequestID int) ([]string, error) {
+	var page bitbucketServerPage
+	if err := server.sendApiRequest(ctx, "GET", server.pullRequestPath(projectKey, repoSlug, pullRequestID)+"/activities?limit=100", nil, &page); err != nil {
+		log.For(ctx).Error("Error getting PR Request comments")
+		return nil, err
+	}
+
+	var activities []struct {
+		Action  string `json:"action"`
+		Comment struct {
+			Text string `json:"text"`
+		} `json:"comment"`
+	}
+	if err := json.Unmarshal(page.Values, &activities); err != nil {
+		return nil, err
+	}
+
+	var comments []string
+	for _, activity := range activities {
+		if activity.Action == "COMMENTED" {
+			comments = append(comments, activity.Comment.Text)
+		}
+	}
+	return comments, nil
+}
+
+// GetPullRequestStatus returns the build statuses of the latest commit of the pull request
+func (server BitbucketServer) GetPullRequestStatus(ctx context.Context, projectKey string, repoSlug string, pullRequest PullRequest) (BuildStatus, error) {
+	serverPR, err := server.getPullRequest(ctx, projectKey, repoSlug, pullRequest.ID)
+	if err != nil {
+		return BuildNone, err
+	}
+
+	var page bitbucketServerPage
+	if err := server.sendApiRequest(ctx, "GET", "/rest/build-status/1.0/commits/"+serverPR.FromRef.LatestCommit, nil, &page); err != nil {
+		log.For(ctx).Error("Error getting PR statuses")
+		return BuildNone, err
+	}
+
+	var buildStatuses []struct {
+		State string `json:"state"`
+	}
+	if err := json.Unmarshal(page.Values, &buildStatuses); err != nil {
+		return BuildNone, err
+	}
+
+	var statuses []BuildStatus
+	for _, status := range buildStatuses {
+		switch status.State {
+		case "SUCCESSFUL":
+			statuses = append(statuses, BuildSuccessful)
+		case "INPROGRESS":
+			statuses = append(statuses, BuildInProgress)
+		default:
+			statuses = append(statuses, BuildFailed)
+		}
+	}
+	return aggregateBuildStatus(statuses), nil
+}
+
+func (server BitbucketServer) FindIssue(ctx context.Context, projectKey string, repoSlug string, title string) (*Issue, error) {
+	return nil, errBitbucketServerIssues
+}
+
+func (server BitbucketServer) CreateIssue(ctx context.Context, projectKey string, repoSlug string, title string, body string) error {
+	return errBitbucketServerIssues
+}
+
+func (server BitbucketServer) UpdateIssue(ctx context.Context, projectKey string, repoSlug string, issueID int, body string) error {
+	return errBitbucketServerIssues
+}
+
+// sendApiRequest sends body encoded as json and decodes the response into result when it is not nil
+func (server BitbucketServer) sendApiRequest(ctx context.Context, method string, path string, body interface{}, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		buf := &bytes.Buffer{}
+		if err := json.NewEncoder(buf).Encode(body); err != nil {
+			return err
+		}
+		reader = buf
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, server.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	request.Header.Add("Content-Type", "application/json")
+	authenticateRequest(server.authentication, request)
+
+	client := getHTTPClient(server.transport)
+	resp, err := client.Do(request)
+	if err != nil {
+		log.For(ctx).Error(client.LogString())
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	log.For(ctx).Tracef("%s '%s' returned %d.", method, path, resp.StatusCode)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s %s returned status code %s", method, path, resp.Status)
+	}
+
+	if result != nil {
+		return json.NewDecoder(resp.Body).Decode(result)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package repositorymanagementsystem_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/coveooss/lure/lib/lure/project"
+	managementsystem "github.com/coveooss/lure/lib/lure/repositorymanagementsystem"
+	"github.com/coveooss/lure/lib/lure/vcs"
+)
+
+const bitbucketServerRepositoryPath = "/rest/api/1.0/projects/CAT/repos/catfeeder"
+
+type fakeBitbucketServer struct {
+	*fakeAPI
+}
+
+func newFakeBitbucketServer(t *testing.T) *fakeBitbucketServer {
+	return &fakeBitbucketServer{newFakeAPI(t, "", func(w http.ResponseWriter, r *http.Request, route string) {
+		switch route {
+		case "GET " + bitbucketServerRepositoryPath + "/pull-requests":
+			query := r.URL.Query()
+			switch {
+			case query.Get("state") == "OPEN" && query.Get("start") == "0":
+				fmt.Fprint(w, `{"values": [{"id": 1, "state": "OPEN", "fromRef": {"displayId": "lure-a"}, "toRef": {"displayId": "master"}}], "isLastPage": false, "nextPageStart": 25}`)
+			case query.Get("state") == "OPEN" && query.Get("start") == "25":
+				fmt.Fprint(w, `{"values": [{"id": 2, "state": "OPEN", "fromRef": {"displayId": "lure-b"}, "toRef": {"displayId": "master"}, "reviewers": [{"user": {"name": "reviewer"}}]}], "isLastPage": true}`)
+			case query.Get("state") == "DECLINED":
+				fmt.Fprint(w, `{"values": [{"id": 3, "state": "DECLINED", "fromRef": {"displayId": "lure-c"}, "toRef": {"displayId": "master"}}], "isLastPage": true}`)
+			default:
+				t.Errorf("Unexpected query %s", r.URL.RawQuery)
+			}
+		case "GET " + bitbucketServerRepositoryPath:
+			fmt.Fprint(w, `{"id": 42}`)
+		case "GET /rest/default-reviewers/1.0/projects/CAT/repos/catfeeder/reviewers":
+			fmt.Fprint(w, `[{"name": "reviewer"}]`)
+		case "GET " + bitbucketServerRepositoryPath + "/pull-requests/12":
+			fmt.Fprint(w, `{"id": 12, "version": 7, "fromRef": {"displayId": "lure-a", "latestCommit": "abc123"}}`)
+		default:
+			fmt.Fprint(w, `{}`)
+		}
+	})}
+}
+
+func (fake *fakeBitbucketServer) newBitbucketServer(t *testing.T) managementsystem.BitbucketServer {
+	server, err := managementsystem.NewBitbucketServer(vcs.TokenAuth{Token: "secret"}, project.Project{BaseURL: fake.server.URL + "/", Owner: "CAT", Name: "catfeeder"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+func TestNewBitbucketServerRequiresBaseURL(t *testing.T) {
+	if _, err := managementsystem.NewBitbucketServer(vcs.TokenAuth{}, project.Project{Owner: "CAT", Name: "catfeeder"}); err == nil {
+		t.Error("Should have required the base URL of the server")
+	}
+}
+
+func TestBitbucketServerSendsUsernameAndPasswordAsBasicAuth(t *testing.T) {
+	fake := newFakeBitbucketServer(t)
+	defer fake.server.Close()
+
+	server, err := managementsystem.NewBitbucketServer(vcs.UserPassAuth{Username: "lure", Password: "secret"}, project.Project{BaseURL: fake.server.URL, Owner: "CAT", Name: "catfeeder"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.GetPullRequests(context.Background(), "CAT", "catfeeder", true); err != nil {
+		t.Fatal(err)
+	}
+
+	if fake.token(0) != "Basic bHVyZTpzZWNyZXQ=" {
+		t.Errorf("Should have sent the username and password, got %q", fake.token(0))
+	}
+}
+
+func TestBitbucketServerGetPullRequestsFollowsPages(t *testing.T) {
+	fake := newFakeBitbucketServer(t)
+	defer fake.server.Close()
+
+	prs, err := fake.newBitbucketServer(t).GetPullRequests(context.Background(), "CAT", "catfeeder", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(prs) != 3 {
+		t.Fatalf("Should have returned both pages and the declined pull requests, got %d", len(prs))
+	}
+	if prs[1].ID != 2 || prs[1].Source.GetName() != "lure-b" || prs[1].Reviewers[0].Uuid != "reviewer" {
+		t.Errorf("Unexpected second pull request %+v", prs[1])
+	}
+	if prs[2].State != "DECLINED" {
+		t.Errorf("Unexpected declined pull request %+v", prs[2])
+	}
+}
+
+func TestBitbucketServerCreatePullRequestWithDefaultReviewers(t *testing.T) {
+	fake := newFakeBitbucketServer(t)
+	defer fake.server.Close()
+
+	err := fake.newBitbucketServer(t).CreatePullRequest(context.Background(), "lure-a", "master", "CAT", "catfeeder", "Update a", "description", managementsystem.PullRequestOptions{UseDefaultReviewers: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := fake.received["POST "+bitbucketServerRepositoryPath+"/pull-requests"]
+	if fmt.Sprint(body["fromRef"]) != "map[id:refs/heads/lure-a]" || fmt.Sprint(body["toRef"]) != "map[id:refs/heads/master]" {
+		t.Errorf("Unexpected pull request %v", body)
+	}
+	if fmt.Sprint(body["reviewers"]) != "[map[user:map[name:reviewer]]]" {
+		t.Errorf("Should request the default reviewers, got %v", body["reviewers"])
+	}
+	if fake.queries["GET /rest/default-reviewers/1.0/projects/CAT/repos/catfeeder/reviewers"] != "sourceRefId=refs%2Fheads%2Flure-a&sourceRepoId=42&targetRefId=refs%2Fheads%2Fmaster&targetRepoId=42" {
+		t.Errorf("Unexpected default reviewers query %v", fake.queries)
+	}
+}
+
+func TestBitbucketServerDeclinePullRequestSendsVersion(t *testing.T) {
+	fake := newFakeBitbucketServer(t)
+	defer fake.server.Close()
+
+	if err := fake.newBitbucketServer(t).DeclinePullRequest(context.Background(), "CAT", "catfeeder", 12); err != nil {
+		t.Fatal(err)
+	}
+
+	if query, ok := fake.queries["POST "+bitbucketServerRepositoryPath+"/pull-requests/12/decline"]; !ok || query != "version=7" {
+		t.Errorf("Should have declined the current version, got %v", fake.queries)
+	}
+}
@@ -0,0 +1,125 @@
+package repositorymanagementsystem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/coveooss/lure/lib/lure/log"
+	"github.com/coveooss/lure/lib/lure/vcs"
+)
+
+// The issue tracker of a Bitbucket repository is often disabled. The issues are then kept as pages of the wiki,
+// which has no API but is a git repository next to the code one. A page is edited like an issue body, so the boxes
+// of the dashboard can still be checked.
+
+var wikiPageNameRegex = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// hasIssueTracker returns whether the issue tracker of the repository is enabled
+func (bitbucket BitBucket) hasIssueTracker(ctx context.Context, username string, repoSlug string) (bool, error) {
+	var repository struct {
+		HasIssues bool `json:"has_issues"`
+	}
+	if err := bitbucket.sendApiRequest(ctx, "GET", fmt.Sprintf("/%s/%s", username, repoSlug), nil, &repository); err != nil {
+		log.For(ctx).Error("Error getting the repository")
+		return false, err
+	}
+	return repository.HasIssues, nil
+}
+
+// wikiPageName returns the file of the wiki page of an issue, like Lure-Dependency-Dashboard.md
+func wikiPageName(title string) string {
+	return strings.Trim(wikiPageNameRegex.ReplaceAllString(title, "-"), "-") + ".md"
+}
+
+// wikiPageID returns the id standing for a wiki page in the issue methods
+func wikiPageID(name string) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(name))
+	return int(hash.Sum32() & 0x7fffffff)
+}
+
+// cloneWiki clones the wiki in a temporary directory, removed by the returned function
+func (bitbucket BitBucket) cloneWiki(ctx context.Context, username string, repoSlug string) (vcs.GitRepo, func(), error) {
+	dir, err := ioutil.TempDir("", "lure-wiki")
+	if err != nil {
+		return vcs.GitRepo{}, nil, err
+	}
+	remove := func() { os.RemoveAll(dir) }
+
+	source := fmt.Sprintf("https://bitbucket.org/%s/%s.git/wiki", username, repoSlug)
+	wiki, err := vcs.NewGit(bitbucket.authentication, source, filepath.Join(dir, "wiki"), "", bitbucket.wikiCloneOptions, bitbucket.wikiCommitOptions)
+	if err == nil {
+		err = wiki.Clone(ctx)
+	}
+	if err != nil {
+		remove()
+		return vcs.GitRepo{}, nil, fmt.Errorf("the issue tracker is disabled and the wiki could not be cloned: %w", err)
+	}
+	return wiki, remove, nil
+}
+
+func (bitbucket BitBucket) findWikiPage(ctx context.Context, username string, repoSlug string, title string) (*Issue, error) {
+	wiki, remove, err := bitbucket.cloneWiki(ctx, username, repoSlug)
+	if err != nil {
+		return nil, err
+	}
+	defer remove()
+
+	name := wikiPageName(title)
+	body, err := ioutil.ReadFile(filepath.Join(wiki.LocalPath(), name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &Issue{ID: wikiPageID(name), Title: title, Body: string(body)}, nil
+}
+
+func (bitbucket BitBucket) updateWikiPage(ctx context.Context, username string, repoSlug string, pageID int, body string) error {
+	wiki, remove, err := bitbucket.cloneWiki(ctx, username, repoSlug)
+	if err != nil {
+		return err
+	}
+	defer remove()
+
+	pages, err := filepath.Glob(filepath.Join(wiki.LocalPath(), "*.md"))
+	if err != nil {
+		return err
+	}
+	for _, page := range pages {
+		if name := filepath.Base(page); wikiPageID(name) == pageID {
+			return bitbucket.commitWikiPage(ctx, wiki, name, body)
+		}
+	}
+	return fmt.Errorf("no page of the wiki has the id %d", pageID)
+}
+
+func (bitbucket BitBucket) writeWikiPage(ctx context.Context, username string, repoSlug string, name string, body string) error {
+	wiki, remove, err := bitbucket.cloneWiki(ctx, username, repoSlug)
+	if err != nil {
+		return err
+	}
+	defer remove()
+
+	return bitbucket.commitWikiPage(ctx, wiki, name, body)
+}
+
+func (bitbucket BitBucket) commitWikiPage(ctx context.Context, wiki vcs.GitRepo, name string, body string) error {
+	if err := ioutil.WriteFile(filepath.Join(wiki.LocalPath(), name), []byte(body), 0644); err != nil {
+		return err
+	}
+	if _, err := wiki.Commit(ctx, "Update "+strings.TrimSuffix(name, ".md")); errors.Is(err, vcs.ErrNothingToCommit) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	_, err := wiki.Push(ctx)
+	return err
+}
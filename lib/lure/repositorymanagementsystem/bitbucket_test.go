@@ -0,0 +1,74 @@
+package repositorymanagementsystem_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/coveooss/lure/lib/lure/project"
+	managementsystem "github.com/coveooss/lure/lib/lure/repositorymanagementsystem"
+	"github.com/coveooss/lure/lib/lure/vcs"
+)
+
+const bitbucketRepositoryPath = "/2.0/repositories/coveo/catfeeder"
+
+func newFakeBitbucket(t *testing.T, statuses string) (*fakeAPI, managementsystem.BitBucket) {
+	fake := newFakeAPI(t, bitbucketRepositoryPath, func(w http.ResponseWriter, r *http.Request, route string) {
+		switch route {
+		case "GET /pullrequests/12/statuses":
+			fmt.Fprintf(w, `{"values": %s}`, statuses)
+		case "POST /pullrequests/12/merge":
+			fmt.Fprint(w, `{"id": 12, "state": "MERGED"}`)
+		case "POST /pullrequests/13/merge":
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"type": "error", "error": {"message": "You can't merge until you resolve all merge conflicts."}}`)
+		default:
+			t.Errorf("Unexpected request %s", route)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	bitbucket, err := managementsystem.NewBitbucket(vcs.TokenAuth{Token: "secret"}, project.Project{APIURL: fake.server.URL + "/2.0", Owner: "coveo", Name: "catfeeder"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fake, bitbucket
+}
+
+func TestBitbucketGetPullRequestStatusAggregatesStatuses(t *testing.T) {
+	for statuses, expected := range map[string]managementsystem.BuildStatus{
+		`[]`: managementsystem.BuildNone,
+		`[{"state": "SUCCESSFUL"}, {"state": "SUCCESSFUL"}]`: managementsystem.BuildSuccessful,
+		`[{"state": "SUCCESSFUL"}, {"state": "INPROGRESS"}]`: managementsystem.BuildInProgress,
+		`[{"state": "INPROGRESS"}, {"state": "STOPPED"}]`:    managementsystem.BuildFailed,
+	} {
+		fake, bitbucket := newFakeBitbucket(t, statuses)
+		status, err := bitbucket.GetPullRequestStatus(context.Background(), "coveo", "catfeeder", managementsystem.PullRequest{ID: 12})
+		fake.server.Close()
+
+		if err != nil || status != expected {
+			t.Errorf("Expected %s for %s, got %s %v", expected, statuses, status, err)
+		}
+		if fake.token(0) != "Bearer secret" {
+			t.Errorf("Should have authenticated the request, got %q", fake.token(0))
+		}
+	}
+}
+
+func TestBitbucketMergePullRequestMakesAMergeCommit(t *testing.T) {
+	fake, bitbucket := newFakeBitbucket(t, `[]`)
+	defer fake.server.Close()
+
+	if err := bitbucket.MergePullRequest(context.Background(), "coveo", "catfeeder", 12); err != nil {
+		t.Fatal(err)
+	}
+	merge := fake.received["POST /pullrequests/12/merge"]
+	if merge["merge_strategy"] != "merge_commit" || merge["close_source_branch"] != true {
+		t.Errorf("Unexpected merge %v", merge)
+	}
+
+	if err := bitbucket.MergePullRequest(context.Background(), "coveo", "catfeeder", 13); err == nil {
+		t.Error("Should have returned the refusal of Bitbucket")
+	}
+}
@@ -0,0 +1,70 @@
+package repositorymanagementsystem
+
+import (
+	"path"
+	"strings"
+)
+
+// codeOwnersPaths are the locations where GitHub looks for the CODEOWNERS file, in order
+var codeOwnersPaths = []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS"}
+
+type codeOwnersRule struct {
+	pattern string
+	owners  []string
+}
+
+type codeOwners []codeOwnersRule
+
+func parseCodeOwners(content string) codeOwners {
+	var rules codeOwners
+	for _, line := range strings.Split(content, "\n") {
+		if comment := strings.Index(line, "#"); comment >= 0 {
+			line = line[:comment]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		rules = append(rules, codeOwnersRule{pattern: fields[0], owners: fields[1:]})
+	}
+	return rules
+}
+
+// ownersOf returns the owners of the last rule matching file, as the last match takes precedence
+func (rules codeOwners) ownersOf(file string) []string {
+	for i := len(rules) - 1; i >= 0; i-- {
+		if rules[i].matches(file) {
+			return rules[i].owners
+		}
+	}
+	return nil
+}
+
+// matches supports the common gitignore patterns: anchored or not, directories and globs
+func (rule codeOwnersRule) matches(file string) bool {
+	pattern := rule.pattern
+	if pattern == "*" {
+		return true
+	}
+
+	anchored := strings.HasPrefix(pattern, "/") || strings.Contains(strings.TrimSuffix(pattern, "/"), "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+	directory := strings.HasSuffix(pattern, "/")
+	pattern = strings.TrimSuffix(strings.TrimSuffix(pattern, "/**"), "/")
+
+	segments := strings.Split(file, "/")
+	for start := range segments {
+		if anchored && start > 0 {
+			break
+		}
+		for end := start + 1; end <= len(segments); end++ {
+			if directory && end == len(segments) {
+				break
+			}
+			if matched, _ := path.Match(pattern, strings.Join(segments[start:end], "/")); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
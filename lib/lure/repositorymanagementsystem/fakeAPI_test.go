@@ -0,0 +1,54 @@
+package repositorymanagementsystem_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeAPI is the server of the provider tests. It records the headers of the requests, and their query and json body
+// by route, and lets the provider answer. A route is the method and the path without the prefix of the repository,
+// e.g. "POST /pulls".
+type fakeAPI struct {
+	server   *httptest.Server
+	headers  []http.Header
+	queries  map[string]string
+	received map[string]map[string]interface{}
+}
+
+func newFakeAPI(t *testing.T, prefix string, answer func(w http.ResponseWriter, r *http.Request, route string)) *fakeAPI {
+	fake := &fakeAPI{queries: map[string]string{}, received: map[string]map[string]interface{}{}}
+
+	fake.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fake.headers = append(fake.headers, r.Header.Clone())
+
+		// the raw path keeps the escaped slashes of paths like /projects/group%2Fcatfeeder
+		path := r.URL.Path
+		if r.URL.RawPath != "" {
+			path = r.URL.RawPath
+		}
+		if !strings.HasPrefix(path, prefix) {
+			t.Errorf("Unexpected repository path %s", path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		route := r.Method + " " + strings.TrimPrefix(path, prefix)
+		fake.queries[route] = r.URL.RawQuery
+
+		var body map[string]interface{}
+		if json.NewDecoder(r.Body).Decode(&body) == nil {
+			fake.received[route] = body
+		}
+
+		answer(w, r, route)
+	}))
+
+	return fake
+}
+
+// token returns the Authorization header of the request
+func (fake *fakeAPI) token(request int) string {
+	return fake.headers[request].Get("Authorization")
+}
@@ -0,0 +1,457 @@
+package repositorymanagementsystem
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/coveooss/lure/lib/lure/log"
+	"github.com/coveooss/lure/lib/lure/project"
+	"github.com/coveooss/lure/lib/lure/vcs"
+)
+
+const (
+	defaultGiteaURL = "https://gitea.com"
+	giteaPageSize   = 50
+)
+
+// Gitea is the provider for Gitea and its Forgejo fork, which share the same API
+type Gitea struct {
+	URL            string
+	apiURL         string
+	authentication vcs.Authentication
+	transport      http.RoundTripper
+}
+
+type giteaUser struct {
+	Login string `json:"login"`
+}
+
+type giteaLabel struct {
+	Name string `json:"name"`
+}
+
+type giteaBranch struct {
+	Ref string `json:"ref"`
+	Sha string `json:"sha"`
+}
+
+type giteaPullRequest struct {
+	Number             int          `json:"number"`
+	Title              string       `json:"title"`
+	Body               string       `json:"body"`
+	State              string       `json:"state"`
+	Merged             bool         `json:"merged"`
+	MergeCommitSha     string       `json:"merge_commit_sha"`
+	Head               giteaBranch  `json:"head"`
+	Base               giteaBranch  `json:"base"`
+	Labels             []giteaLabel `json:"labels"`
+	RequestedReviewers []giteaUser  `json:"requested_reviewers"`
+}
+
+type giteaIssue struct {
+	Number int    `json:"number"`
+	Title  string `json:"title"`
+	Body   string `json:"body"`
+}
+
+func NewGitea(authentication vcs.Authentication, project project.Project) (Gitea, error) {
+	transport, err := NewTransport(project)
+	if err != nil {
+		return Gitea{}, err
+	}
+
+	baseURL := strings.TrimSuffix(project.BaseURL, "/")
+	if baseURL == "" {
+		baseURL = defaultGiteaURL
+	}
+	apiURL := strings.TrimSuffix(project.APIURL, "/")
+	if apiURL == "" {
+		apiURL = baseURL + "/api/v1"
+	}
+
+	return Gitea{
+		URL:            baseURL + "/" + project.Owner + "/" + project.Name,
+		apiURL:         apiURL,
+		authentication: authentication,
+		transport:      transport,
+	}, nil
+}
+
+func (gitea Gitea) GetURL() string {
+	return gitea.URL
+}
+
+func (gitea Gitea) repositoryPath(owner string, repo string) string {
+	return "/repos/" + url.PathEscape(owner) + "/" + url.PathEscape(repo)
+}
+
+func (gitea Gitea) GetPullRequests(ctx context.Context, owner string, repo string, ignoreDeclinedPRs bool) ([]PullRequest, error) {
+	log.For(ctx).Info("Retrieving pull requests")
+
+	state := "all"
+	if ignoreDeclinedPRs {
+		state = "open"
+	}
+
+	giteaPRs, err := gitea.listPullRequests(ctx, owner, repo, url.Values{"state": {state}})
+	if err != nil {
+		log.For(ctx).Error("Error getting PR Requests")
+		return nil, err
+	}
+
+	var pullRequests []PullRequest
+	for _, giteaPR := range giteaPRs {
+		if giteaPR.Merged {
+			continue
+		}
+		pullRequests = append(pullRequests, giteaPR.toPullRequest())
+	}
+	log.For(ctx).Infof("Found %d PRs.", len(pullRequests))
+
+	return pullRequests, nil
+}
+
+// GetMergedPullRequests returns the most recently updated pull requests merged into destBranch
+func (gitea Gitea) GetMergedPullRequests(ctx context.Context, owner string, repo string, destBranch string) ([]PullRequest, error) {
+	query := url.Values{
+		"state": {"closed"},
+		"sort":  {"recentupdate"},
+		"page":  {"1"},
+		"limit": {fmt.Sprint(giteaPageSize)},
+	}
+
+	var giteaPRs []giteaPullRequest
+	if err := gitea.sendApiRequest(ctx, "GET", gitea.repositoryPath(owner, repo)+"/pulls?"+query.Encode(), nil, &giteaPRs); err != nil {
+		log.For(ctx).Error("Error getting merged PR Requests")
+		return nil, err
+	}
+
+	var pullRequests []PullRequest
+	for _, giteaPR := range giteaPRs {
+		if giteaPR.Merged && giteaPR.Base.Ref == destBranch {
+			pullRequests = append(pullRequests, giteaPR.toPullRequest())
+		}
+	}
+	return pullRequests, nil
+}
+
+// listPullRequests goes through the pages until all the pull requests are received
+func (gitea Gitea) listPullRequests(ctx context.Context, owner string, repo string, query url.Values) ([]giteaPullRequest, error) {
+	query.Set("limit", fmt.Sprint(giteaPageSize))
+
+	var pullRequests []giteaPullRequest
+	for page := 1; ; page++ {
+		query.Set("page", fmt.Sprint(page))
+
+		var pagePullRequests []giteaPullRequest
+		header, err := gitea.sendRequest(ctx, "GET", gitea.repositoryPath(owner, repo)+"/pulls?"+query.Encode(), nil, &pagePullRequests)
+		if err != nil {
+			return nil, err
+		}
+		pullRequests = append(pullRequests, pagePullRequests...)
+
+		if len(pagePullRequests) == 0 || !hasNextPage(header, len(pullRequests)) {
+			return pullRequests, nil
+		}
+	}
+}
+
+func (giteaPR giteaPullRequest) toPullRequest() PullRequest {
+	state := "OPEN"
+	if giteaPR.Merged {
+		state = "MERGED"
+	} else if giteaPR.State == "closed" {
+		state = "DECLINED"
+	}
+
+	var reviewers []user
+	for _, reviewer := range giteaPR.RequestedReviewers {
+		reviewers = append(reviewers, user{reviewer.Login})
+	}
+
+	var labels []string
+	for _, label := range giteaPR.Labels {
+		labels = append(labels, label.Name)
+	}
+
+	return PullRequest{
+		ID:          giteaPR.Number,
+		Title:       giteaPR.Title,
+		Description: giteaPR.Body,
+		Metadata:    ParseMetadata(giteaPR.Body),
+		Source: &source{
+			Branch: branch{
+				Name: giteaPR.Head.Ref,
+			},
+		},
+		Dest: &dest{
+			Branch: branch{
+				Name: giteaPR.Base.Ref,
+			},
+		},
+		CloseSourceBranch: true,
+		State:             state,
+		Reviewers:         reviewers,
+		Labels:            labels,
+		MergeCommit:       giteaPR.MergeCommitSha,
+	}
+}
+
+func (gitea Gitea) CreatePullRequest(ctx context.Context, sourceBranch string, destBranch string, owner string, repo string, title string, description string, options PullRequestOptions) error {
+	body := map[string]interface{}{
+		"head":  sourceBranch,
+		"base":  destBranch,
+		"title": title,
+		"body":  description,
+	}
+
+	var created giteaPullRequest
+	if err := gitea.sendApiRequest(ctx, "POST", gitea.repositoryPath(owner, repo)+"/pulls", body, &created); err != nil {
+		log.For(ctx).Error("Error creating PR Request")
+		return err
+	}
+
+	log.For(ctx).Infof("Created Pull Request %d", created.Number)
+
+	if options.UseDefaultReviewers {
+		reviewers, err := gitea.getDefaultReviewers(ctx, owner, repo, destBranch)
+		if err != nil {
+			log.For(ctx).Warnf("Could not get default reviewers: %s", err)
+			return nil
+		}
+		if len(reviewers) == 0 {
+			return nil
+		}
+
+		reviewersBody := map[string]interface{}{"reviewers": reviewers}
+		if err := gitea.sendApiRequest(ctx, "POST", fmt.Sprintf("%s/pulls/%d/requested_reviewers", gitea.repositoryPath(owner, repo), created.Number), reviewersBody, nil); err != nil {
+			log.For(ctx).Error("Error requesting reviewers")
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getDefaultReviewers returns the users allowed to approve on the protected destBranch.
+// Gitea has no default reviewers, so an unprotected branch has none.
+func (gitea Gitea) getDefaultReviewers(ctx context.Context, owner string, repo string, destBranch string) ([]string, error) {
+	var protections []struct {
+		BranchName                  string   `json:"branch_name"`
+		RuleName                    string   `json:"rule_name"`
+		ApprovalsWhitelistUsernames []string `json:"approvals_whitelist_username"`
+	}
+	if err := gitea.sendApiRequest(ctx, "GET", gitea.repositoryPath(owner, repo)+"/branch_protections", nil, &protections); err != nil {
+		return nil, err
+	}
+
+	for _, protection := range protections {
+		if protection.BranchName == destBranch || protection.RuleName == destBranch {
+			return protection.ApprovalsWhitelistUsernames, nil
+		}
+	}
+	return nil, nil
+}
+
+func (gitea Gitea) DeclinePullRequest(ctx context.Context, owner string, repo string, pullRequestID int) error {
+	body := map[string]interface{}{"state": "closed"}
+
+	if err := gitea.sendApiRequest(ctx, "PATCH", fmt.Sprintf("%s/pulls/%d", gitea.repositoryPath(owner, repo), pullRequestID), body, nil); err != nil {
+		log.For(ctx).Error("Error declining PR Request")
+		return err
+	}
+
+	log.For(ctx).Infof("Declined PR number %d", pullRequestID)
+
+	return nil
+}
+
+func (gitea Gitea) UpdatePullRequest(ctx context.Context, owner string, repo string, pullRequestID int, title string, description string) error {
+	body := map[string]interface{}{"title": title, "body": description}
+
+	if err := gitea.sendApiRequest(ctx, "PATCH", fmt.Sprintf("%s/pulls/%d", gitea.repositoryPath(owner, repo), pullRequestID), body, nil); err != nil {
+		log.For(ctx).Error("Error updating PR Request")
+		return err
+	}
+	return nil
+}
+
+func (gitea Gitea) MergePullRequest(ctx context.Context, owner string, repo string, pullRequestID int) error {
+	body := map[string]interface{}{"Do": "merge"}
+
+	if err := gitea.sendApiRequest(ctx, "POST", fmt.Sprintf("%s/pulls/%d/merge", gitea.repositoryPath(owner, repo), pullRequestID), body, nil); err != nil {
+		log.For(ctx).Error("Error merging PR Request")
+		return err
+	}
+
+	log.For(ctx).Infof("Merged PR number %d", pullRequestID)
+
+	return nil
+}
+
+// CommentPullRequest comments through the issue API, pull requests being issues in Gitea
+func (gitea Gitea) CommentPullRequest(ctx context.Context, owner string, repo string, pullRequestID int, comment string) error {
+	body := map[string]interface{}{"body": comment}
+
+	if err := gitea.sendApiRequest(ctx, "POST", fmt.Sprintf("%s/issues/%d/comments", gitea.repositoryPath(owner, repo), pullRequestID), body, nil); err != nil {
+		log.For(ctx).Error("Error commenting PR Request")
+		return err
+	}
+	return nil
+}
+
+func (gitea Gitea) GetPullRequestComments(ctx context.Context, owner string, repo string, pullRequestID int) ([]string, error) {
+	var giteaComments []struct {
+		Body string `json:"body"`
+	}
+	if err := gitea.sendApiRequest(ctx, "GET", fmt.Sprintf("%s/issues/%d/comments", gitea.repositoryPath(owner, repo), pullRequestID), nil, &giteaComments); err != nil {
+		log.For(ctx).Error("Error getting PR Request comments")
+		return nil, err
+	}
+
+	var comments []string
+	for _, comment := range giteaComments {
+		comments = append(comments, comment.Body)
+	}
+	return comments, nil
+}
+
+// GetPullRequestStatus returns the combined commit status of the head of the pull request
+func (gitea Gitea) GetPullRequestStatus(ctx context.Context, owner string, repo string, pullRequest PullRequest) (BuildStatus, error) {
+	var giteaPR giteaPullRequest
+	if err := gitea.sendApiRequest(ctx, "GET", fmt.Sprintf("%s/pulls/%d", gitea.repositoryPath(owner, repo), pullRequest.ID), nil, &giteaPR); err != nil {
+		return BuildNone, err
+	}
+
+	var combined struct {
+		State      string `json:"state"`
+		TotalCount int    `json:"total_count"`
+	}
+	if err := gitea.sendApiRequest(ctx, "GET", gitea.repositoryPath(owner, repo)+"/commits/"+url.PathEscape(giteaPR.Head.Sha)+"/status", nil, &combined); err != nil {
+		log.For(ctx).Error("Error getting PR statuses")
+		return BuildNone, err
+	}
+
+	if combined.TotalCount == 0 {
+		return BuildNone, nil
+	}
+	switch combined.State {
+	case "success":
+		return BuildSuccessful, nil
+	case "pending":
+		return BuildInProgress, nil
+	default:
+		return BuildFailed, nil
+	}
+}
+
+func (gitea Gitea) FindIssue(ctx context.Context, owner string, repo string, title string) (*Issue, error) {
+	query := url.Values{
+		"state": {"open"},
+		"type":  {"issues"},
+		"q":     {title},
+		"limit": {fmt.Sprint(giteaPageSize)},
+	}
+
+	received := 0
+	for page := 1; ; page++ {
+		query.Set("page", fmt.Sprint(page))
+
+		var issues []giteaIssue
+		header, err := gitea.sendRequest(ctx, "GET", gitea.repositoryPath(owner, repo)+"/issues?"+query.Encode(), nil, &issues)
+		if err != nil {
+			log.For(ctx).Error("Error searching Gitea Issues")
+			return nil, err
+		}
+		received += len(issues)
+		for _, issue := range issues {
+			if issue.Title == title {
+				return &Issue{ID: issue.Number, Title: issue.Title, Body: issue.Body}, nil
+			}
+		}
+		if len(issues) == 0 || !hasNextPage(header, received) {
+			return nil, nil
+		}
+	}
+}
+
+func (gitea Gitea) CreateIssue(ctx context.Context, owner string, repo string, title string, body string) error {
+	issue := map[string]interface{}{"title": title, "body": body}
+
+	if err := gitea.sendApiRequest(ctx, "POST", gitea.repositoryPath(owner, repo)+"/issues", issue, nil); err != nil {
+		log.For(ctx).Error("Error creating Gitea Issue")
+		return err
+	}
+	return nil
+}
+
+func (gitea Gitea) UpdateIssue(ctx context.Context, owner string, repo string, issueID int, body string) error {
+	issue := map[string]interface{}{"body": body}
+
+	if err := gitea.sendApiRequest(ctx, "PATCH", fmt.Sprintf("%s/issues/%d", gitea.repositoryPath(owner, repo), issueID), issue, nil); err != nil {
+		log.For(ctx).Error("Error updating Gitea Issue")
+		return err
+	}
+	return nil
+}
+
+// hasNextPage tells whether a list has more items than the received ones, from its X-Total-Count or Link header.
+// The server caps the pages to its MAX_RESPONSE_ITEMS, so a page smaller than the requested limit may not be the last.
+func hasNextPage(header http.Header, received int) bool {
+	if total, err := strconv.Atoi(header.Get("X-Total-Count")); err == nil {
+		return received < total
+	}
+	return strings.Contains(header.Get("Link"), `rel="next"`)
+}
+
+// sendApiRequest sends body encoded as json and decodes the response into result when it is not nil
+func (gitea Gitea) sendApiRequest(ctx context.Context, method string, path string, body interface{}, result interface{}) error {
+	_, err := gitea.sendRequest(ctx, method, path, body, result)
+	return err
+}
+
+// sendRequest sends an API request like sendApiRequest and returns the headers of the response
+func (gitea Gitea) sendRequest(ctx context.Context, method string, path string, body interface{}, result interface{}) (http.Header, error) {
+	var reader io.Reader
+	if body != nil {
+		buf := &bytes.Buffer{}
+		if err := json.NewEncoder(buf).Encode(body); err != nil {
+			return nil, err
+		}
+		reader = buf
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, gitea.apiURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Add("Content-Type", "application/json")
+	authenticateRequest(gitea.authentication, request)
+
+	client := getHTTPClient(gitea.transport)
+	resp, err := client.Do(request)
+	if err != nil {
+		log.For(ctx).Error(client.LogString())
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	log.For(ctx).Tracef("%s '%s' returned %d.", method, path, resp.StatusCode)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%s %s returned status code %s", method, path, resp.Status)
+	}
+
+	if result != nil {
+		return resp.Header, json.NewDecoder(resp.Body).Decode(result)
+	}
+	return resp.Header, nil
+}
@@ -0,0 +1,146 @@
+package repositorymanagementsystem_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/coveooss/lure/lib/lure/project"
+	managementsystem "github.com/coveooss/lure/lib/lure/repositorymanagementsystem"
+	"github.com/coveooss/lure/lib/lure/vcs"
+)
+
+const giteaRepositoryPath = "/api/v1/repos/lure/catfeeder"
+
+// giteaFixtures maps the requests to the responses recorded from a Gitea instance in testdata/gitea
+var giteaFixtures = map[string]string{
+	"GET /pulls":                         "pulls.json",
+	"POST /pulls":                        "pull_created.json",
+	"PATCH /pulls/12":                    "pull_12_closed.json",
+	"POST /pulls/12/requested_reviewers": "requested_reviewers.json",
+	"GET /branch_protections":            "branch_protections.json",
+}
+
+type fakeGitea struct {
+	*fakeAPI
+}
+
+func newFakeGitea(t *testing.T) *fakeGitea {
+	return &fakeGitea{newFakeAPI(t, giteaRepositoryPath, func(w http.ResponseWriter, r *http.Request, route string) {
+		fixture, ok := giteaFixtures[route]
+		if !ok {
+			t.Errorf("Unexpected request %s", route)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		content, err := ioutil.ReadFile(filepath.Join("testdata", "gitea", fixture))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(content)
+	})}
+}
+
+func (fake *fakeGitea) newGitea(t *testing.T) managementsystem.Gitea {
+	gitea, err := managementsystem.NewGitea(vcs.TokenAuth{Token: "secret"}, project.Project{
+		BaseURL: "https://git.example.com",
+		APIURL:  fake.server.URL + "/api/v1/",
+		Owner:   "lure",
+		Name:    "catfeeder",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gitea
+}
+
+func TestGiteaGetPullRequestsMapsClosedToDeclined(t *testing.T) {
+	fake := newFakeGitea(t)
+	defer fake.server.Close()
+
+	prs, err := fake.newGitea(t).GetPullRequests(context.Background(), "lure", "catfeeder", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(prs) != 2 {
+		t.Fatalf("Should have returned the open and declined pull requests, got %d", len(prs))
+	}
+	if prs[0].ID != 1 || prs[0].State != "OPEN" || prs[0].Source.GetName() != "lure-lodash-4.17.21" || prs[0].Labels[0] != "dependencies" {
+		t.Errorf("Unexpected first pull request %+v", prs[0])
+	}
+	if prs[1].ID != 2 || prs[1].State != "DECLINED" {
+		t.Errorf("Closed pull request should be declined %+v", prs[1])
+	}
+	if fake.queries["GET /pulls"] != "limit=50&page=1&state=all" {
+		t.Errorf("Unexpected query %s", fake.queries["GET /pulls"])
+	}
+	if fake.token(0) != "Bearer secret" {
+		t.Errorf("Should authenticate with the token, got '%s'", fake.token(0))
+	}
+}
+
+func TestGiteaCreatePullRequestWithDefaultReviewers(t *testing.T) {
+	fake := newFakeGitea(t)
+	defer fake.server.Close()
+
+	err := fake.newGitea(t).CreatePullRequest(context.Background(), "lure-lodash-4.17.21", "master", "lure", "catfeeder", "Update lodash to 4.17.21", "description", managementsystem.PullRequestOptions{UseDefaultReviewers: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := fake.received["POST /pulls"]
+	if body["head"] != "lure-lodash-4.17.21" || body["base"] != "master" || body["title"] != "Update lodash to 4.17.21" {
+		t.Errorf("Unexpected pull request %v", body)
+	}
+	if fmt.Sprint(fake.received["POST /pulls/12/requested_reviewers"]["reviewers"]) != "[reviewer other]" {
+		t.Errorf("Should request the approvers of master, got %v", fake.received["POST /pulls/12/requested_reviewers"])
+	}
+}
+
+func TestGiteaGetPullRequestsFollowsPagesCappedByTheServer(t *testing.T) {
+	content, err := ioutil.ReadFile(filepath.Join("testdata", "gitea", "pulls.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a server with MAX_RESPONSE_ITEMS=3 answers pages of 3 pull requests whatever the limit
+	fake := &fakeGitea{newFakeAPI(t, giteaRepositoryPath, func(w http.ResponseWriter, r *http.Request, route string) {
+		if page := r.URL.Query().Get("page"); route != "GET /pulls" || (page != "1" && page != "2") {
+			t.Errorf("Unexpected request %s?%s", route, r.URL.RawQuery)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("X-Total-Count", "6")
+		w.Write(content)
+	})}
+	defer fake.server.Close()
+
+	prs, err := fake.newGitea(t).GetPullRequests(context.Background(), "lure", "catfeeder", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(prs) != 4 || fake.queries["GET /pulls"] != "limit=50&page=2&state=all" {
+		t.Errorf("Should have read both pages, got %d pull requests up to %s", len(prs), fake.queries["GET /pulls"])
+	}
+}
+
+func TestGiteaSendsUsernameAndPasswordAsBasicAuth(t *testing.T) {
+	fake := newFakeGitea(t)
+	defer fake.server.Close()
+
+	gitea, err := managementsystem.NewGitea(vcs.UserPassAuth{Username: "lure", Password: "secret"}, project.Project{APIURL: fake.server.URL + "/api/v1", Owner: "lure", Name: "catfeeder"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gitea.DeclinePullRequest(context.Background(), "lure", "catfeeder", 12); err != nil {
+		t.Fatal(err)
+	}
+
+	if fake.token(0) != "Basic bHVyZTpzZWNyZXQ=" {
+		t.Errorf("Should have sent the username and password, got %q", fake.token(0))
+	}
+}
@@ -3,6 +3,11 @@ package repositorymanagementsystem
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
 	"github.com/coveooss/lure/lib/lure/log"
 	"github.com/coveooss/lure/lib/lure/project"
 	"github.com/coveooss/lure/lib/lure/vcs"
@@ -11,9 +16,14 @@ import (
 
 
 
+const defaultGitHubURL = "https://github.com"
+
 type GitHub struct {
 	URL            string
 	apiURL         string
+	uploadURL      string
+	reviewerTeams  []string
+	transport      http.RoundTripper
 	authentication vcs.Authentication
 }
 
@@ -22,111 +32,532 @@ func (gh GitHub) GetURL() string {
 	return gh.URL
 }
 
-func NewGitHub(authentication vcs.Authentication, project project.Project) GitHub {
+// NewGitHub returns the github.com provider, or the GitHub Enterprise Server one when baseURL or apiURL is set
+func NewGitHub(authentication vcs.Authentication, project project.Project) (GitHub, error) {
+	transport, err := NewTransport(project)
+	if err != nil {
+		return GitHub{}, err
+	}
+
+	baseURL := strings.TrimSuffix(project.BaseURL, "/")
+	if baseURL == "" && project.APIURL != "" {
+		// the enterprise API is served under /api/v3 of the server
+		baseURL = strings.TrimSuffix(strings.TrimSuffix(project.APIURL, "/"), "/api/v3")
+	}
+	if baseURL == "" {
+		baseURL = defaultGitHubURL
+	}
+
+	apiURL := project.APIURL
+	if apiURL == "" && project.BaseURL != "" {
+		apiURL = baseURL + "/api/v3/"
+	}
+	if _, err := url.Parse(apiURL); err != nil {
+		return GitHub{}, err
+	}
+
 	return GitHub{
-		URL:            "https://github.com/" + project.Owner + "/" + project.Name,
-		apiURL:         "https://api.github.com/" + project.Owner + "/" + project.Name,
+		URL:            baseURL + "/" + project.Owner + "/" + project.Name,
+		apiURL:         apiURL,
+		uploadURL:      strings.TrimSuffix(strings.TrimSuffix(apiURL, "/"), "/api/v3"),
+		reviewerTeams:  project.ReviewerTeams,
+		transport:      transport,
 		authentication: authentication,
+	}, nil
+}
+
+// newClient returns a client of the public API, or of the enterprise API when apiURL is set
+func (gh GitHub) newClient() (*github.Client, error) {
+	httpClient := gh.authentication.AuthenticateWithToken(gh.transport)
+	if gh.apiURL == "" {
+		return github.NewClient(httpClient), nil
 	}
+	return github.NewEnterpriseClient(gh.apiURL, gh.uploadURL, httpClient)
 }
 
 
-func (gh GitHub) CreatePullRequest(sourceBranch string, destBranch string, owner string, repo string, title string, description string, useDefaultReviewers bool) error {
-	httpClient := gh.authentication.AuthenticateWithToken()
-	client := github.NewClient(httpClient)
+func (gh GitHub) CreatePullRequest(ctx context.Context, sourceBranch string, destBranch string, owner string, repo string, title string, description string, options PullRequestOptions) error {
+	client, err := gh.newClient()
+	if err != nil {
+		return err
+	}
 
 	newPR := github.NewPullRequest{
 		Title:               &title,
 		Head:                &sourceBranch,
 		Base:                &destBranch,
 		Body:                &description,
+		Draft:               &options.Draft,
 	}
 
-	pr, _, err := client.PullRequests.Create(context.Background(), owner, repo, &newPR)
+	pr, _, err := client.PullRequests.Create(ctx, owner, repo, &newPR)
 
 	if err != nil {
-		log.Logger.Error("Error creating GitHub Pull Request")
-		log.Logger.Error(err)
+		log.For(ctx).Error("Error creating GitHub Pull Request")
+		log.For(ctx).Error(err)
 		return err
 	}
 
-	log.Logger.Info(fmt.Sprintf("Created Pull Request %x", *pr.Number))
+	log.For(ctx).Info(fmt.Sprintf("Created Pull Request %x", *pr.Number))
 
+	if options.UseDefaultReviewers {
+		gh.requestDefaultReviewers(ctx, client, owner, repo, destBranch, pr.GetNumber())
+	}
+
+	gh.editIssueOptions(ctx, client, owner, repo, pr.GetNumber(), options)
 	return nil
 }
 
-func (gh GitHub) GetPullRequests(username string, repoSlug string, ignoreDeclinedPRs bool) ([]PullRequest, error) {
-	httpClient := gh.authentication.AuthenticateWithToken()
-	client := github.NewClient(httpClient)
+// editIssueOptions sets the labels, assignees and milestone, which GitHub handles on the issue of the pull request.
+// Failing to do so does not fail the pull request.
+func (gh GitHub) editIssueOptions(ctx context.Context, client *github.Client, owner string, repo string, number int, options PullRequestOptions) {
+	if len(options.Labels) == 0 && len(options.Assignees) == 0 && options.Milestone == "" {
+		return
+	}
 
-	state := "open"
-	if !ignoreDeclinedPRs {
-		state = "all"
+	issue := github.IssueRequest{}
+	if len(options.Labels) > 0 {
+		issue.Labels = &options.Labels
+	}
+	if len(options.Assignees) > 0 {
+		issue.Assignees = &options.Assignees
+	}
+	if options.Milestone != "" {
+		milestone, err := gh.findMilestone(ctx, client, owner, repo, options.Milestone)
+		if err != nil {
+			log.For(ctx).Warnf("Could not find the milestone: %s", err)
+		} else {
+			issue.Milestone = &milestone
+		}
+	}
+
+	if _, _, err := client.Issues.Edit(ctx, owner, repo, number, &issue); err != nil {
+		log.For(ctx).Warnf("Could not set the labels, assignees and milestone: %s", err)
+	}
+}
+
+// findMilestone returns the number of the open milestone with the given title
+func (gh GitHub) findMilestone(ctx context.Context, client *github.Client, owner string, repo string, title string) (int, error) {
+	options := github.MilestoneListOptions{State: "open", ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		milestones, resp, err := client.Issues.ListMilestones(ctx, owner, repo, &options)
+		if err != nil {
+			return 0, err
+		}
+		for _, milestone := range milestones {
+			if milestone.GetTitle() == title {
+				return milestone.GetNumber(), nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return 0, fmt.Errorf("No open milestone named '%s'", title)
+		}
+		options.Page = resp.NextPage
+	}
+}
+
+// requestDefaultReviewers requests the configured reviewer teams, or the code owners of the changed files.
+// Failing to do so does not fail the pull request.
+func (gh GitHub) requestDefaultReviewers(ctx context.Context, client *github.Client, owner string, repo string, destBranch string, number int) {
+	reviewers := github.ReviewersRequest{TeamReviewers: gh.reviewerTeams}
+	if len(gh.reviewerTeams) == 0 {
+		var err error
+		if reviewers, err = gh.getCodeOwners(ctx, client, owner, repo, destBranch, number); err != nil {
+			log.For(ctx).Warnf("Could not get the code owners: %s", err)
+			return
+		}
+	}
+	if len(reviewers.Reviewers) == 0 && len(reviewers.TeamReviewers) == 0 {
+		return
+	}
+
+	if _, _, err := client.PullRequests.RequestReviewers(ctx, owner, repo, number, reviewers); err != nil {
+		log.For(ctx).Warnf("Could not request reviewers: %s", err)
+	}
+}
+
+// getCodeOwners returns the owners of the files changed by the pull request according to the CODEOWNERS of destBranch
+func (gh GitHub) getCodeOwners(ctx context.Context, client *github.Client, owner string, repo string, destBranch string, number int) (github.ReviewersRequest, error) {
+	var codeOwners codeOwners
+	for _, codeOwnersPath := range codeOwnersPaths {
+		content, _, resp, err := client.Repositories.GetContents(ctx, owner, repo, codeOwnersPath, &github.RepositoryContentGetOptions{Ref: destBranch})
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			continue
+		}
+		if err != nil {
+			return github.ReviewersRequest{}, err
+		}
+		decoded, err := content.GetContent()
+		if err != nil {
+			return github.ReviewersRequest{}, err
+		}
+		codeOwners = parseCodeOwners(decoded)
+		break
+	}
+	if len(codeOwners) == 0 {
+		return github.ReviewersRequest{}, nil
 	}
-	options := github.PullRequestListOptions{State: state}
-	prs, _, err := client.PullRequests.List(context.Background(), username, repoSlug, &options)
 
+	var owners []string
+	options := github.ListOptions{PerPage: 100}
+	for {
+		files, resp, err := client.PullRequests.ListFiles(ctx, owner, repo, number, &options)
+		if err != nil {
+			return github.ReviewersRequest{}, err
+		}
+		for _, file := range files {
+			owners = append(owners, codeOwners.ownersOf(file.GetFilename())...)
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		options.Page = resp.NextPage
+	}
+
+	reviewers := github.ReviewersRequest{}
+	seen := map[string]bool{}
+	for _, codeOwner := range owners {
+		if seen[codeOwner] || !strings.HasPrefix(codeOwner, "@") {
+			continue
+		}
+		seen[codeOwner] = true
+		if team := strings.SplitN(strings.TrimPrefix(codeOwner, "@"), "/", 2); len(team) == 2 {
+			reviewers.TeamReviewers = append(reviewers.TeamReviewers, team[1])
+		} else {
+			reviewers.Reviewers = append(reviewers.Reviewers, team[0])
+		}
+	}
+	return reviewers, nil
+}
+
+func (gh GitHub) GetPullRequests(ctx context.Context, username string, repoSlug string, ignoreDeclinedPRs bool) ([]PullRequest, error) {
+	client, err := gh.newClient()
 	if err != nil {
-		log.Logger.Error("Error listing GitHub Pull Requests")
-		log.Logger.Error(err)
 		return nil, err
 	}
 
-	var pullRequests []PullRequest
-	for _, pr := range prs {
-		options := github.ListOptions{Page: 1, PerPage: 100}
-		reviews, _, err := client.PullRequests.ListReviews(context.Background(), username, repoSlug, *pr.Number, &options)
+	state := "open"
+	if !ignoreDeclinedPRs {
+		state = "all"
+	}
+	options := github.PullRequestListOptions{State: state, ListOptions: github.ListOptions{PerPage: 100}}
 
+	var pullRequests []PullRequest
+	for {
+		prs, resp, err := client.PullRequests.List(ctx, username, repoSlug, &options)
 		if err != nil {
-			log.Logger.Error("Error getting GitHub Pull Request")
-			log.Logger.Error(err)
+			log.For(ctx).Error("Error listing GitHub Pull Requests")
+			log.For(ctx).Error(err)
 			return nil, err
 		}
 
-		var reviewers []user
-		for _, review := range reviews {
-			reviewers = append(reviewers, user{*review.User.Login})
+		for _, pr := range prs {
+			pullRequest := toPullRequest(pr)
+			if pullRequest.State == "MERGED" {
+				continue
+			}
+			pullRequests = append(pullRequests, pullRequest)
+		}
+
+		if resp.NextPage == 0 {
+			return pullRequests, nil
 		}
+		options.Page = resp.NextPage
+	}
+}
+
+// toPullRequest maps closed pull requests to MERGED or DECLINED. The reviewers are the requested ones,
+// listing the reviews of every pull request being too costly.
+func toPullRequest(pr *github.PullRequest) PullRequest {
+	state := "OPEN"
+	if pr.GetMergedAt() != (time.Time{}) {
+		state = "MERGED"
+	} else if pr.GetState() == "closed" {
+		state = "DECLINED"
+	}
+
+	var reviewers []user
+	for _, reviewer := range pr.RequestedReviewers {
+		reviewers = append(reviewers, user{reviewer.GetLogin()})
+	}
+
+	// the head repository is missing once the fork is deleted
+	headRepository := pr.GetHead().GetRepo()
+	fromFork := headRepository == nil || !strings.EqualFold(headRepository.GetFullName(), pr.GetBase().GetRepo().GetFullName())
 
-		pullRequests = append(pullRequests, PullRequest{
-			ID:                *pr.Number,
-			Title:             *pr.Title,
-			Description:       *pr.Body,
-			Source:            &source{
-				Branch: branch{
-					Name: *pr.Head.Ref,
-				},
+	return PullRequest{
+		ID:          pr.GetNumber(),
+		Title:       pr.GetTitle(),
+		Description: pr.GetBody(),
+		Metadata:    ParseMetadata(pr.GetBody()),
+		Source: &source{
+			Branch: branch{
+				Name: pr.GetHead().GetRef(),
 			},
-			Dest:              &dest{
-				Branch: branch{
-					Name: *pr.Base.Ref,
-				},
+		},
+		Dest: &dest{
+			Branch: branch{
+				Name: pr.GetBase().GetRef(),
 			},
-			CloseSourceBranch: true,
-			State:             "OPEN",
-			Reviewers:         reviewers,
-		})
+		},
+		CloseSourceBranch: true,
+		State:             state,
+		Reviewers:         reviewers,
+		Labels:            getLabelNames(pr.Labels),
+		MergeCommit:       pr.GetMergeCommitSHA(),
+		FromFork:          fromFork,
+		SourceCommit:      pr.GetHead().GetSHA(),
 	}
-	return pullRequests, nil
 }
 
-func (gh GitHub) DeclinePullRequest(username string, repoSlug string, pullRequestID int) error {
-	httpClient := gh.authentication.AuthenticateWithToken()
-	client := github.NewClient(httpClient)
+func (gh GitHub) DeclinePullRequest(ctx context.Context, username string, repoSlug string, pullRequestID int) error {
+	client, err := gh.newClient()
+	if err != nil {
+		return err
+	}
 
 	newState := "closed"
 	pull := github.PullRequest{
 		State: &newState,
 	}
-	pr, _, err := client.PullRequests.Edit(context.Background(), username, repoSlug, pullRequestID, &pull)
+	pr, _, err := client.PullRequests.Edit(ctx, username, repoSlug, pullRequestID, &pull)
+
+	if err != nil {
+		log.For(ctx).Error("Error editing GitHub Pull Request")
+		log.For(ctx).Error(err)
+		return err
+	}
+
+	log.For(ctx).Info(fmt.Sprintf("Closed PR number %x", *pr.Number))
+
+	return nil
+}
+func (gh GitHub) GetPullRequestStatus(ctx context.Context, username string, repoSlug string, pullRequest PullRequest) (BuildStatus, error) {
+	client, err := gh.newClient()
+	if err != nil {
+		return BuildNone, err
+	}
+
+	// the statuses of the head commit, as the branch of a fork may have the name of a branch of the repository
+	ref := pullRequest.SourceCommit
+	if ref == "" {
+		return BuildNone, fmt.Errorf("The head commit of PR %d is unknown", pullRequest.ID)
+	}
+	var statuses []BuildStatus
+
+	combinedStatus, _, err := client.Repositories.GetCombinedStatus(ctx, username, repoSlug, ref, &github.ListOptions{PerPage: 100})
+	if err != nil {
+		log.For(ctx).Error("Error getting GitHub commit status")
+		log.For(ctx).Error(err)
+		return BuildNone, err
+	}
+	for _, status := range combinedStatus.Statuses {
+		switch status.GetState() {
+		case "success":
+			statuses = append(statuses, BuildSuccessful)
+		case "pending":
+			statuses = append(statuses, BuildInProgress)
+		default:
+			statuses = append(statuses, BuildFailed)
+		}
+	}
+
+	checkRuns, _, err := client.Checks.ListCheckRunsForRef(ctx, username, repoSlug, ref, &github.ListCheckRunsOptions{ListOptions: github.ListOptions{PerPage: 100}})
+	if err != nil {
+		log.For(ctx).Error("Error listing GitHub check runs")
+		log.For(ctx).Error(err)
+		return BuildNone, err
+	}
+	for _, checkRun := range checkRuns.CheckRuns {
+		if checkRun.GetStatus() != "completed" {
+			statuses = append(statuses, BuildInProgress)
+			continue
+		}
+		switch checkRun.GetConclusion() {
+		case "success", "neutral", "skipped":
+			statuses = append(statuses, BuildSuccessful)
+		default:
+			statuses = append(statuses, BuildFailed)
+		}
+	}
 
+	return aggregateBuildStatus(statuses), nil
+}
+
+func (gh GitHub) MergePullRequest(ctx context.Context, username string, repoSlug string, pullRequestID int) error {
+	client, err := gh.newClient()
 	if err != nil {
-		log.Logger.Error("Error editing GitHub Pull Request")
-		log.Logger.Error(err)
-		return nil
+		return err
 	}
 
-	log.Logger.Info(fmt.Sprintf("Closed PR number %x", *pr.Number))
+	result, _, err := client.PullRequests.Merge(ctx, username, repoSlug, pullRequestID, "", &github.PullRequestOptions{MergeMethod: "merge"})
+	if err != nil {
+		log.For(ctx).Error("Error merging GitHub Pull Request")
+		log.For(ctx).Error(err)
+		return err
+	}
+
+	log.For(ctx).Info(fmt.Sprintf("Merged PR number %d: %s", pullRequestID, result.GetMessage()))
 
 	return nil
-}
\ No newline at end of file
+}
+
+// FindIssue returns the open issue with the given title, nil if there is none
+func (gh GitHub) FindIssue(ctx context.Context, username string, repoSlug string, title string) (*Issue, error) {
+	client, err := gh.newClient()
+	if err != nil {
+		return nil, err
+	}
+
+	options := github.IssueListByRepoOptions{State: "open", ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		issues, resp, err := client.Issues.ListByRepo(ctx, username, repoSlug, &options)
+		if err != nil {
+			log.For(ctx).Error("Error listing GitHub Issues")
+			log.For(ctx).Error(err)
+			return nil, err
+		}
+
+		for _, issue := range issues {
+			if !issue.IsPullRequest() && issue.GetTitle() == title {
+				return &Issue{
+					ID:    issue.GetNumber(),
+					Title: issue.GetTitle(),
+					Body:  issue.GetBody(),
+				}, nil
+			}
+		}
+
+		if resp.NextPage == 0 {
+			return nil, nil
+		}
+		options.Page = resp.NextPage
+	}
+}
+
+func (gh GitHub) CreateIssue(ctx context.Context, username string, repoSlug string, title string, body string) error {
+	client, err := gh.newClient()
+	if err != nil {
+		return err
+	}
+
+	issue, _, err := client.Issues.Create(ctx, username, repoSlug, &github.IssueRequest{Title: &title, Body: &body})
+	if err != nil {
+		log.For(ctx).Error("Error creating GitHub Issue")
+		log.For(ctx).Error(err)
+		return err
+	}
+
+	log.For(ctx).Info(fmt.Sprintf("Created Issue %d", issue.GetNumber()))
+
+	return nil
+}
+
+func (gh GitHub) UpdateIssue(ctx context.Context, username string, repoSlug string, issueID int, body string) error {
+	client, err := gh.newClient()
+	if err != nil {
+		return err
+	}
+
+	if _, _, err := client.Issues.Edit(ctx, username, repoSlug, issueID, &github.IssueRequest{Body: &body}); err != nil {
+		log.For(ctx).Error("Error editing GitHub Issue")
+		log.For(ctx).Error(err)
+		return err
+	}
+
+	log.For(ctx).Info(fmt.Sprintf("Updated Issue %d", issueID))
+
+	return nil
+}
+
+func (gh GitHub) UpdatePullRequest(ctx context.Context, username string, repoSlug string, pullRequestID int, title string, description string) error {
+	client, err := gh.newClient()
+	if err != nil {
+		return err
+	}
+
+	pull := github.PullRequest{
+		Title: &title,
+		Body:  &description,
+	}
+	if _, _, err := client.PullRequests.Edit(ctx, username, repoSlug, pullRequestID, &pull); err != nil {
+		log.For(ctx).Error("Error editing GitHub Pull Request")
+		log.For(ctx).Error(err)
+		return err
+	}
+
+	log.For(ctx).Info(fmt.Sprintf("Updated PR number %d", pullRequestID))
+
+	return nil
+}
+
+// GetMergedPullRequests returns the 100 most recently updated pull requests merged into destBranch
+func (gh GitHub) GetMergedPullRequests(ctx context.Context, username string, repoSlug string, destBranch string) ([]PullRequest, error) {
+	client, err := gh.newClient()
+	if err != nil {
+		return nil, err
+	}
+
+	options := github.PullRequestListOptions{State: "closed", Base: destBranch, Sort: "updated", Direction: "desc", ListOptions: github.ListOptions{PerPage: 100}}
+	prs, _, err := client.PullRequests.List(ctx, username, repoSlug, &options)
+	if err != nil {
+		log.For(ctx).Error("Error listing GitHub Pull Requests")
+		log.For(ctx).Error(err)
+		return nil, err
+	}
+
+	var pullRequests []PullRequest
+	for _, pr := range prs {
+		if pullRequest := toPullRequest(pr); pullRequest.State == "MERGED" {
+			pullRequests = append(pullRequests, pullRequest)
+		}
+	}
+	return pullRequests, nil
+}
+
+func (gh GitHub) CommentPullRequest(ctx context.Context, username string, repoSlug string, pullRequestID int, comment string) error {
+	client, err := gh.newClient()
+	if err != nil {
+		return err
+	}
+
+	if _, _, err := client.Issues.CreateComment(ctx, username, repoSlug, pullRequestID, &github.IssueComment{Body: &comment}); err != nil {
+		log.For(ctx).Error("Error commenting GitHub Pull Request")
+		log.For(ctx).Error(err)
+		return err
+	}
+	return nil
+}
+
+func (gh GitHub) GetPullRequestComments(ctx context.Context, username string, repoSlug string, pullRequestID int) ([]string, error) {
+	client, err := gh.newClient()
+	if err != nil {
+		return nil, err
+	}
+
+	options := github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	var comments []string
+	for {
+		issueComments, resp, err := client.Issues.ListComments(ctx, username, repoSlug, pullRequestID, &options)
+		if err != nil {
+			log.For(ctx).Error("Error listing GitHub Pull Request comments")
+			log.For(ctx).Error(err)
+			return nil, err
+		}
+		for _, comment := range issueComments {
+			comments = append(comments, comment.GetBody())
+		}
+		if resp.NextPage == 0 {
+			return comments, nil
+		}
+		options.Page = resp.NextPage
+	}
+}
+
+func getLabelNames(labels []*github.Label) []string {
+	var names []string
+	for _, label := range labels {
+		names = append(names, label.GetName())
+	}
+	return names
+}
@@ -0,0 +1,302 @@
+package repositorymanagementsystem_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coveooss/lure/lib/lure/project"
+	managementsystem "github.com/coveooss/lure/lib/lure/repositorymanagementsystem"
+	"github.com/coveooss/lure/lib/lure/vcs"
+)
+
+func TestGitHubGetURLDefaultsToGitHubDotCom(t *testing.T) {
+	gh, err := managementsystem.NewGitHub(vcs.TokenAuth{}, project.Project{Owner: "coveooss", Name: "lure"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if gh.GetURL() != "https://github.com/coveooss/lure" {
+		t.Errorf("Unexpected URL %s", gh.GetURL())
+	}
+}
+
+func TestGitHubGetURLUsesBaseURL(t *testing.T) {
+	gh, err := managementsystem.NewGitHub(vcs.TokenAuth{}, project.Project{BaseURL: "https://github.corp/", Owner: "coveo", Name: "lure"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if gh.GetURL() != "https://github.corp/coveo/lure" {
+		t.Errorf("Unexpected URL %s", gh.GetURL())
+	}
+}
+
+func TestGitHubGetURLUsesTheServerOfTheAPI(t *testing.T) {
+	gh, err := managementsystem.NewGitHub(vcs.TokenAuth{}, project.Project{APIURL: "https://github.corp/api/v3/", Owner: "coveo", Name: "lure"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if gh.GetURL() != "https://github.corp/coveo/lure" {
+		t.Errorf("Should clone from the server of the API, got %s", gh.GetURL())
+	}
+}
+
+func TestGitHubUsesEnterpriseAPITrustingCACertificates(t *testing.T) {
+	var paths []string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		fmt.Fprint(w, `[]`)
+	}))
+	defer server.Close()
+
+	directory, err := ioutil.TempDir("", "lure-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	certificatePath := filepath.Join(directory, "ca.pem")
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(certificatePath, certificate, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	gh, err := managementsystem.NewGitHub(vcs.TokenAuth{Token: "secret"}, project.Project{
+		BaseURL:        "https://github.corp",
+		APIURL:         server.URL,
+		CACertificates: []string{certificatePath},
+		Owner:          "coveo",
+		Name:           "lure",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := gh.GetPullRequests(context.Background(), "coveo", "lure", true); err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 1 || paths[0] != "/api/v3/repos/coveo/lure/pulls" {
+		t.Errorf("Should have called the enterprise API, got %v", paths)
+	}
+}
+
+func TestNewGitHubFailsOnMissingCACertificates(t *testing.T) {
+	_, err := managementsystem.NewGitHub(vcs.TokenAuth{}, project.Project{CACertificates: []string{"does-not-exist.pem"}, Owner: "coveo", Name: "lure"})
+
+	if err == nil {
+		t.Error("Should have failed reading the CA certificates")
+	}
+}
+
+const gitHubRepositoryPath = "/api/v3/repos/coveo/lure"
+
+type fakeGitHub struct {
+	*fakeAPI
+	codeOwners string
+}
+
+func newFakeGitHub(t *testing.T) *fakeGitHub {
+	fake := &fakeGitHub{}
+
+	fake.fakeAPI = newFakeAPI(t, gitHubRepositoryPath, func(w http.ResponseWriter, r *http.Request, route string) {
+		switch route {
+		case "GET /pulls":
+			if r.URL.Query().Get("page") == "" {
+				w.Header().Set("Link", fmt.Sprintf(`<%s%s/pulls?page=2>; rel="next"`, fake.server.URL, gitHubRepositoryPath))
+				fmt.Fprint(w, `[{"number": 1, "state": "open", "head": {"ref": "lure-a"}, "base": {"ref": "master"}, "requested_reviewers": [{"login": "reviewer"}]}]`)
+			} else {
+				fmt.Fprint(w, `[
+					{"number": 2, "state": "closed", "head": {"ref": "lure-b"}, "base": {"ref": "master"}},
+					{"number": 3, "state": "closed", "merged_at": "2020-10-01T12:00:00Z", "merge_commit_sha": "abc", "head": {"ref": "lure-c"}, "base": {"ref": "master"}}
+				]`)
+			}
+		case "POST /pulls":
+			fmt.Fprint(w, `{"number": 12}`)
+		case "GET /contents/CODEOWNERS":
+			if fake.codeOwners == "" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			fmt.Fprintf(w, `{"type": "file", "encoding": "base64", "content": "%s"}`, base64.StdEncoding.EncodeToString([]byte(fake.codeOwners)))
+		case "GET /contents/.github/CODEOWNERS", "GET /contents/docs/CODEOWNERS":
+			w.WriteHeader(http.StatusNotFound)
+		case "GET /pulls/12/files":
+			fmt.Fprint(w, `[{"filename": "package.json"}, {"filename": "docs/api/readme.md"}, {"filename": "ui/package.json"}]`)
+		case "POST /pulls/12/requested_reviewers":
+			fmt.Fprint(w, `{"number": 12}`)
+		case "GET /milestones":
+			fmt.Fprint(w, `[{"number": 3, "title": "Q3"}, {"number": 4, "title": "Q4"}]`)
+		case "PATCH /issues/12":
+			fmt.Fprint(w, `{"number": 12}`)
+		case "PATCH /pulls/12":
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			fmt.Fprint(w, `{"message": "Validation Failed"}`)
+		default:
+			t.Errorf("Unexpected request %s", route)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	return fake
+}
+
+func (fake *fakeGitHub) newGitHub(t *testing.T, reviewerTeams ...string) managementsystem.GitHub {
+	gh, err := managementsystem.NewGitHub(vcs.TokenAuth{Token: "secret"}, project.Project{APIURL: fake.server.URL, Owner: "coveo", Name: "lure", ReviewerTeams: reviewerTeams})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gh
+}
+
+func TestGitHubGetPullRequestsPagesAndMapsStates(t *testing.T) {
+	fake := newFakeGitHub(t)
+	defer fake.server.Close()
+
+	prs, err := fake.newGitHub(t).GetPullRequests(context.Background(), "coveo", "lure", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(prs) != 2 {
+		t.Fatalf("Should have returned the open and declined pull requests of both pages, got %d", len(prs))
+	}
+	if prs[0].ID != 1 || prs[0].State != "OPEN" || prs[0].Reviewers[0].Uuid != "reviewer" {
+		t.Errorf("Unexpected open pull request %+v", prs[0])
+	}
+	if prs[1].ID != 2 || prs[1].State != "DECLINED" {
+		t.Errorf("Closed pull request should be declined %+v", prs[1])
+	}
+}
+
+func TestGitHubCreatePullRequestRequestsCodeOwners(t *testing.T) {
+	fake := newFakeGitHub(t)
+	defer fake.server.Close()
+	fake.codeOwners = "# Owners\n* @coveo/platform\n/docs/ @writer docs@coveo.com\nui/ @coveo/frontend @dev\n"
+
+	if err := fake.newGitHub(t).CreatePullRequest(context.Background(), "lure-a", "master", "coveo", "lure", "Update a", "description", managementsystem.PullRequestOptions{UseDefaultReviewers: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	requested := fake.received["POST /pulls/12/requested_reviewers"]
+	if fmt.Sprint(requested["reviewers"]) != "[writer dev]" || fmt.Sprint(requested["team_reviewers"]) != "[platform frontend]" {
+		t.Errorf("Should have requested the code owners of the changed files, got %v", requested)
+	}
+}
+
+func TestGitHubCreatePullRequestRequestsConfiguredTeams(t *testing.T) {
+	fake := newFakeGitHub(t)
+	defer fake.server.Close()
+	fake.codeOwners = "* @coveo/platform\n"
+
+	if err := fake.newGitHub(t, "dependencies").CreatePullRequest(context.Background(), "lure-a", "master", "coveo", "lure", "Update a", "description", managementsystem.PullRequestOptions{UseDefaultReviewers: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	requested := fake.received["POST /pulls/12/requested_reviewers"]
+	if fmt.Sprint(requested["team_reviewers"]) != "[dependencies]" {
+		t.Errorf("Should have requested the configured teams, got %v", requested)
+	}
+}
+
+func TestGitHubCreatePullRequestWithoutDefaultReviewers(t *testing.T) {
+	fake := newFakeGitHub(t)
+	defer fake.server.Close()
+	fake.codeOwners = "* @coveo/platform\n"
+
+	if err := fake.newGitHub(t).CreatePullRequest(context.Background(), "lure-a", "master", "coveo", "lure", "Update a", "description", managementsystem.PullRequestOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, requested := fake.received["POST /pulls/12/requested_reviewers"]; requested {
+		t.Error("Should not have requested reviewers")
+	}
+}
+
+func TestGitHubDeclinePullRequestReturnsErrors(t *testing.T) {
+	fake := newFakeGitHub(t)
+	defer fake.server.Close()
+
+	if err := fake.newGitHub(t).DeclinePullRequest(context.Background(), "coveo", "lure", 12); err == nil {
+		t.Error("Should have returned the error of GitHub")
+	}
+	if fake.received["PATCH /pulls/12"]["state"] != "closed" {
+		t.Errorf("Should have closed the pull request, got %v", fake.received)
+	}
+}
+
+func TestGitHubCreatePullRequestAppliesOptions(t *testing.T) {
+	fake := newFakeGitHub(t)
+	defer fake.server.Close()
+
+	options := managementsystem.PullRequestOptions{Labels: []string{"dependencies"}, Assignees: []string{"owner"}, Milestone: "Q4", Draft: true}
+	if err := fake.newGitHub(t).CreatePullRequest(context.Background(), "lure-a", "master", "coveo", "lure", "Update a", "description", options); err != nil {
+		t.Fatal(err)
+	}
+
+	if fake.received["POST /pulls"]["draft"] != true {
+		t.Errorf("Should have opened a draft, got %v", fake.received["POST /pulls"])
+	}
+	issue := fake.received["PATCH /issues/12"]
+	if fmt.Sprint(issue["labels"]) != "[dependencies]" || fmt.Sprint(issue["assignees"]) != "[owner]" || issue["milestone"] != float64(4) {
+		t.Errorf("Unexpected labels, assignees or milestone %v", issue)
+	}
+}
+
+func TestGitHubCreatePullRequestKeepsThePullRequestWhenItsOptionsFail(t *testing.T) {
+	fake := newFakeGitHub(t)
+	defer fake.server.Close()
+
+	options := managementsystem.PullRequestOptions{Labels: []string{"dependencies"}, Milestone: "Q5"}
+	if err := fake.newGitHub(t).CreatePullRequest(context.Background(), "lure-a", "master", "coveo", "lure", "Update a", "description", options); err != nil {
+		t.Errorf("Should not fail the created pull request: %s", err)
+	}
+
+	if fmt.Sprint(fake.received["PATCH /issues/12"]["labels"]) != "[dependencies]" {
+		t.Errorf("Should still have set the labels, got %v", fake.received["PATCH /issues/12"])
+	}
+}
+
+func TestGitHubShouldTellForksAndCheckTheirHeadCommit(t *testing.T) {
+	fake := newFakeAPI(t, gitHubRepositoryPath, func(w http.ResponseWriter, r *http.Request, route string) {
+		switch route {
+		case "GET /pulls":
+			fmt.Fprint(w, `[
+				{"number": 1, "state": "open", "head": {"ref": "lure-a", "sha": "1a2b3c", "repo": {"full_name": "coveo/lure"}}, "base": {"ref": "master", "repo": {"full_name": "coveo/lure"}}},
+				{"number": 2, "state": "open", "head": {"ref": "lure-a", "sha": "4d5e6f", "repo": {"full_name": "outsider/lure"}}, "base": {"ref": "master", "repo": {"full_name": "coveo/lure"}}}
+			]`)
+		case "GET /commits/4d5e6f/status":
+			fmt.Fprint(w, `{"statuses": [{"state": "failure"}]}`)
+		case "GET /commits/4d5e6f/check-runs":
+			fmt.Fprint(w, `{"check_runs": [{"status": "completed", "conclusion": "success"}]}`)
+		default:
+			t.Errorf("Unexpected request %s", route)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	defer fake.server.Close()
+
+	gh, err := managementsystem.NewGitHub(vcs.TokenAuth{Token: "secret"}, project.Project{APIURL: fake.server.URL, Owner: "coveo", Name: "lure"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prs, err := gh.GetPullRequests(context.Background(), "coveo", "lure", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prs) != 2 || prs[0].FromFork || !prs[1].FromFork {
+		t.Fatalf("Should have told the pull request of the fork, got %+v", prs)
+	}
+
+	status, err := gh.GetPullRequestStatus(context.Background(), "coveo", "lure", prs[1])
+	if err != nil || status != managementsystem.BuildFailed {
+		t.Errorf("Should have checked the head commit of the fork rather than the branch of the same name, got %s %v", status, err)
+	}
+}
@@ -0,0 +1,405 @@
+package repositorymanagementsystem
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/coveooss/lure/lib/lure/log"
+	"github.com/coveooss/lure/lib/lure/project"
+	"github.com/coveooss/lure/lib/lure/vcs"
+)
+
+const defaultGitLabURL = "https://gitlab.com"
+
+type GitLab struct {
+	URL            string
+	apiURL         string
+	authentication vcs.Authentication
+	transport      http.RoundTripper
+}
+
+type gitLabUser struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+}
+
+type gitLabPipeline struct {
+	Status string `json:"status"`
+}
+
+type gitLabMergeRequest struct {
+	IID            int             `json:"iid"`
+	Title          string          `json:"title"`
+	Description    string          `json:"description"`
+	SourceBranch   string          `json:"source_branch"`
+	TargetBranch   string          `json:"target_branch"`
+	State          string          `json:"state"`
+	Labels         []string        `json:"labels"`
+	Reviewers      []gitLabUser    `json:"reviewers"`
+	MergeCommitSHA string          `json:"merge_commit_sha"`
+	SquashSHA      string          `json:"squash_commit_sha"`
+	HeadPipeline   *gitLabPipeline `json:"head_pipeline"`
+}
+
+type gitLabIssue struct {
+	IID         int    `json:"iid"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+type gitLabNote struct {
+	Body string `json:"body"`
+}
+
+// NewGitLab creates a GitLab provider for gitlab.com or, with the project baseURL, a self-hosted instance
+func NewGitLab(authentication vcs.Authentication, project project.Project) (GitLab, error) {
+	transport, err := NewTransport(project)
+	if err != nil {
+		return GitLab{}, err
+	}
+
+	baseURL := strings.TrimSuffix(project.BaseURL, "/")
+	if baseURL == "" {
+		baseURL = defaultGitLabURL
+	}
+
+	return GitLab{
+		URL:            baseURL + "/" + project.Owner + "/" + project.Name,
+		apiURL:         baseURL + "/api/v4",
+		authentication: authentication,
+		transport:      transport,
+	}, nil
+}
+
+func (gitlab GitLab) GetURL() string {
+	return gitlab.URL
+}
+
+// projectPath returns the url encoded project path, nested groups included (group%2Fsubgroup%2Fname)
+func projectPath(owner string, repo string) string {
+	return url.PathEscape(owner + "/" + repo)
+}
+
+func (gitlab GitLab) CreatePullRequest(ctx context.Context, sourceBranch string, destBranch string, owner string, repo string, title string, description string, options PullRequestOptions) error {
+	mergeRequest := map[string]interface{}{
+		"source_branch":        sourceBranch,
+		"target_branch":        destBranch,
+		"title":                title,
+		"description":          description,
+		"remove_source_branch": true,
+	}
+
+	if options.UseDefaultReviewers {
+		reviewers, err := gitlab.getDefaultReviewers(ctx, owner, repo)
+		if err != nil {
+			log.For(ctx).Warnf("Could not get default approvers: %s", err)
+		}
+		var reviewerIDs []int
+		for _, reviewer := range reviewers {
+			reviewerIDs = append(reviewerIDs, reviewer.ID)
+		}
+		if len(reviewerIDs) > 0 {
+			mergeRequest["reviewer_ids"] = reviewerIDs
+		}
+	}
+
+	var created gitLabMergeRequest
+	if _, err := gitlab.sendApiRequest(ctx, "POST", fmt.Sprintf("/projects/%s/merge_requests", projectPath(owner, repo)), mergeRequest, &created); err != nil {
+		log.For(ctx).Error("Error creating GitLab Merge Request")
+		return err
+	}
+
+	log.For(ctx).Infof("Created Merge Request !%d", created.IID)
+
+	return nil
+}
+
+// getDefaultReviewers returns the eligible approvers of the project approval rules
+func (gitlab GitLab) getDefaultReviewers(ctx context.Context, owner string, repo string) ([]gitLabUser, error) {
+	var rules []struct {
+		EligibleApprovers []gitLabUser `json:"eligible_approvers"`
+	}
+	if _, err := gitlab.sendApiRequest(ctx, "GET", fmt.Sprintf("/projects/%s/approval_rules", projectPath(owner, repo)), nil, &rules); err != nil {
+		return nil, err
+	}
+
+	var reviewers []gitLabUser
+	seen := map[int]bool{}
+	for _, rule := range rules {
+		for _, approver := range rule.EligibleApprovers {
+			if !seen[approver.ID] {
+				seen[approver.ID] = true
+				reviewers = append(reviewers, approver)
+			}
+		}
+	}
+	return reviewers, nil
+}
+
+func (gitlab GitLab) GetPullRequests(ctx context.Context, owner string, repo string, ignoreDeclinedPRs bool) ([]PullRequest, error) {
+	log.For(ctx).Info("Retrieving merge requests")
+
+	state := "all"
+	if ignoreDeclinedPRs {
+		state = "opened"
+	}
+
+	mergeRequests, err := gitlab.listMergeRequests(ctx, owner, repo, url.Values{"state": {state}})
+	if err != nil {
+		return nil, err
+	}
+
+	var pullRequests []PullRequest
+	for _, mergeRequest := range mergeRequests {
+		pullRequest := mergeRequest.toPullRequest()
+		if pullRequest.State == "MERGED" {
+			continue
+		}
+		pullRequests = append(pullRequests, pullRequest)
+	}
+	log.For(ctx).Infof("Found %d MRs.", len(pullRequests))
+
+	return pullRequests, nil
+}
+
+// GetMergedPullRequests returns the 100 most recently updated merge requests merged into destBranch
+func (gitlab GitLab) GetMergedPullRequests(ctx context.Context, owner string, repo string, destBranch string) ([]PullRequest, error) {
+	query := url.Values{
+		"state":         {"merged"},
+		"target_branch": {destBranch},
+		"order_by":      {"updated_at"},
+		"sort":          {"desc"},
+		"per_page":      {"100"},
+	}
+
+	var mergeRequests []gitLabMergeRequest
+	if _, err := gitlab.sendApiRequest(ctx, "GET", fmt.Sprintf("/projects/%s/merge_requests?%s", projectPath(owner, repo), query.Encode()), nil, &mergeRequests); err != nil {
+		log.For(ctx).Error("Error listing GitLab Merge Requests")
+		return nil, err
+	}
+
+	var pullRequests []PullRequest
+	for _, mergeRequest := range mergeRequests {
+		pullRequests = append(pullRequests, mergeRequest.toPullRequest())
+	}
+	return pullRequests, nil
+}
+
+func (gitlab GitLab) listMergeRequests(ctx context.Context, owner string, repo string, query url.Values) ([]gitLabMergeRequest, error) {
+	query.Set("per_page", "100")
+
+	var mergeRequests []gitLabMergeRequest
+	for page := "1"; page != ""; {
+		query.Set("page", page)
+
+		var pageMergeRequests []gitLabMergeRequest
+		header, err := gitlab.sendApiRequest(ctx, "GET", fmt.Sprintf("/projects/%s/merge_requests?%s", projectPath(owner, repo), query.Encode()), nil, &pageMergeRequests)
+		if err != nil {
+			log.For(ctx).Error("Error listing GitLab Merge Requests")
+			return nil, err
+		}
+		mergeRequests = append(mergeRequests, pageMergeRequests...)
+		page = header.Get("X-Next-Page")
+	}
+	return mergeRequests, nil
+}
+
+func (mergeRequest gitLabMergeRequest) toPullRequest() PullRequest {
+	var state string
+	switch mergeRequest.State {
+	case "opened", "locked":
+		state = "OPEN"
+	case "merged":
+		state = "MERGED"
+	default:
+		state = "DECLINED"
+	}
+
+	var reviewers []user
+	for _, reviewer := range mergeRequest.Reviewers {
+		reviewers = append(reviewers, user{strconv.Itoa(reviewer.ID)})
+	}
+
+	mergeCommit := mergeRequest.MergeCommitSHA
+	if mergeCommit == "" {
+		mergeCommit = mergeRequest.SquashSHA
+	}
+
+	return PullRequest{
+		ID:          mergeRequest.IID,
+		Title:       mergeRequest.Title,
+		Description: mergeRequest.Description,
+		Metadata:    ParseMetadata(mergeRequest.Description),
+		Source: &source{
+			Branch: branch{
+				Name: mergeRequest.SourceBranch,
+			},
+		},
+		Dest: &dest{
+			Branch: branch{
+				Name: mergeRequest.TargetBranch,
+			},
+		},
+		CloseSourceBranch: true,
+		State:             state,
+		Reviewers:         reviewers,
+		Labels:            mergeRequest.Labels,
+		MergeCommit:       mergeCommit,
+	}
+}
+
+func (gitlab GitLab) DeclinePullRequest(ctx context.Context, owner string, repo string, pullRequestID int) error {
+	if _, err := gitlab.sendApiRequest(ctx, "PUT", fmt.Sprintf("/projects/%s/merge_requests/%d", projectPath(owner, repo), pullRequestID), map[string]string{"state_event": "close"}, nil); err != nil {
+		log.For(ctx).Error("Error closing GitLab Merge Request")
+		return err
+	}
+
+	log.For(ctx).Infof("Closed MR !%d", pullRequestID)
+
+	return nil
+}
+
+func (gitlab GitLab) UpdatePullRequest(ctx context.Context, owner string, repo string, pullRequestID int, title string, description string) error {
+	if _, err := gitlab.sendApiRequest(ctx, "PUT", fmt.Sprintf("/projects/%s/merge_requests/%d", projectPath(owner, repo), pullRequestID), map[string]string{"title": title, "description": description}, nil); err != nil {
+		log.For(ctx).Error("Error updating GitLab Merge Request")
+		return err
+	}
+	return nil
+}
+
+func (gitlab GitLab) CommentPullRequest(ctx context.Context, owner string, repo string, pullRequestID int, comment string) error {
+	if _, err := gitlab.sendApiRequest(ctx, "POST", fmt.Sprintf("/projects/%s/merge_requests/%d/notes", projectPath(owner, repo), pullRequestID), gitLabNote{Body: comment}, nil); err != nil {
+		log.For(ctx).Error("Error commenting GitLab Merge Request")
+		return err
+	}
+	return nil
+}
+
+func (gitlab GitLab) GetPullRequestComments(ctx context.Context, owner string, repo string, pullRequestID int) ([]string, error) {
+	var notes []gitLabNote
+	if _, err := gitlab.sendApiRequest(ctx, "GET", fmt.Sprintf("/projects/%s/merge_requests/%d/notes?per_page=100", projectPath(owner, repo), pullRequestID), nil, &notes); err != nil {
+		log.For(ctx).Error("Error listing GitLab Merge Request notes")
+		return nil, err
+	}
+
+	var comments []string
+	for _, note := range notes {
+		comments = append(comments, note.Body)
+	}
+	return comments, nil
+}
+
+// GetPullRequestStatus returns the status of the merge request head pipeline
+func (gitlab GitLab) GetPullRequestStatus(ctx context.Context, owner string, repo string, pullRequest PullRequest) (BuildStatus, error) {
+	var mergeRequest gitLabMergeRequest
+	if _, err := gitlab.sendApiRequest(ctx, "GET", fmt.Sprintf("/projects/%s/merge_requests/%d", projectPath(owner, repo), pullRequest.ID), nil, &mergeRequest); err != nil {
+		log.For(ctx).Error("Error getting GitLab Merge Request")
+		return BuildNone, err
+	}
+
+	if mergeRequest.HeadPipeline == nil {
+		return BuildNone, nil
+	}
+	switch mergeRequest.HeadPipeline.Status {
+	case "success":
+		return BuildSuccessful, nil
+	case "failed", "canceled", "skipped":
+		return BuildFailed, nil
+	default:
+		return BuildInProgress, nil
+	}
+}
+
+func (gitlab GitLab) MergePullRequest(ctx context.Context, owner string, repo string, pullRequestID int) error {
+	if _, err := gitlab.sendApiRequest(ctx, "PUT", fmt.Sprintf("/projects/%s/merge_requests/%d/merge", projectPath(owner, repo), pullRequestID), map[string]bool{"should_remove_source_branch": true}, nil); err != nil {
+		log.For(ctx).Error("Error merging GitLab Merge Request")
+		return err
+	}
+
+	log.For(ctx).Infof("Merged MR !%d", pullRequestID)
+
+	return nil
+}
+
+// FindIssue returns the open issue with the given title, nil if there is none
+func (gitlab GitLab) FindIssue(ctx context.Context, owner string, repo string, title string) (*Issue, error) {
+	query := url.Values{"state": {"opened"}, "search": {title}, "in": {"title"}, "per_page": {"100"}}
+
+	var issues []gitLabIssue
+	if _, err := gitlab.sendApiRequest(ctx, "GET", fmt.Sprintf("/projects/%s/issues?%s", projectPath(owner, repo), query.Encode()), nil, &issues); err != nil {
+		log.For(ctx).Error("Error listing GitLab Issues")
+		return nil, err
+	}
+
+	for _, issue := range issues {
+		if issue.Title == title {
+			return &Issue{ID: issue.IID, Title: issue.Title, Body: issue.Description}, nil
+		}
+	}
+	return nil, nil
+}
+
+func (gitlab GitLab) CreateIssue(ctx context.Context, owner string, repo string, title string, body string) error {
+	if _, err := gitlab.sendApiRequest(ctx, "POST", fmt.Sprintf("/projects/%s/issues", projectPath(owner, repo)), gitLabIssue{Title: title, Description: body}, nil); err != nil {
+		log.For(ctx).Error("Error creating GitLab Issue")
+		return err
+	}
+	return nil
+}
+
+func (gitlab GitLab) UpdateIssue(ctx context.Context, owner string, repo string, issueID int, body string) error {
+	if _, err := gitlab.sendApiRequest(ctx, "PUT", fmt.Sprintf("/projects/%s/issues/%d", projectPath(owner, repo), issueID), map[string]string{"description": body}, nil); err != nil {
+		log.For(ctx).Error("Error updating GitLab Issue")
+		return err
+	}
+	return nil
+}
+
+// sendApiRequest sends body encoded as json and decodes the response into result when it is not nil.
+// The response headers are returned for paging.
+func (gitlab GitLab) sendApiRequest(ctx context.Context, method string, path string, body interface{}, result interface{}) (http.Header, error) {
+	var reader io.Reader
+	if body != nil {
+		buf := &bytes.Buffer{}
+		if err := json.NewEncoder(buf).Encode(body); err != nil {
+			return nil, err
+		}
+		reader = buf
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, gitlab.apiURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Add("Content-Type", "application/json")
+	gitlab.authentication.AuthenticateHTTPRequest(request.Header)
+
+	client := getHTTPClient(gitlab.transport)
+	resp, err := client.Do(request)
+	if err != nil {
+		log.For(ctx).Error(client.LogString())
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	log.For(ctx).Tracef("%s '%s' returned %d.", method, request.URL, resp.StatusCode)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%s %s returned status code %s", method, path, resp.Status)
+	}
+
+	if result != nil {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return nil, err
+		}
+	}
+	return resp.Header, nil
+}
@@ -0,0 +1,121 @@
+package repositorymanagementsystem_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/coveooss/lure/lib/lure/project"
+	managementsystem "github.com/coveooss/lure/lib/lure/repositorymanagementsystem"
+	"github.com/coveooss/lure/lib/lure/vcs"
+)
+
+const gitLabProjectPath = "/api/v4/projects/group%2Fsubgroup%2Fcatfeeder"
+
+type fakeGitLab struct {
+	*fakeAPI
+	mergeRequests []map[string]interface{}
+}
+
+func newFakeGitLab(t *testing.T) *fakeGitLab {
+	fake := &fakeGitLab{}
+
+	fake.fakeAPI = newFakeAPI(t, gitLabProjectPath, func(w http.ResponseWriter, r *http.Request, route string) {
+		switch route {
+		case "GET /merge_requests":
+			page := r.URL.Query().Get("page")
+			if page == "1" {
+				w.Header().Set("X-Next-Page", "2")
+				json.NewEncoder(w).Encode(fake.mergeRequests[:1])
+			} else {
+				json.NewEncoder(w).Encode(fake.mergeRequests[1:])
+			}
+		case "GET /approval_rules":
+			fmt.Fprint(w, `[{"eligible_approvers": [{"id": 4, "username": "reviewer"}, {"id": 5, "username": "other"}]}, {"eligible_approvers": [{"id": 4, "username": "reviewer"}]}]`)
+		case "POST /merge_requests":
+			fmt.Fprint(w, `{"iid": 12}`)
+		case "GET /merge_requests/12":
+			fmt.Fprint(w, `{"iid": 12, "head_pipeline": {"status": "running"}}`)
+		default:
+			fmt.Fprint(w, `{}`)
+		}
+	})
+
+	return fake
+}
+
+func (fake *fakeGitLab) newGitLab(t *testing.T, auth vcs.Authentication) managementsystem.GitLab {
+	gitlab, err := managementsystem.NewGitLab(auth, project.Project{BaseURL: fake.server.URL + "/", Owner: "group/subgroup", Name: "catfeeder"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gitlab
+}
+
+func TestGitLabGetPullRequestsPagesAndMapsStates(t *testing.T) {
+	fake := newFakeGitLab(t)
+	defer fake.server.Close()
+	fake.mergeRequests = []map[string]interface{}{
+		{"iid": 1, "title": "open", "source_branch": "lure-a", "target_branch": "master", "state": "opened"},
+		{"iid": 2, "title": "closed", "source_branch": "lure-b", "target_branch": "master", "state": "closed", "labels": []string{"dependencies"}},
+		{"iid": 3, "title": "merged", "source_branch": "lure-c", "target_branch": "master", "state": "merged"},
+	}
+
+	prs, err := fake.newGitLab(t, vcs.TokenAuth{Token: "secret"}).GetPullRequests(context.Background(), "group/subgroup", "catfeeder", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(prs) != 2 {
+		t.Fatalf("Should have returned the open and declined merge requests, got %d", len(prs))
+	}
+	if prs[0].ID != 1 || prs[0].State != "OPEN" || prs[0].Source.GetName() != "lure-a" {
+		t.Errorf("Unexpected first merge request %+v", prs[0])
+	}
+	if prs[1].State != "DECLINED" || prs[1].Labels[0] != "dependencies" {
+		t.Errorf("Closed merge request should be declined %+v", prs[1])
+	}
+	if fake.token(0) != "Bearer secret" {
+		t.Errorf("Should authenticate with the personal token, got '%s'", fake.token(0))
+	}
+}
+
+func TestGitLabCreatePullRequestWithDefaultApprovers(t *testing.T) {
+	fake := newFakeGitLab(t)
+	defer fake.server.Close()
+
+	err := fake.newGitLab(t, vcs.JobTokenAuth{Token: "job"}).CreatePullRequest(context.Background(), "lure-a", "master", "group/subgroup", "catfeeder", "Update a", "description", managementsystem.PullRequestOptions{UseDefaultReviewers: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := fake.received["POST /merge_requests"]
+	if body["source_branch"] != "lure-a" || body["target_branch"] != "master" || body["title"] != "Update a" {
+		t.Errorf("Unexpected merge request %v", body)
+	}
+	if fmt.Sprint(body["reviewer_ids"]) != "[4 5]" {
+		t.Errorf("Should request the eligible approvers once, got %v", body["reviewer_ids"])
+	}
+	if fake.headers[0].Get("JOB-TOKEN") != "job" {
+		t.Errorf("Should authenticate with the job token, got '%s'", fake.headers[0].Get("JOB-TOKEN"))
+	}
+}
+
+func TestGitLabGoesThroughTheProxyOfTheProject(t *testing.T) {
+	fake := newFakeGitLab(t)
+	defer fake.server.Close()
+
+	gitlab, err := managementsystem.NewGitLab(vcs.TokenAuth{}, project.Project{BaseURL: "http://gitlab.example.com", Proxy: fake.server.URL, Owner: "group/subgroup", Name: "catfeeder"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := gitlab.GetPullRequestStatus(context.Background(), "group/subgroup", "catfeeder", managementsystem.PullRequest{ID: 12}); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.headers) != 1 {
+		t.Errorf("Should have sent the request to the proxy")
+	}
+}
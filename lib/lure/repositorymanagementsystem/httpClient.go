@@ -0,0 +1,58 @@
+package repositorymanagementsystem
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/coveooss/lure/lib/lure/project"
+	"github.com/coveooss/lure/lib/lure/vcs"
+)
+
+// NewTransport returns a rate limited transport going through the proxy of the project and trusting its CA certificates
+func NewTransport(project project.Project) (http.RoundTripper, error) {
+	if project.Proxy == "" && len(project.CACertificates) == 0 {
+		return rateLimitedTransport{http.DefaultTransport}, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if project.Proxy != "" {
+		proxyURL, err := url.Parse(project.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid proxy '%s': %s", project.Proxy, err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if len(project.CACertificates) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		for _, certificatePath := range project.CACertificates {
+			certificates, err := ioutil.ReadFile(certificatePath)
+			if err != nil {
+				return nil, err
+			}
+			if !pool.AppendCertsFromPEM(certificates) {
+				return nil, fmt.Errorf("No PEM certificate found in '%s'", certificatePath)
+			}
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return rateLimitedTransport{transport}, nil
+}
+
+// authenticateRequest authenticates an API request in its headers, with basic auth for a username and password
+func authenticateRequest(authentication vcs.Authentication, request *http.Request) {
+	if userPass, ok := authentication.(vcs.UserPassAuth); ok {
+		request.SetBasicAuth(userPass.Username, userPass.Password)
+		return
+	}
+	authentication.AuthenticateHTTPRequest(request.Header)
+}
@@ -0,0 +1,7 @@
+package repositorymanagementsystem
+
+type Issue struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
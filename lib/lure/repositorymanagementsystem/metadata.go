@@ -0,0 +1,43 @@
+package repositorymanagementsystem
+
+import (
+	"encoding/json"
+	"regexp"
+)
+
+// Metadata describes the update made by a lure pull request. It is stored in a hidden block of the
+// description so lure can recognize its pull requests without relying on their branch names.
+type Metadata struct {
+	Type           string `json:"type"`
+	Module         string `json:"module"`
+	From           string `json:"from,omitempty"`
+	To             string `json:"to"`
+	ConfigHash     string `json:"configHash,omitempty"`
+	AutoMerge      bool   `json:"autoMerge,omitempty"`
+	DependencyType string `json:"dependencyType,omitempty"`
+	// PackageModule is the module as listed by its package manager, when Module is the name of its version property
+	PackageModule string `json:"packageModule,omitempty"`
+}
+
+var metadataRegex = regexp.MustCompile(`<!-- lure:metadata (\{.*?\}) -->`)
+
+// EncodeMetadata returns the hidden block to append to a pull request description.
+// HTML characters are escaped by the JSON encoding, so module names cannot close the comment.
+func EncodeMetadata(metadata Metadata) string {
+	data, _ := json.Marshal(metadata)
+	return "<!-- lure:metadata " + string(data) + " -->"
+}
+
+// ParseMetadata returns the metadata block of a pull request description, or nil when there is none
+func ParseMetadata(description string) *Metadata {
+	match := metadataRegex.FindStringSubmatch(description)
+	if match == nil {
+		return nil
+	}
+
+	var metadata Metadata
+	if err := json.Unmarshal([]byte(match[1]), &metadata); err != nil {
+		return nil
+	}
+	return &metadata
+}
@@ -0,0 +1,25 @@
+package repositorymanagementsystem_test
+
+import (
+	"testing"
+
+	managementsystem "github.com/coveooss/lure/lib/lure/repositorymanagementsystem"
+)
+
+func TestParseMetadataShouldReadEncodedMetadata(t *testing.T) {
+	metadata := managementsystem.Metadata{Type: "npm", Module: "evil-->module", From: "1.0.0", To: "2.0.0", ConfigHash: "abc", AutoMerge: true}
+
+	parsed := managementsystem.ParseMetadata("Update evil-->module\n\n" + managementsystem.EncodeMetadata(metadata))
+
+	if parsed == nil || *parsed != metadata {
+		t.Errorf("Expected %+v, got %+v", metadata, parsed)
+	}
+}
+
+func TestParseMetadataShouldIgnoreDescriptionsWithoutMetadata(t *testing.T) {
+	for _, description := range []string{"", "Update lodash", "<!-- lure:autoMerge -->", "<!-- lure:metadata {not json} -->"} {
+		if parsed := managementsystem.ParseMetadata(description); parsed != nil {
+			t.Errorf("Expected no metadata in %q, got %+v", description, parsed)
+		}
+	}
+}
@@ -5,12 +5,57 @@ type Branch interface {
 }
 
 type PullRequest struct {
-	ID                int    `json:"id"`
-	Title             string `json:"title"`
-	Description       string `json:"description"`
-	Source            Branch `json:"source"`
-	Dest              Branch `json:"dest"`
-	CloseSourceBranch bool   `json:"close_source_branch"`
-	State             string `json:"state"`
-	Reviewers         []user `json:"reviewers"`
+	ID                int       `json:"id"`
+	Title             string    `json:"title"`
+	Description       string    `json:"description"`
+	Source            Branch    `json:"source"`
+	Dest              Branch    `json:"dest"`
+	CloseSourceBranch bool      `json:"close_source_branch"`
+	State             string    `json:"state"`
+	Reviewers         []user    `json:"reviewers"`
+	Labels            []string  `json:"-"`
+	MergeCommit       string    `json:"-"`
+	Metadata          *Metadata `json:"-"`
+	// FromFork is set when the source branch is in another repository, whoever can fork can name it like a branch of lure
+	FromFork bool `json:"-"`
+	// SourceCommit is the head commit of the source branch, when the host tells it
+	SourceCommit string `json:"-"`
+}
+
+// PullRequestOptions are the optional settings of created pull requests.
+// Hosts lacking a feature apply its closest equivalent, or ignore it.
+type PullRequestOptions struct {
+	UseDefaultReviewers bool
+	Labels              []string
+	Assignees           []string
+	Milestone           string
+	Draft               bool
+}
+
+// BuildStatus is the aggregated result of the statuses and checks reported on a pull request
+type BuildStatus string
+
+const (
+	BuildSuccessful BuildStatus = "SUCCESSFUL"
+	BuildFailed     BuildStatus = "FAILED"
+	BuildInProgress BuildStatus = "INPROGRESS"
+	BuildNone       BuildStatus = "NONE"
+)
+
+// aggregateBuildStatus reduces many statuses to one: any failure wins, then anything still running
+func aggregateBuildStatus(statuses []BuildStatus) BuildStatus {
+	if len(statuses) == 0 {
+		return BuildNone
+	}
+
+	result := BuildSuccessful
+	for _, status := range statuses {
+		switch status {
+		case BuildFailed:
+			return BuildFailed
+		case BuildInProgress:
+			result = BuildInProgress
+		}
+	}
+	return result
 }
@@ -0,0 +1,86 @@
+package repositorymanagementsystem
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// hostLimiters spaces out the API calls made to each host, by all the projects run in parallel
+var hostLimiters = rateLimiters{limiters: map[string]*rateLimiter{}}
+
+// SetRateLimit limits the API calls to requestsPerSecond per host. Zero removes the limit.
+func SetRateLimit(requestsPerSecond float64) {
+	hostLimiters.mutex.Lock()
+	defer hostLimiters.mutex.Unlock()
+	hostLimiters.interval = 0
+	if requestsPerSecond > 0 {
+		hostLimiters.interval = time.Duration(float64(time.Second) / requestsPerSecond)
+	}
+	hostLimiters.limiters = map[string]*rateLimiter{}
+}
+
+type rateLimiters struct {
+	mutex    sync.Mutex
+	interval time.Duration
+	limiters map[string]*rateLimiter
+}
+
+func (limiters *rateLimiters) forHost(host string) *rateLimiter {
+	limiters.mutex.Lock()
+	defer limiters.mutex.Unlock()
+	if limiters.interval == 0 {
+		return nil
+	}
+	limiter, ok := limiters.limiters[host]
+	if !ok {
+		limiter = &rateLimiter{interval: limiters.interval}
+		limiters.limiters[host] = limiter
+	}
+	return limiter
+}
+
+type rateLimiter struct {
+	mutex    sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// wait books the next slot of the host and waits for it, or for ctx to be done
+func (limiter *rateLimiter) wait(ctx context.Context) error {
+	limiter.mutex.Lock()
+	now := time.Now()
+	slot := limiter.next
+	if slot.Before(now) {
+		slot = now
+	}
+	limiter.next = slot.Add(limiter.interval)
+	limiter.mutex.Unlock()
+
+	timer := time.NewTimer(slot.Sub(now))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// rateLimitedTransport waits for the slot of the host before each request, retries included
+type rateLimitedTransport struct {
+	base http.RoundTripper
+}
+
+func (transport rateLimitedTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if limiter := hostLimiters.forHost(request.URL.Host); limiter != nil {
+		if err := limiter.wait(request.Context()); err != nil {
+			if request.Body != nil {
+				request.Body.Close()
+			}
+			return nil, err
+		}
+	}
+	return transport.base.RoundTrip(request)
+}
@@ -0,0 +1,45 @@
+package repositorymanagementsystem_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	managementsystem "github.com/coveooss/lure/lib/lure/repositorymanagementsystem"
+)
+
+func TestRateLimitSpacesOutTheCallsToAHost(t *testing.T) {
+	fake := newFakeGitea(t)
+	defer fake.server.Close()
+	managementsystem.SetRateLimit(10)
+	defer managementsystem.SetRateLimit(0)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := fake.newGitea(t).DeclinePullRequest(context.Background(), "lure", "catfeeder", 12); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("Should have waited 100ms between the calls, 3 calls took %s", elapsed)
+	}
+}
+
+func TestRateLimitStopsWaitingWhenTheContextIsDone(t *testing.T) {
+	fake := newFakeGitea(t)
+	defer fake.server.Close()
+	managementsystem.SetRateLimit(0.1)
+	defer managementsystem.SetRateLimit(0)
+
+	if err := fake.newGitea(t).DeclinePullRequest(context.Background(), "lure", "catfeeder", 12); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := fake.newGitea(t).DeclinePullRequest(ctx, "lure", "catfeeder", 12); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Should have given up waiting for the next slot, got %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package vcs
+
+import (
+	"net/url"
+)
+
+const (
+	credentialHelperUsername = "LURE_GIT_USERNAME"
+	credentialHelperPassword = "LURE_GIT_PASSWORD"
+	credentialHelperHost     = "LURE_GIT_HOST"
+)
+
+// credentialHelper answers the get requests of git from the environment, so the secrets appear neither
+// on the command line nor in the URL. It only answers for the host of the source, not for the submodules or
+// redirections to other hosts. The empty helper first drops the helpers configured elsewhere.
+const credentialHelper = `!f() { test "$1" = get || return 0; ` +
+	`while read -r line && test -n "$line"; do test "$line" = "host=$` + credentialHelperHost + `" && matched=1; done; ` +
+	`if test -n "$matched"; then echo "username=$` + credentialHelperUsername + `"; echo "password=$` + credentialHelperPassword + `"; fi; }; f`
+
+// CredentialHelperAuth gives the credentials of the wrapped authentication to git through a credential helper.
+// Mercurial has no credential helpers, hg keeps the credentials in its URL.
+type CredentialHelperAuth struct {
+	Authentication
+}
+
+// AuthenticateURL leaves the URL untouched, the credentials going through the helper
+func (auth CredentialHelperAuth) AuthenticateURL(source string) string {
+	return source
+}
+
+// credentials returns the user and password the wrapped authentication would put in the URL
+func (auth CredentialHelperAuth) credentials(source string) (string, string) {
+	authenticated, err := url.Parse(auth.Authentication.AuthenticateURL(source))
+	if err != nil || authenticated.User == nil {
+		return "", ""
+	}
+	password, _ := authenticated.User.Password()
+	return authenticated.User.Username(), password
+}
+
+func (auth CredentialHelperAuth) CommandArgs(vcs string, source string) ([]string, []string) {
+	username, password := auth.credentials(source)
+	var host string
+	if parsed, err := url.Parse(source); err == nil {
+		host = parsed.Host
+	}
+	args := []string{"-c", "credential.helper=", "-c", "credential.helper=" + credentialHelper}
+	return args, []string{credentialHelperUsername + "=" + username, credentialHelperPassword + "=" + password, credentialHelperHost + "=" + host}
+}
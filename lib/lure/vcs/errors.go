@@ -0,0 +1,125 @@
+package vcs
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	osutil "github.com/coveooss/lure/lib/lure/os"
+)
+
+// The kinds of failures callers can act on, matched with errors.Is
+var (
+	ErrNothingToCommit = errors.New("nothing to commit")
+	ErrAuth            = errors.New("authentication failed")
+	ErrConflict        = errors.New("conflict")
+	ErrNotFound        = errors.New("not found")
+	ErrBranchExists    = errors.New("branch already exists")
+)
+
+// Error is a failed vcs operation. It matches its Kind with errors.Is and unwraps to the underlying error.
+type Error struct {
+	Kind     error // nil when the failure is not recognized
+	ExitCode int   // -1 when no command ran, as with go-git
+	Stderr   string
+	Err      error
+}
+
+func (e *Error) Error() string {
+	if stderr := strings.TrimSpace(e.Stderr); stderr != "" {
+		return fmt.Sprintf("%s: %s", e.Err, stderr)
+	}
+	return e.Err.Error()
+}
+
+func (e *Error) Is(target error) bool {
+	return e.Kind != nil && e.Kind == target
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// commandErrors recognizes the failures of git and hg from the lines of their stderr, in order. The commands run
+// with LC_ALL=C so the messages are not translated. Nothing to commit and merge conflicts are told on stdout, the
+// operations recognize them from the exit code or the state of the repository instead.
+var commandErrors = []struct {
+	kind    error
+	pattern *regexp.Regexp
+}{
+	// GitHub answers that a repository is not found when the credentials cannot see it
+	{ErrAuth, anchoredPattern(
+		`fatal: Authentication failed for `,
+		`fatal: could not read (Username|Password) for `,
+		`remote: Repository not found`,
+		`fatal: repository '.*' not found$`,
+		`fatal: unable to access '.*': The requested URL returned error: 40[13]`,
+		`remote: HTTP Basic: Access denied`,
+		`(\S+: )?Permission denied \(publickey`,
+		`Host key verification failed`,
+		`abort: authorization failed`,
+		`abort: HTTP Error 40[13]`,
+	)},
+	{ErrConflict, anchoredPattern(
+		`error: could not apply `,
+		`abort: unresolved conflicts`,
+	)},
+	{ErrBranchExists, anchoredPattern(
+		`fatal: a branch named '.*' already exists`,
+		`abort: a branch of the same name already exists`,
+		`abort: bookmark '.*' already exists`,
+	)},
+	{ErrNotFound, anchoredPattern(
+		`error: pathspec '.*' did not match any file\(s\) known to git`,
+		`fatal: couldn't find remote ref `,
+		`fatal: Not a valid object name `,
+		`fatal: invalid reference: `,
+		`fatal: bad revision '`,
+		`fatal: ambiguous argument '.*': unknown revision`,
+		`abort: unknown revision '`,
+		`abort: HTTP Error 404`,
+	)},
+}
+
+// anchoredPattern matches the lines starting with one of the messages
+func anchoredPattern(messages ...string) *regexp.Regexp {
+	return regexp.MustCompile(`(?m)^(?:` + strings.Join(messages, "|") + `)`)
+}
+
+// newCommandError wraps the failure of a git or hg command with its kind, exit code and stderr
+func newCommandError(err error) error {
+	var execErr *osutil.ExecError
+	if !errors.As(err, &execErr) {
+		return err
+	}
+
+	var kind error
+	for _, commandError := range commandErrors {
+		if commandError.pattern.MatchString(execErr.Stderr) {
+			kind = commandError.kind
+			break
+		}
+	}
+	return &Error{Kind: kind, ExitCode: execErr.ExitCode, Stderr: execErr.Stderr, Err: err}
+}
+
+// withKind gives kind to a command failure its stderr did not tell apart
+func withKind(err error, kind error) error {
+	var vcsErr *Error
+	if errors.As(err, &vcsErr) && vcsErr.Kind == nil {
+		vcsErr.Kind = kind
+	}
+	return err
+}
+
+// hasExitCode tells whether err is a command failure with the given exit code
+func hasExitCode(err error, exitCode int) bool {
+	var vcsErr *Error
+	return errors.As(err, &vcsErr) && vcsErr.ExitCode == exitCode
+}
+
+// newGoGitError wraps a go-git failure of the given kind
+func newGoGitError(kind error, err error) error {
+	return &Error{Kind: kind, ExitCode: -1, Err: err}
+}
@@ -0,0 +1,103 @@
+package vcs_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/coveooss/lure/lib/lure/vcs"
+)
+
+// failingHg puts an hg on the PATH that prints stdout and stderr and exits with exitCode
+func failingHg(t *testing.T, stdout string, stderr string, exitCode int) func() {
+	dir, err := ioutil.TempDir("", "lure-hg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"stdout": stdout, "stderr": stderr} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	script := "#!/bin/sh\ncat " + filepath.Join(dir, "stdout") + "\ncat " + filepath.Join(dir, "stderr") + " >&2\nexit " + strconv.Itoa(exitCode) + "\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "hg"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	return func() {
+		os.Setenv("PATH", path)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestCommandErrorsShouldBeRecognizedFromStderr(t *testing.T) {
+	samples := []struct {
+		stdout   string
+		stderr   string
+		exitCode int
+		expected error
+	}{
+		{"", "fatal: Authentication failed for 'https://gitlab.com/coveo/catfeeder.git/'\n", 128, vcs.ErrAuth},
+		{"", "remote: HTTP Basic: Access denied\nfatal: Authentication failed for 'https://gitlab.com/coveo/catfeeder.git/'\n", 128, vcs.ErrAuth},
+		{"", "remote: Repository not found.\nfatal: repository 'https://github.com/coveo/catfeeder.git/' not found\n", 128, vcs.ErrAuth},
+		{"", "fatal: could not read Username for 'https://github.com': terminal prompts disabled\n", 128, vcs.ErrAuth},
+		{"", "fatal: unable to access 'https://bitbucket.example.com/scm/cat/catfeeder.git/': The requested URL returned error: 403\n", 128, vcs.ErrAuth},
+		{"", "git@github.com: Permission denied (publickey).\nfatal: Could not read from remote repository.\n\nPlease make sure you have the correct access rights\nand the repository exists.\n", 128, vcs.ErrAuth},
+		{"", "Host key verification failed.\nfatal: Could not read from remote repository.\n", 128, vcs.ErrAuth},
+		{"", "abort: authorization failed\n", 255, vcs.ErrAuth},
+		{"", "abort: HTTP Error 403: Forbidden\n", 255, vcs.ErrAuth},
+		{"", "error: could not apply 1a2b3c4... Fix the feeder schedule\nhint: after resolving the conflicts, mark the corrected paths\n", 1, vcs.ErrConflict},
+		{"grafting 12:1a2b3c4d5e6f \"Fix the feeder schedule\"\nmerging feeder.py\n", "abort: unresolved conflicts, can't continue\n(use 'hg resolve' and 'hg graft --continue')\n", 255, vcs.ErrConflict},
+		{"", "fatal: a branch named 'master' already exists\n", 128, vcs.ErrBranchExists},
+		{"", "abort: a branch of the same name already exists\n(use 'hg update' to switch to it)\n", 255, vcs.ErrBranchExists},
+		{"", "abort: bookmark 'lure-left_pad-1_3_0' already exists (use -f to force)\n", 255, vcs.ErrBranchExists},
+		{"", "error: pathspec 'release/9.9' did not match any file(s) known to git\n", 1, vcs.ErrNotFound},
+		{"", "fatal: couldn't find remote ref release/9.9\n", 128, vcs.ErrNotFound},
+		{"", "fatal: ambiguous argument 'master..release/9.9': unknown revision or path not in the working tree.\nUse '--' to separate paths from revisions, like this:\n", 128, vcs.ErrNotFound},
+		{"", "abort: unknown revision 'release/9.9'!\n", 255, vcs.ErrNotFound},
+		{"", "abort: HTTP Error 404: Not Found\n", 255, vcs.ErrNotFound},
+		// Only the lines of stderr starting with a known message are recognized, not the output of the command
+		{"Fix the conflict when the feeder is not found\n", "abort: no username supplied\n", 255, nil},
+		{"", "warning: the repository was not found in the cache\nabort: push creates new remote head 1a2b3c4d5e6f\n", 255, nil},
+	}
+
+	for _, sample := range samples {
+		restore := failingHg(t, sample.stdout, sample.stderr, sample.exitCode)
+		repo, err := vcs.NewHg(vcs.TokenAuth{User: "lure", Token: "secret"}, "https://hg.example.com/catfeeder", "", "default", "closed-branch-trash", vcs.HgBranch, "", vcs.CloneOptions{}, vcs.CommitOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = repo.Cmd(context.Background(), "pull")
+		restore()
+
+		var vcsErr *vcs.Error
+		if !errors.As(err, &vcsErr) {
+			t.Fatalf("Expected a vcs error, got %v", err)
+		}
+		if vcsErr.Kind != sample.expected || vcsErr.ExitCode != sample.exitCode {
+			t.Errorf("Expected %v and exit code %d for %q, got %v and %d", sample.expected, sample.exitCode, sample.stderr, vcsErr.Kind, vcsErr.ExitCode)
+		}
+	}
+}
+
+func TestHgShouldRecognizeFailuresFromTheirExitCode(t *testing.T) {
+	restore := failingHg(t, "nothing changed\n", "", 1)
+	defer restore()
+
+	repo, err := vcs.NewHg(vcs.TokenAuth{User: "lure", Token: "secret"}, "https://hg.example.com/catfeeder", "", "default", "closed-branch-trash", vcs.HgBranch, "", vcs.CloneOptions{}, vcs.CommitOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Commit(context.Background(), "Nothing"); !errors.Is(err, vcs.ErrNothingToCommit) {
+		t.Errorf("Expected nothing to commit, got %v", err)
+	}
+	if _, err := repo.CherryPick(context.Background(), "1a2b3c4d5e6f"); !errors.Is(err, vcs.ErrConflict) {
+		t.Errorf("Expected a conflict, got %v", err)
+	}
+}
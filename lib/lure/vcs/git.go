@@ -1,24 +1,47 @@
 package vcs
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/coveooss/lure/lib/lure/log"
 	osutil "github.com/coveooss/lure/lib/lure/os"
 )
 
 type GitRepo struct {
-	workingPath string
-	localPath   string
-	remotePath  string
+	workingPath    string
+	localPath      string
+	basePath       string
+	source         string
+	authentication Authentication
+	cloneOptions   CloneOptions
+	commitOptions  CommitOptions
+	// caInfo is the file of the certificates trusted by the remote
+	caInfo string
+	// detached is set on the worktrees, which check out revisions detached as a branch can only be checked out once
+	detached bool
 }
 
-func NewGit(auth Authentication, source string, to string, basePath string) (GitRepo, error) {
+// NewGit signs commits with gpg or ssh, the signing key being a gpg key id or the path of an ssh key
+func NewGit(auth Authentication, source string, to string, basePath string, cloneOptions CloneOptions, commitOptions CommitOptions) (GitRepo, error) {
 	var repo GitRepo
-
-	source = auth.AuthenticateURL(source)
+	if err := commitOptions.validate(); err != nil {
+		return repo, err
+	}
+	caInfo, err := certificateBundle(cloneOptions.CACertificates)
+	if err != nil {
+		return repo, err
+	}
 
 	var workingPath strings.Builder
 	workingPath.WriteString(to)
@@ -26,31 +49,125 @@ func NewGit(auth Authentication, source string, to string, basePath string) (Git
 	workingPath.WriteString(basePath)
 
 	repo = GitRepo{
-		workingPath: workingPath.String(),
-		localPath:   to,
-		remotePath:  source,
+		workingPath:    workingPath.String(),
+		localPath:      to,
+		basePath:       strings.Trim(basePath, "/"),
+		source:         withoutURLUser(source),
+		authentication: auth,
+		cloneOptions:   cloneOptions,
+		commitOptions:  commitOptions,
+		caInfo:         caInfo,
 	}
 
 	return repo, nil
 }
 
+// certificateBundle returns the file holding the certificates, git trusting a single file. Several certificates are
+// written to a bundle of the temporary directory, named after their paths so every run reuses it.
+func certificateBundle(certificates []string) (string, error) {
+	if len(certificates) <= 1 {
+		return strings.Join(certificates, ""), nil
+	}
+
+	var bundle []byte
+	for _, certificate := range certificates {
+		content, err := ioutil.ReadFile(certificate)
+		if err != nil {
+			return "", err
+		}
+		bundle = append(append(bundle, content...), '\n')
+	}
+
+	key := sha256.Sum256([]byte(strings.Join(certificates, "\n")))
+	path := filepath.Join(os.TempDir(), "lure-ca-"+hex.EncodeToString(key[:])[:16]+".pem")
+	return path, ioutil.WriteFile(path, bundle, 0644)
+}
+
+// withoutURLUser drops the user of clone URLs like https://org@dev.azure.com/org/project/_git/repo,
+// the authentication adding its own
+func withoutURLUser(source string) string {
+	parsed, err := url.Parse(source)
+	if err != nil || parsed.User == nil {
+		return source
+	}
+	parsed.User = nil
+	return parsed.String()
+}
+
 func (gitRepo GitRepo) SanitizeBranchName(branchName string) string {
+	return sanitizeGitBranchName(branchName)
+}
+
+func sanitizeGitBranchName(branchName string) string {
 	//TODO: https://wincent.com/wiki/Legal_Git_branch_names
 	reg, _ := regexp.Compile("[^a-zA-Z0-9/_-]+")
 	safe := reg.ReplaceAllString(branchName, "_")
 	return safe
 }
 
-func (gitRepo GitRepo) Clone() error {
-	log.Logger.Infof("cloning to %s", gitRepo.localPath)
-	args := []string{"clone", gitRepo.remotePath, gitRepo.localPath}
+// Clone clones the source, or refreshes the clone of an earlier run when cached
+func (gitRepo GitRepo) Clone(ctx context.Context) error {
+	if gitRepo.cloneOptions.Cached && isDir(filepath.Join(gitRepo.localPath, ".git")) {
+		return gitRepo.refresh(ctx)
+	}
+
+	log.For(ctx).Infof("cloning to %s", gitRepo.localPath)
+	args := []string{"clone"}
+	if gitRepo.cloneOptions.Depth > 0 {
+		args = append(args, "--depth", strconv.Itoa(gitRepo.cloneOptions.Depth), "--no-single-branch")
+	}
+	if gitRepo.cloneOptions.Filter != "" {
+		args = append(args, "--filter="+gitRepo.cloneOptions.Filter)
+	}
+	sparse := gitRepo.cloneOptions.Sparse && gitRepo.basePath != ""
+	if sparse {
+		args = append(args, "--sparse")
+	}
+	args = append(args, gitRepo.RemotePath(), gitRepo.localPath)
 
-	if _, err := osutil.Execute("", "git", args...); err != nil {
+	if _, err := gitRepo.git(ctx, "", args...); err != nil {
 		return err
 	}
+	if sparse {
+		if _, err := gitRepo.Cmd(ctx, "sparse-checkout", "set", gitRepo.basePath); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// refresh fetches the source and drops what an earlier run left behind: local branches, changes and untracked files
+func (gitRepo GitRepo) refresh(ctx context.Context) error {
+	log.For(ctx).Infof("refreshing %s", gitRepo.localPath)
+	fetch := []string{"fetch", "--prune", "--force"}
+	if gitRepo.cloneOptions.Depth > 0 {
+		fetch = append(fetch, "--depth", strconv.Itoa(gitRepo.cloneOptions.Depth))
+	}
+	fetch = append(fetch, gitRepo.RemotePath(), "+refs/heads/*:refs/remotes/origin/*")
+
+	for _, args := range [][]string{fetch, {"reset", "--hard"}, {"checkout", "--force", "--detach", "origin/HEAD"}, {"clean", "-ffdx"}} {
+		if _, err := gitRepo.Cmd(ctx, args...); err != nil {
+			return err
+		}
+	}
+
+	// the cache may be shared by projects of other base paths
+	if gitRepo.cloneOptions.Sparse && gitRepo.basePath != "" {
+		if _, err := gitRepo.Cmd(ctx, "sparse-checkout", "set", gitRepo.basePath); err != nil {
+			return err
+		}
+	}
+
+	out, err := gitRepo.Cmd(ctx, "for-each-ref", "--format=%(refname:short)", "refs/heads/")
+	if err != nil {
+		return err
+	}
+	if branches := splitLines(out); len(branches) > 0 {
+		_, err = gitRepo.Cmd(ctx, append([]string{"branch", "-D"}, branches...)...)
+	}
+	return err
+}
+
 func (gitRepo GitRepo) WorkingPath() string {
 	return gitRepo.workingPath
 }
@@ -59,40 +176,197 @@ func (gitRepo GitRepo) LocalPath() string {
 	return gitRepo.localPath
 }
 
+// RemotePath authenticates the source at each call, the credentials possibly expiring during long runs
 func (gitRepo GitRepo) RemotePath() string {
-	return gitRepo.remotePath
+	return gitRepo.authentication.AuthenticateURL(gitRepo.source)
+}
+
+func (gitRepo GitRepo) Cmd(ctx context.Context, args ...string) (string, error) {
+	return gitRepo.git(ctx, gitRepo.localPath, args...)
+}
+
+// git runs a git command with the commit and remote options, and the options and environment of the authentication
+// when it authenticates commands. Merges and cherry-picks commit too, so every command gets the commit options.
+// Failures are returned as an *Error.
+func (gitRepo GitRepo) git(ctx context.Context, dir string, args ...string) (string, error) {
+	commandArgs := append(gitRepo.commitArgs(), gitRepo.remoteArgs()...)
+	// The errors are recognized from the untranslated messages
+	env := []string{"LC_ALL=C"}
+	if commandAuth, ok := gitRepo.authentication.(CommandAuthentication); ok {
+		authArgs, authEnv := commandAuth.CommandArgs(Git, gitRepo.source)
+		commandArgs, env = append(commandArgs, authArgs...), append(env, authEnv...)
+	}
+
+	out, err := osutil.ExecuteWithEnv(ctx, dir, env, "git", append(commandArgs, args...)...)
+	if err != nil {
+		return out, newCommandError(err)
+	}
+	return out, nil
+}
+
+func (gitRepo GitRepo) remoteArgs() []string {
+	var args []string
+	if gitRepo.cloneOptions.Proxy != "" {
+		args = append(args, "-c", "http.proxy="+gitRepo.cloneOptions.Proxy)
+	}
+	if gitRepo.caInfo != "" {
+		args = append(args, "-c", "http.sslCAInfo="+gitRepo.caInfo)
+	}
+	return args
+}
+
+func (gitRepo GitRepo) commitArgs() []string {
+	var args []string
+	if gitRepo.commitOptions.AuthorName != "" {
+		args = append(args, "-c", "user.name="+gitRepo.commitOptions.AuthorName)
+	}
+	if gitRepo.commitOptions.AuthorEmail != "" {
+		args = append(args, "-c", "user.email="+gitRepo.commitOptions.AuthorEmail)
+	}
+
+	switch gitRepo.commitOptions.SigningFormat {
+	case GPG:
+		args = append(args, "-c", "gpg.format=openpgp")
+	case SSH:
+		args = append(args, "-c", "gpg.format=ssh")
+	default:
+		return args
+	}
+	return append(args, "-c", "user.signingKey="+gitRepo.commitOptions.SigningKey, "-c", "commit.gpgSign=true")
+}
+
+func (gitRepo GitRepo) Update(ctx context.Context, rev string) (string, error) {
+	if gitRepo.detached {
+		return gitRepo.Cmd(ctx, "checkout", "--detach", rev)
+	}
+	return gitRepo.Cmd(ctx, "checkout", rev)
+}
+
+// Clean drops the changes and the untracked files of the working copy, the ignored ones like node_modules are kept
+func (gitRepo GitRepo) Clean(ctx context.Context) error {
+	if _, err := gitRepo.Cmd(ctx, "reset", "--hard"); err != nil {
+		return err
+	}
+	_, err := gitRepo.Cmd(ctx, "clean", "-ffd")
+	return err
 }
 
-func (gitRepo GitRepo) Cmd(args ...string) (string, error) {
-	return osutil.Execute(gitRepo.localPath, "git", args...)
+// AddWorktree checks out another working copy of the clone in dir, replacing what an earlier run left there
+func (gitRepo GitRepo) AddWorktree(ctx context.Context, dir string) (SourceControl, error) {
+	if err := gitRepo.RemoveWorktree(ctx, dir); err != nil {
+		return nil, err
+	}
+	if _, err := gitRepo.Cmd(ctx, "worktree", "add", "--detach", "--no-checkout", dir, "HEAD"); err != nil {
+		return nil, err
+	}
+
+	worktree := gitRepo
+	worktree.localPath = dir
+	worktree.workingPath = dir + strings.TrimPrefix(gitRepo.workingPath, gitRepo.localPath)
+	worktree.detached = true
+	// the sparse checkout is set before checking out, so the worktree never holds the whole repository
+	if gitRepo.cloneOptions.Sparse && gitRepo.basePath != "" {
+		if _, err := worktree.Cmd(ctx, "sparse-checkout", "set", gitRepo.basePath); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := worktree.Cmd(ctx, "reset", "--hard"); err != nil {
+		return nil, err
+	}
+	return worktree, nil
 }
 
-func (gitRepo GitRepo) Update(rev string) (string, error) {
-	return gitRepo.Cmd("checkout", rev)
+// RemoveWorktree removes the working copy in dir, if any
+func (gitRepo GitRepo) RemoveWorktree(ctx context.Context, dir string) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	_, err := gitRepo.Cmd(ctx, "worktree", "prune")
+	return err
 }
 
-func (gitRepo GitRepo) Branch(branchname string) (string, error) {
-	return gitRepo.Cmd("checkout", "-b", gitRepo.SanitizeBranchName(branchname))
+func (gitRepo GitRepo) Branch(ctx context.Context, branchname string) (string, error) {
+	return gitRepo.Cmd(ctx, "checkout", "-b", gitRepo.SanitizeBranchName(branchname))
 }
 
-func (gitRepo GitRepo) SoftBranch(branchname string) (string, error) {
-	return gitRepo.Branch(branchname)
+func (gitRepo GitRepo) SoftBranch(ctx context.Context, branchname string) (string, error) {
+	return gitRepo.Branch(ctx, branchname)
 }
 
-func (gitRepo GitRepo) Commit(message string) (string, error) {
-	add, err := gitRepo.Cmd("add", "--all")
+func (gitRepo GitRepo) Commit(ctx context.Context, message string) (string, error) {
+	add, err := gitRepo.Cmd(ctx, "add", "--all")
 	if err != nil {
 		return add, err
 	}
-	return gitRepo.Cmd("commit", "-m", message)
+	out, err := gitRepo.Cmd(ctx, "commit", "-m", message)
+	// git tells there is nothing to commit on stdout, with the exit code of its other failures
+	if err != nil {
+		if status, statusErr := gitRepo.Cmd(ctx, "status", "--porcelain"); statusErr == nil && strings.TrimSpace(status) == "" {
+			return out, withKind(err, ErrNothingToCommit)
+		}
+	}
+	return out, err
 }
 
-func (gitRepo GitRepo) Push() (string, error) {
-	return gitRepo.Cmd("push", gitRepo.remotePath)
+// Merge merges rev into the current branch and commits the result. On conflict, the merge is left in progress.
+func (gitRepo GitRepo) Merge(ctx context.Context, rev string, message string) (string, error) {
+	out, err := gitRepo.Cmd(ctx, "merge", "--no-ff", "--no-edit", "-m", message, rev)
+	return out, gitRepo.withConflicts(ctx, err)
 }
 
-func (gitRepo GitRepo) CommitsBetween(baseRev string, secondRev string) ([]string, error) {
-	out, err := gitRepo.Cmd("log", "--pretty=%h", fmt.Sprintf("%s...%s", baseRev, secondRev))
+// withConflicts marks the failure of a merge or a cherry-pick as a conflict when it left unmerged files, as git
+// tells its conflicts on stdout
+func (gitRepo GitRepo) withConflicts(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if conflicts, conflictsErr := gitRepo.ConflictedFiles(ctx); conflictsErr == nil && len(conflicts) > 0 {
+		return withKind(err, ErrConflict)
+	}
+	return err
+}
+
+// ConflictedFiles returns the files left unmerged by the merge in progress
+func (gitRepo GitRepo) ConflictedFiles(ctx context.Context) ([]string, error) {
+	out, err := gitRepo.Cmd(ctx, "diff", "--name-only", "--diff-filter=U")
+	if err != nil {
+		return nil, err
+	}
+	return splitLines(out), nil
+}
+
+func (gitRepo GitRepo) AbortMerge(ctx context.Context) error {
+	_, err := gitRepo.Cmd(ctx, "merge", "--abort")
+	return err
+}
+
+// CherryPick applies rev on the current branch, against its first parent for merge commits.
+// On conflict, the cherry-pick is left in progress.
+func (gitRepo GitRepo) CherryPick(ctx context.Context, rev string) (string, error) {
+	parents, err := gitRepo.Cmd(ctx, "rev-list", "--parents", "-n", "1", rev)
+	if err != nil {
+		return "", err
+	}
+
+	args := []string{"cherry-pick", "-x"}
+	if len(strings.Fields(parents)) > 2 {
+		args = append(args, "-m", "1")
+	}
+	out, err := gitRepo.Cmd(ctx, append(args, rev)...)
+	return out, gitRepo.withConflicts(ctx, err)
+}
+
+func (gitRepo GitRepo) AbortCherryPick(ctx context.Context) error {
+	_, err := gitRepo.Cmd(ctx, "cherry-pick", "--abort")
+	return err
+}
+
+func (gitRepo GitRepo) Push(ctx context.Context) (string, error) {
+	return gitRepo.Cmd(ctx, "push", gitRepo.RemotePath())
+}
+
+func (gitRepo GitRepo) CommitsBetween(ctx context.Context, baseRev string, secondRev string) ([]string, error) {
+	out, err := gitRepo.Cmd(ctx, "log", "--pretty=%h", "--reverse", fmt.Sprintf("%s..%s", baseRev, secondRev))
 	if err != nil {
 		return []string{}, err
 	}
@@ -101,9 +375,19 @@ func (gitRepo GitRepo) CommitsBetween(baseRev string, secondRev string) ([]strin
 	return append(lines[:0], lines[:len(lines)-1]...), nil
 }
 
+// Log returns the commits of headRev missing from baseRev, from the oldest
+func (gitRepo GitRepo) Log(ctx context.Context, baseRev string, headRev string) ([]Commit, error) {
+	format := logRecordSeparator + strings.Join([]string{"%H", "%h", "%an", "%ae", "%aI", "%B", ""}, logFieldSeparator)
+	out, err := gitRepo.Cmd(ctx, "log", "--reverse", "--name-only", "--format="+format, fmt.Sprintf("%s..%s", baseRev, headRev))
+	if err != nil {
+		return nil, err
+	}
+	return parseLog(out)
+}
+
 // ActiveBranches returns all currently active branches without origin/ prefix
-func (gitRepo GitRepo) ActiveBranches() ([]string, error) {
-	out, err := gitRepo.Cmd("branch", "-r")
+func (gitRepo GitRepo) ActiveBranches(ctx context.Context) ([]string, error) {
+	out, err := gitRepo.Cmd(ctx, "branch", "-r")
 	if err != nil {
 		return nil, err
 	}
@@ -121,12 +405,56 @@ func (gitRepo GitRepo) ActiveBranches() ([]string, error) {
 }
 
 // CloseBranch deletes the branch for the remote repository
-func (gitRepo GitRepo) CloseBranch(branch string) error {
-	log.Logger.Infof("Closing branch %s.", branch)
-	_, err := gitRepo.Cmd("push", gitRepo.remotePath, "--delete", branch)
+func (gitRepo GitRepo) CloseBranch(ctx context.Context, branch string) error {
+	log.For(ctx).Infof("Closing branch %s.", branch)
+	_, err := gitRepo.Cmd(ctx, "push", gitRepo.RemotePath(), "--delete", branch)
 	return err
 }
 
+// CloseBranches deletes the branches for the remote repository, going on when one of them fails
+func (gitRepo GitRepo) CloseBranches(ctx context.Context, branches []string) error {
+	var failed []string
+	for _, branch := range branches {
+		if err := gitRepo.CloseBranch(ctx, branch); err != nil {
+			log.For(ctx).Errorf("Error: \"Could not close branch %s\" %s", branch, err)
+			failed = append(failed, branch)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("Could not close branches %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// BranchDate returns the newest of the commit date of the branch and of when the clone fetched that commit, so a
+// branch just pushed at an old commit is recent. The fetch is only known for the branches updated by the refresh of a
+// cached clone, git logging none for a clone.
+func (gitRepo GitRepo) BranchDate(ctx context.Context, branch string) (time.Time, error) {
+	out, err := gitRepo.Cmd(ctx, "log", "-1", "--format=%cI", "origin/"+branch)
+	if err != nil {
+		return time.Time{}, err
+	}
+	date, err := time.Parse(time.RFC3339, strings.TrimSpace(out))
+	if err != nil {
+		return date, err
+	}
+
+	out, err = gitRepo.Cmd(ctx, "log", "--walk-reflogs", "-1", "--date=unix", "--format=%gd", "refs/remotes/origin/"+branch)
+	if err != nil {
+		return date, err
+	}
+	if match := reflogDateRegex.FindStringSubmatch(out); match != nil {
+		seconds, _ := strconv.ParseInt(match[1], 10, 64)
+		if fetched := time.Unix(seconds, 0); fetched.After(date) {
+			return fetched, nil
+		}
+	}
+	return date, nil
+}
+
+// reflogDateRegex matches the date of a reflog entry, like origin/lure-lodash@{1592000000}
+var reflogDateRegex = regexp.MustCompile(`@\{(\d+)\}`)
+
 func (gitRepo GitRepo) GetName() string {
 	return Git
 }
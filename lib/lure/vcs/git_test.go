@@ -0,0 +1,522 @@
+package vcs_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	"github.com/coveooss/lure/lib/lure/vcs"
+)
+
+func TestGitShouldSparseCheckoutAndRefreshCachedClone(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir, err := ioutil.TempDir("", "lure-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "source")
+	remote, err := git.PlainInit(source, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, remote, "web/package.json", `{"name": "catfeeder"}`, "Add web")
+	commitFile(t, remote, "api/pom.xml", "<project/>", "Add api")
+	master, _ := remote.Head()
+	remote.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("merged"), master.Hash()))
+
+	cache := filepath.Join(dir, "cache")
+	repo, _ := vcs.NewGit(vcs.TokenAuth{User: "x-access-token", Token: "secret"}, source, cache, "web/", vcs.CloneOptions{Sparse: true, Cached: true}, vcs.CommitOptions{})
+	if err := repo.Clone(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(cache, "api")); !os.IsNotExist(err) {
+		t.Error("Should only have checked out the base path")
+	}
+	if _, err := os.Stat(filepath.Join(cache, "web", "package.json")); err != nil {
+		t.Errorf("Should have checked out the base path: %s", err)
+	}
+
+	repo.Branch(context.Background(), "lure-leftover")
+	ioutil.WriteFile(filepath.Join(cache, "web", "untracked.txt"), []byte("left by an earlier run"), 0644)
+	remote.Storer.RemoveReference(plumbing.NewBranchReferenceName("merged"))
+	latest := commitFile(t, remote, "web/README.md", "catfeeder", "Add readme")
+
+	if err := repo.Clone(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	branches, _ := repo.ActiveBranches(context.Background())
+	if strings.Contains(strings.Join(branches, ","), "merged") {
+		t.Errorf("Should have pruned the deleted branch, got %q", branches)
+	}
+	if _, err := os.Stat(filepath.Join(cache, "web", "untracked.txt")); !os.IsNotExist(err) {
+		t.Error("Should have removed the untracked files")
+	}
+	if out, _ := repo.Cmd(context.Background(), "branch", "--list"); strings.Contains(out, "lure-leftover") {
+		t.Errorf("Should have removed the local branches, got %s", out)
+	}
+	if _, err := repo.Update(context.Background(), "master"); err != nil {
+		t.Fatal(err)
+	}
+	if commits, _ := repo.CommitsBetween(context.Background(), latest.String(), "master"); len(commits) != 0 {
+		t.Errorf("Master should be at the latest remote commit, %q are ahead", commits)
+	}
+}
+
+func TestGitShouldUpdateWorktreesSeparately(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir, err := ioutil.TempDir("", "lure-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "source")
+	remote, err := git.PlainInit(source, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, remote, "web/package.json", `{"name": "catfeeder"}`, "Add web")
+	commitFile(t, remote, "api/pom.xml", "<project/>", "Add api")
+
+	clone := filepath.Join(dir, "clone")
+	repo, _ := vcs.NewGit(vcs.TokenAuth{User: "x-access-token", Token: "secret"}, source, clone, "web", vcs.CloneOptions{Sparse: true}, vcs.CommitOptions{AuthorName: "lure", AuthorEmail: "lure@example.com"})
+	if err := repo.Clone(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var worktrees []vcs.SourceControl
+	for _, name := range []string{"lodash", "left-pad"} {
+		worktree, err := repo.AddWorktree(context.Background(), filepath.Join(dir, "clone-"+name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// both check out master, which the clone checks out as well
+		if _, err := worktree.Update(context.Background(), "master"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := worktree.Branch(context.Background(), "lure-"+name); err != nil {
+			t.Fatal(err)
+		}
+		ioutil.WriteFile(filepath.Join(worktree.WorkingPath(), name+".txt"), []byte(name), 0644)
+		if _, err := worktree.Commit(context.Background(), "Update "+name); err != nil {
+			t.Fatal(err)
+		}
+		worktrees = append(worktrees, worktree)
+	}
+
+	if worktrees[0].WorkingPath() != filepath.Join(dir, "clone-lodash", "web") {
+		t.Errorf("Should work in the base path of the worktree, got %s", worktrees[0].WorkingPath())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "clone-lodash", "api")); !os.IsNotExist(err) {
+		t.Error("Should only have checked out the base path")
+	}
+	if _, err := os.Stat(filepath.Join(clone, "web", "lodash.txt")); !os.IsNotExist(err) {
+		t.Error("Should not have changed the clone")
+	}
+	if commits, _ := repo.CommitsBetween(context.Background(), "master", "lure-left-pad"); len(commits) != 1 {
+		t.Errorf("Should have committed only left-pad on its branch, got %q", commits)
+	}
+
+	if err := repo.RemoveWorktree(context.Background(), filepath.Join(dir, "clone-lodash")); err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := repo.Cmd(context.Background(), "worktree", "list"); strings.Contains(out, "clone-lodash") {
+		t.Errorf("Should have removed the worktree, got %s", out)
+	}
+}
+
+func TestGitShouldCleanTheChangesOfTheWorkingCopy(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir, err := ioutil.TempDir("", "lure-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "source")
+	remote, err := git.PlainInit(source, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, remote, "pom.xml", "<project/>", "Add pom")
+	commitFile(t, remote, ".gitignore", "target/\n", "Ignore target")
+
+	clone := filepath.Join(dir, "clone")
+	repo, _ := vcs.NewGit(vcs.TokenAuth{User: "x-access-token", Token: "secret"}, source, clone, "", vcs.CloneOptions{}, vcs.CommitOptions{})
+	if err := repo.Clone(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(clone, "pom.xml"), []byte("<proj"), 0644)
+	os.MkdirAll(filepath.Join(clone, "lib", "target"), 0755)
+	ioutil.WriteFile(filepath.Join(clone, "lib", "pom.xml.bak"), []byte("<project/>"), 0644)
+	ioutil.WriteFile(filepath.Join(clone, "lib", "target", "feeder.jar"), []byte("jar"), 0644)
+
+	if err := repo.Clean(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if content, _ := ioutil.ReadFile(filepath.Join(clone, "pom.xml")); string(content) != "<project/>" {
+		t.Errorf("Should have restored pom.xml, got %s", content)
+	}
+	if _, err := os.Stat(filepath.Join(clone, "lib", "pom.xml.bak")); !os.IsNotExist(err) {
+		t.Error("Should have removed the untracked files")
+	}
+	if _, err := os.Stat(filepath.Join(clone, "lib", "target", "feeder.jar")); err != nil {
+		t.Errorf("Should have kept the ignored files, got %s", err)
+	}
+}
+
+func TestGitShouldListTheMissingCommitsOldestFirst(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir, err := ioutil.TempDir("", "lure-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "source")
+	remote, err := git.PlainInit(source, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, remote, "README", "catfeeder", "Initial commit")
+
+	clone := filepath.Join(dir, "clone")
+	repo, _ := vcs.NewGit(vcs.TokenAuth{}, source, clone, "", vcs.CloneOptions{}, vcs.CommitOptions{AuthorName: "lure", AuthorEmail: "lure@example.com"})
+	if err := repo.Clone(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	commit := func(name string) string {
+		ioutil.WriteFile(filepath.Join(clone, name), []byte(name), 0644)
+		if _, err := repo.Commit(context.Background(), "Add "+name); err != nil {
+			t.Fatal(err)
+		}
+		hash, _ := repo.Cmd(context.Background(), "log", "-1", "--pretty=%h")
+		return strings.TrimSpace(hash)
+	}
+	repo.Branch(context.Background(), "staging")
+	oldest := commit("feeder.py")
+	newest := commit("bowl.py")
+	repo.Update(context.Background(), "master")
+	commit("hotfix.py")
+
+	// the last one names the merge branch of synchronizedBranches
+	commits, err := repo.CommitsBetween(context.Background(), "master", "staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(commits, ",") != oldest+","+newest {
+		t.Errorf("Expected only the commits of staging, oldest first: %s,%s, got %q", oldest, newest, commits)
+	}
+}
+
+func TestGitShouldDateBranchesFromTheirFetchWhenCached(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir, err := ioutil.TempDir("", "lure-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "source")
+	remote, err := git.PlainInit(source, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worktree, _ := remote.Worktree()
+	ioutil.WriteFile(filepath.Join(source, "README"), []byte("catfeeder"), 0644)
+	worktree.Add("README")
+	old := time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC)
+	hash, err := worktree.Commit("Initial commit", &git.CommitOptions{Author: &object.Signature{Name: "Someone", Email: "someone@example.com", When: old}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clone := filepath.Join(dir, "clone")
+	repo, _ := vcs.NewGit(vcs.TokenAuth{}, source, clone, "", vcs.CloneOptions{Cached: true}, vcs.CommitOptions{})
+	if err := repo.Clone(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// pushed by a colleague at the old commit after the cache was created
+	remote.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("lure-colleague"), hash))
+	if err := repo.Clone(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if date, err := repo.BranchDate(context.Background(), "master"); err != nil || !date.Equal(old) {
+		t.Errorf("Should have dated master from its commit, got %s %v", date, err)
+	}
+	if date, err := repo.BranchDate(context.Background(), "lure-colleague"); err != nil || time.Since(date) > time.Hour {
+		t.Errorf("Should have dated the new branch from its fetch, got %s %v", date, err)
+	}
+}
+
+func TestGitShouldSignCommitsAsTheConfiguredAuthor(t *testing.T) {
+	for _, command := range []string{"git", "ssh-keygen"} {
+		if _, err := exec.LookPath(command); err != nil {
+			t.Skipf("%s is not installed", command)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "lure-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key := filepath.Join(dir, "lure_ed25519")
+	if out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", key).CombinedOutput(); err != nil {
+		t.Fatalf("Could not generate a key: %s %s", err, out)
+	}
+	source := filepath.Join(dir, "source")
+	remote, err := git.PlainInit(source, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, remote, "package.json", `{"name": "catfeeder"}`, "Initial commit")
+
+	clone := filepath.Join(dir, "clone")
+	commitOptions := vcs.CommitOptions{AuthorName: "lure-bot", AuthorEmail: "lure-bot@example.com", SigningFormat: vcs.SSH, SigningKey: key}
+	repo, err := vcs.NewGit(vcs.TokenAuth{User: "x-access-token", Token: "secret"}, source, clone, "", vcs.CloneOptions{}, commitOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Clone(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	repo.Branch(context.Background(), "lure-left_pad-1_3_0")
+	ioutil.WriteFile(filepath.Join(clone, "package.json"), []byte(`{"name": "catfeeder", "version": "1.0.1"}`), 0644)
+	if _, err := repo.Commit(context.Background(), "Update left-pad to 1.3.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	commit, err := repo.Cmd(context.Background(), "cat-file", "-p", "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(commit, "\nauthor lure-bot <lure-bot@example.com>") || !strings.Contains(commit, "\ncommitter lure-bot <lure-bot@example.com>") {
+		t.Errorf("Should have committed as lure-bot, got %s", commit)
+	}
+	if !strings.Contains(commit, "gpgsig -----BEGIN SSH SIGNATURE-----") {
+		t.Errorf("Should have signed the commit, got %s", commit)
+	}
+}
+
+func TestGitShouldRejectUnknownSigningFormats(t *testing.T) {
+	if _, err := vcs.NewGit(vcs.TokenAuth{}, "https://github.com/coveooss/lure.git", "/tmp/lure", "", vcs.CloneOptions{}, vcs.CommitOptions{SigningFormat: "x509", SigningKey: "key"}); err == nil {
+		t.Error("Should not accept x509 signing")
+	}
+	if _, err := vcs.NewGit(vcs.TokenAuth{}, "https://github.com/coveooss/lure.git", "/tmp/lure", "", vcs.CloneOptions{}, vcs.CommitOptions{SigningFormat: vcs.GPG}); err == nil {
+		t.Error("Should require a signing key")
+	}
+}
+
+func TestGitShouldGoThroughTheProxyOfTheProject(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	var requested []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Host)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer proxy.Close()
+
+	dir, err := ioutil.TempDir("", "lure-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo, err := vcs.NewGit(vcs.TokenAuth{}, "http://git.example.com/lure.git", filepath.Join(dir, "clone"), "", vcs.CloneOptions{Proxy: proxy.URL}, vcs.CommitOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.Clone(context.Background()); err == nil {
+		t.Error("Should not clone from the proxy")
+	}
+	if len(requested) == 0 || requested[0] != "git.example.com" {
+		t.Errorf("Should have asked the proxy for the remote, got %v", requested)
+	}
+}
+
+func TestGitShouldReturnTypedErrors(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir, err := ioutil.TempDir("", "lure-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "source")
+	remote, err := git.PlainInit(source, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, remote, "package.json", `{"name": "catfeeder"}`, "Initial commit")
+
+	clone := filepath.Join(dir, "clone")
+	repo, _ := vcs.NewGit(vcs.TokenAuth{User: "x-access-token", Token: "secret"}, source, clone, "", vcs.CloneOptions{}, vcs.CommitOptions{AuthorName: "lure", AuthorEmail: "lure@example.com"})
+	if err := repo.Clone(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.Commit(context.Background(), "Nothing"); !errors.Is(err, vcs.ErrNothingToCommit) {
+		t.Errorf("Expected nothing to commit, got %v", err)
+	}
+	if _, err := repo.Update(context.Background(), "release/9.9"); !errors.Is(err, vcs.ErrNotFound) {
+		t.Errorf("Expected the branch not to be found, got %v", err)
+	}
+	if _, err := repo.Branch(context.Background(), "master"); !errors.Is(err, vcs.ErrBranchExists) {
+		t.Errorf("Expected the branch to exist, got %v", err)
+	}
+
+	_, err = repo.Update(context.Background(), "release/9.9")
+	var vcsErr *vcs.Error
+	if !errors.As(err, &vcsErr) || vcsErr.ExitCode == 0 || !strings.Contains(vcsErr.Stderr, "release/9.9") {
+		t.Errorf("Expected the exit code and stderr of git, got %#v", err)
+	}
+
+	for _, branch := range []string{"feeder", "master"} {
+		if _, err := repo.Update(context.Background(), "master"); err != nil {
+			t.Fatal(err)
+		}
+		if branch != "master" {
+			if _, err := repo.Branch(context.Background(), branch); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := ioutil.WriteFile(filepath.Join(clone, "package.json"), []byte(`{"name": "`+branch+`"}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.Commit(context.Background(), "Rename the package on "+branch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := repo.Merge(context.Background(), "feeder", "Merge feeder into master"); !errors.Is(err, vcs.ErrConflict) {
+		t.Errorf("Expected a conflict, got %v", err)
+	}
+}
+
+func TestGitShouldStopCommandsWhenTheContextIsDone(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir, err := ioutil.TempDir("", "lure-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "source")
+	remote, err := git.PlainInit(source, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, remote, "package.json", `{"name": "catfeeder"}`, "Initial commit")
+
+	clone := filepath.Join(dir, "clone")
+	repo, _ := vcs.NewGit(vcs.TokenAuth{User: "x-access-token", Token: "secret"}, source, clone, "", vcs.CloneOptions{}, vcs.CommitOptions{AuthorName: "lure", AuthorEmail: "lure@example.com"})
+	if err := repo.Clone(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the hook outlives git unless the whole process group is stopped
+	if err := ioutil.WriteFile(filepath.Join(clone, ".git", "hooks", "pre-commit"), []byte("#!/bin/sh\nsleep 30\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(clone, "package.json"), []byte(`{"name": "catfeeder", "version": "1.0.0"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := repo.Commit(ctx, "Update version"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the deadline to be exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Expected the hook to be stopped, the commit took %s", elapsed)
+	}
+}
+
+func TestGitShouldLogCommits(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir, err := ioutil.TempDir("", "lure-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "source")
+	remote, err := git.PlainInit(source, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := commitFile(t, remote, "package.json", `{"name": "catfeeder"}`, "Initial commit")
+	commitFile(t, remote, "web/README.md", "catfeeder", "Add readme\n\nFor CAT-42")
+	head := commitFile(t, remote, "api/pom.xml", "<project/>", "Add api")
+
+	clone := filepath.Join(dir, "clone")
+	repo, _ := vcs.NewGit(vcs.TokenAuth{User: "x-access-token", Token: "secret"}, source, clone, "", vcs.CloneOptions{}, vcs.CommitOptions{})
+	if err := repo.Clone(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	commits, err := repo.Log(context.Background(), base.String(), "origin/master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) != 2 {
+		t.Fatalf("Expected the 2 commits after the base, got %+v", commits)
+	}
+	first, second := commits[0], commits[1]
+	if first.Message != "Add readme\n\nFor CAT-42" || first.Subject() != "Add readme" || strings.Join(first.Files, ",") != "web/README.md" {
+		t.Errorf("Unexpected first commit %+v", first)
+	}
+	if first.Author != "Someone" || first.Email != "someone@example.com" || time.Since(first.Date) > time.Minute {
+		t.Errorf("Unexpected author %s <%s> on %s", first.Author, first.Email, first.Date)
+	}
+	if second.Hash != head.String() || !strings.HasPrefix(head.String(), second.ShortHash) {
+		t.Errorf("Expected %s last, got %s (%s)", head, second.Hash, second.ShortHash)
+	}
+}
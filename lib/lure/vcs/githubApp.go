@@ -0,0 +1,173 @@
+package vcs
+
+import (
+	"context"
+	"crypto/rsa"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coveooss/lure/lib/lure/log"
+	"github.com/golang-jwt/jwt"
+	"github.com/google/go-github/v32/github"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// gitHubAppJWTLifetime stays under the 10 minutes accepted by GitHub
+	gitHubAppJWTLifetime = 9 * time.Minute
+	// installationTokenRefreshMargin renews installation tokens this long before they expire
+	installationTokenRefreshMargin = 5 * time.Minute
+)
+
+// OwnerAuthentication is an Authentication whose credentials depend on the owner of the repository.
+// ForOwner gets them through transport, the one of the project.
+type OwnerAuthentication interface {
+	Authentication
+	ForOwner(owner string, transport http.RoundTripper) Authentication
+}
+
+// GitHubAppAuth authenticates as a GitHub App. By itself, it only gives access to the app endpoints:
+// ForOwner returns the authentication of the installation of the app on an owner.
+type GitHubAppAuth struct {
+	AppID      int64
+	PrivateKey *rsa.PrivateKey
+	// APIURL is the API of a GitHub Enterprise Server, empty for github.com
+	APIURL string
+
+	installations *installationTokenSources
+}
+
+type installationTokenSources struct {
+	sync.Mutex
+	byOwner map[string]oauth2.TokenSource
+}
+
+func NewGitHubAppAuth(appID int64, privateKey []byte, apiURL string) (GitHubAppAuth, error) {
+	key, err := jwt.ParseRSAPrivateKeyFromPEM(privateKey)
+	if err != nil {
+		return GitHubAppAuth{}, err
+	}
+
+	return GitHubAppAuth{
+		AppID:         appID,
+		PrivateKey:    key,
+		APIURL:        apiURL,
+		installations: &installationTokenSources{byOwner: map[string]oauth2.TokenSource{}},
+	}, nil
+}
+
+// signJWT returns a JWT identifying the app, backdated a minute against clock drift
+func (auth GitHubAppAuth) signJWT() (string, error) {
+	now := time.Now()
+	claims := jwt.StandardClaims{
+		IssuedAt:  now.Add(-time.Minute).Unix(),
+		ExpiresAt: now.Add(gitHubAppJWTLifetime).Unix(),
+		Issuer:    strconv.FormatInt(auth.AppID, 10),
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(auth.PrivateKey)
+}
+
+func (auth GitHubAppAuth) AuthenticateURL(url string) string {
+	return strings.Replace(url, "://", fmt.Sprintf("://x-access-token:%s@", auth.appToken()), 1)
+}
+
+func (auth GitHubAppAuth) AuthenticateHTTPRequest(header header) {
+	header.Add("Authorization", "Bearer "+auth.appToken())
+}
+
+func (auth GitHubAppAuth) AuthenticateWithToken(transport http.RoundTripper) *http.Client {
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: transport})
+	ts := oauth2.StaticTokenSource(
+		&oauth2.Token{AccessToken: auth.appToken()},
+	)
+	return oauth2.NewClient(ctx, ts)
+}
+
+func (auth GitHubAppAuth) appToken() string {
+	token, err := auth.signJWT()
+	if err != nil {
+		log.Logger.Errorf("\"Could not sign the GitHub App JWT\" %s", err)
+	}
+	return token
+}
+
+// ForOwner returns the authentication of the installation on owner, sharing its token with the other projects of owner.
+// The tokens are created through the transport of the first project of owner.
+func (auth GitHubAppAuth) ForOwner(owner string, transport http.RoundTripper) Authentication {
+	auth.installations.Lock()
+	defer auth.installations.Unlock()
+
+	tokens, ok := auth.installations.byOwner[owner]
+	if !ok {
+		tokens = oauth2.ReuseTokenSource(nil, installationTokenSource{app: auth, owner: owner, transport: transport})
+		auth.installations.byOwner[owner] = tokens
+	}
+	return gitHubInstallationAuth{tokens: tokens}
+}
+
+// installationTokenSource creates a new installation token each time the previous one is about to expire
+type installationTokenSource struct {
+	app       GitHubAppAuth
+	owner     string
+	transport http.RoundTripper
+}
+
+func (source installationTokenSource) Token() (*oauth2.Token, error) {
+	httpClient := source.app.AuthenticateWithToken(source.transport)
+	client := github.NewClient(httpClient)
+	if source.app.APIURL != "" {
+		var err error
+		if client, err = github.NewEnterpriseClient(source.app.APIURL, source.app.APIURL, httpClient); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx := context.Background()
+	installation, _, err := client.Apps.FindOrganizationInstallation(ctx, source.owner)
+	if err != nil {
+		installation, _, err = client.Apps.FindUserInstallation(ctx, source.owner)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not find the installation of the GitHub App on %s: %s", source.owner, err)
+	}
+
+	token, _, err := client.Apps.CreateInstallationToken(ctx, installation.GetID(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("Could not create an installation token for %s: %s", source.owner, err)
+	}
+	log.Logger.Infof("Created a GitHub App installation token for %s expiring at %s", source.owner, token.GetExpiresAt())
+
+	return &oauth2.Token{
+		AccessToken: token.GetToken(),
+		Expiry:      token.GetExpiresAt().Add(-installationTokenRefreshMargin),
+	}, nil
+}
+
+type gitHubInstallationAuth struct {
+	tokens oauth2.TokenSource
+}
+
+func (auth gitHubInstallationAuth) token() string {
+	token, err := auth.tokens.Token()
+	if err != nil {
+		log.Logger.Errorf("\"Could not get the GitHub App installation token\" %s", err)
+		return ""
+	}
+	return token.AccessToken
+}
+
+func (auth gitHubInstallationAuth) AuthenticateURL(url string) string {
+	return strings.Replace(url, "://", fmt.Sprintf("://x-access-token:%s@", auth.token()), 1)
+}
+
+func (auth gitHubInstallationAuth) AuthenticateHTTPRequest(header header) {
+	header.Add("Authorization", "Bearer "+auth.token())
+}
+
+func (auth gitHubInstallationAuth) AuthenticateWithToken(transport http.RoundTripper) *http.Client {
+	return &http.Client{Transport: &oauth2.Transport{Source: auth.tokens, Base: transport}}
+}
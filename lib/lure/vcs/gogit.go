@@ -0,0 +1,721 @@
+package vcs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/storage"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"golang.org/x/crypto/openpgp"
+
+	"github.com/coveooss/lure/lib/lure/log"
+)
+
+const goGitRemote = "origin"
+
+// GoGitRepo is an in-process git implementation of SourceControl, no git binary required.
+// Credentials are given to the transport instead of being written in the remote URL.
+type GoGitRepo struct {
+	workingPath    string
+	localPath      string
+	source         string
+	authentication Authentication
+	cloneOptions   CloneOptions
+	commitOptions  CommitOptions
+	signKey        *openpgp.Entity
+
+	// storage and worktree hold in-memory repositories, the repository is opened from localPath otherwise
+	storage  storage.Storer
+	worktree billy.Filesystem
+}
+
+// NewGoGit ignores the Filter and Sparse clone options, go-git supporting neither partial clones nor sparse checkouts.
+// Commits are signed with gpg only, the signing key being the path of an armored private key without passphrase.
+func NewGoGit(auth Authentication, source string, to string, basePath string, cloneOptions CloneOptions, commitOptions CommitOptions) (GoGitRepo, error) {
+	if cloneOptions.Filter != "" || cloneOptions.Sparse {
+		log.Logger.Warn("The go-git backend does not support partial clones nor sparse checkouts, the whole repository is cloned")
+	}
+	if err := commitOptions.validate(); err != nil {
+		return GoGitRepo{}, err
+	}
+	signKey, err := readSignKey(commitOptions)
+	if err != nil {
+		return GoGitRepo{}, err
+	}
+
+	var workingPath strings.Builder
+	workingPath.WriteString(to)
+	workingPath.WriteString("/")
+	workingPath.WriteString(basePath)
+
+	return GoGitRepo{
+		workingPath:    workingPath.String(),
+		localPath:      to,
+		source:         goGitSource(auth, source),
+		authentication: auth,
+		cloneOptions:   cloneOptions,
+		commitOptions:  commitOptions,
+		signKey:        signKey,
+	}, nil
+}
+
+func readSignKey(commitOptions CommitOptions) (*openpgp.Entity, error) {
+	switch commitOptions.SigningFormat {
+	case "":
+		return nil, nil
+	case SSH:
+		return nil, errors.New("the go-git backend cannot sign commits with ssh")
+	}
+
+	file, err := os.Open(commitOptions.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entities, err := openpgp.ReadArmoredKeyRing(file)
+	if err != nil {
+		return nil, err
+	}
+	for _, entity := range entities {
+		if entity.PrivateKey != nil && !entity.PrivateKey.Encrypted {
+			return entity, nil
+		}
+	}
+	return nil, fmt.Errorf("%s holds no private key without passphrase", commitOptions.SigningKey)
+}
+
+// NewGoGitInMemory clones source in memory, package managers having no files to work on, mostly for tests
+func NewGoGitInMemory(auth Authentication, source string) GoGitRepo {
+	return GoGitRepo{
+		source:         goGitSource(auth, source),
+		authentication: auth,
+		storage:        memory.NewStorage(),
+		worktree:       memfs.New(),
+	}
+}
+
+func (gitRepo GoGitRepo) SanitizeBranchName(branchName string) string {
+	return sanitizeGitBranchName(branchName)
+}
+
+// Clone clones the source, or refreshes the clone of an earlier run when cached
+func (gitRepo GoGitRepo) Clone(ctx context.Context) error {
+	if gitRepo.cloneOptions.Cached {
+		if repository, err := gitRepo.openRepository(); err == nil {
+			return gitRepo.refresh(ctx, repository)
+		}
+	}
+
+	log.For(ctx).Infof("cloning to %s", gitRepo.localPath)
+	auth, err := gitRepo.transportAuth()
+	if err != nil {
+		return err
+	}
+	options := &git.CloneOptions{URL: gitRepo.source, Auth: auth, Depth: gitRepo.cloneOptions.Depth}
+
+	if gitRepo.storage != nil {
+		_, err = git.CloneContext(ctx, gitRepo.storage, gitRepo.worktree, options)
+	} else {
+		_, err = git.PlainCloneContext(ctx, gitRepo.localPath, false, options)
+	}
+	return goGitError(err)
+}
+
+// refresh fetches the source and drops what an earlier run left behind: local branches, changes and untracked files
+func (gitRepo GoGitRepo) refresh(ctx context.Context, repository *git.Repository) error {
+	log.For(ctx).Infof("refreshing %s", gitRepo.localPath)
+	auth, err := gitRepo.transportAuth()
+	if err != nil {
+		return err
+	}
+	fetched, err := remoteBranchCommits(repository)
+	if err != nil {
+		return err
+	}
+	err = repository.FetchContext(ctx, &git.FetchOptions{
+		RemoteName: goGitRemote,
+		RefSpecs:   []config.RefSpec{config.RefSpec(fmt.Sprintf("+refs/heads/*:refs/remotes/%s/*", goGitRemote))},
+		Depth:      gitRepo.cloneOptions.Depth,
+		Auth:       auth,
+		Force:      true,
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return goGitError(err)
+	}
+	if err := recordFetchDates(repository, fetched, time.Now()); err != nil {
+		return err
+	}
+	head, err := gitRepo.pruneRemoteBranches(ctx, repository, auth)
+	if err != nil {
+		return err
+	}
+
+	worktree, err := repository.Worktree()
+	if err != nil {
+		return err
+	}
+	if err := worktree.Checkout(&git.CheckoutOptions{Hash: head, Force: true}); err != nil {
+		return err
+	}
+	if err := worktree.Clean(&git.CleanOptions{Dir: true}); err != nil {
+		return err
+	}
+
+	branches, err := repository.Branches()
+	if err != nil {
+		return err
+	}
+	return branches.ForEach(func(branch *plumbing.Reference) error {
+		return repository.Storer.RemoveReference(branch.Name())
+	})
+}
+
+// fetchDateSection is the git config section recording when a refresh fetched the current commit of each remote
+// branch, as go-git keeps no reflog
+const fetchDateSection = "lure-fetched"
+
+// remoteBranchCommits returns the commits of the remote branches by branch name
+func remoteBranchCommits(repository *git.Repository) (map[string]plumbing.Hash, error) {
+	references, err := repository.References()
+	if err != nil {
+		return nil, err
+	}
+	commits := map[string]plumbing.Hash{}
+	err = references.ForEach(func(reference *plumbing.Reference) error {
+		if reference.Name().IsRemote() && reference.Type() == plumbing.HashReference {
+			commits[strings.TrimPrefix(reference.Name().Short(), goGitRemote+"/")] = reference.Hash()
+		}
+		return nil
+	})
+	return commits, err
+}
+
+// recordFetchDates dates the remote branches the fetch created or moved away from their previous commits and forgets
+// the deleted ones
+func recordFetchDates(repository *git.Repository, previous map[string]plumbing.Hash, date time.Time) error {
+	current, err := remoteBranchCommits(repository)
+	if err != nil {
+		return err
+	}
+	cfg, err := repository.Config()
+	if err != nil {
+		return err
+	}
+	section := cfg.Raw.Section(fetchDateSection)
+	kept := section.Subsections[:0]
+	for _, subsection := range section.Subsections {
+		if _, ok := current[subsection.Name]; ok {
+			kept = append(kept, subsection)
+		}
+	}
+	section.Subsections = kept
+	for branch, hash := range current {
+		if previous[branch] != hash {
+			section.Subsection(branch).SetOption("date", strconv.FormatInt(date.Unix(), 10))
+		}
+	}
+	return repository.SetConfig(cfg)
+}
+
+// pruneRemoteBranches removes the remote branches deleted from the source since the last fetch.
+// It returns the commit of the source HEAD.
+func (gitRepo GoGitRepo) pruneRemoteBranches(ctx context.Context, repository *git.Repository, auth transport.AuthMethod) (plumbing.Hash, error) {
+	remote, err := repository.Remote(goGitRemote)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	remoteReferences, err := remote.List(&git.ListOptions{Auth: auth})
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	existing := map[string]plumbing.Hash{}
+	var head *plumbing.Reference
+	for _, reference := range remoteReferences {
+		if reference.Name().IsBranch() {
+			existing[reference.Name().String()] = reference.Hash()
+		} else if reference.Name() == plumbing.HEAD {
+			head = reference
+		}
+	}
+	if head == nil {
+		return plumbing.ZeroHash, errors.New("the source has no HEAD")
+	}
+	headHash := head.Hash()
+	if head.Type() == plumbing.SymbolicReference {
+		headHash = existing[head.Target().String()]
+	}
+
+	branches, err := gitRepo.ActiveBranches(ctx)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	for _, branch := range branches {
+		if _, ok := existing[plumbing.NewBranchReferenceName(branch).String()]; !ok {
+			if err := repository.Storer.RemoveReference(plumbing.NewRemoteReferenceName(goGitRemote, branch)); err != nil {
+				return plumbing.ZeroHash, err
+			}
+		}
+	}
+	return headHash, nil
+}
+
+func (gitRepo GoGitRepo) WorkingPath() string {
+	return gitRepo.workingPath
+}
+
+func (gitRepo GoGitRepo) LocalPath() string {
+	return gitRepo.localPath
+}
+
+// RemotePath returns the source without credentials, they are only given to the transport
+func (gitRepo GoGitRepo) RemotePath() string {
+	return gitRepo.source
+}
+
+// goGitSource clones over SSH with an SSH authentication, the other credentials go to the transport
+func goGitSource(auth Authentication, source string) string {
+	if _, ok := auth.(SSHAuth); ok {
+		return auth.AuthenticateURL(source)
+	}
+	return withoutURLUser(source)
+}
+
+// transportAuth authenticates the source at each call, the credentials possibly expiring during long runs
+func (gitRepo GoGitRepo) transportAuth() (transport.AuthMethod, error) {
+	switch auth := gitRepo.authentication.(type) {
+	case nil:
+		return nil, nil
+	case SSHAuth:
+		return auth.transportAuth()
+	case CredentialHelperAuth:
+		username, password := auth.credentials(gitRepo.source)
+		return &githttp.BasicAuth{Username: username, Password: password}, nil
+	}
+
+	authenticated, err := url.Parse(gitRepo.authentication.AuthenticateURL(gitRepo.source))
+	if err != nil || authenticated.User == nil {
+		return nil, nil
+	}
+	password, _ := authenticated.User.Password()
+	return &githttp.BasicAuth{Username: authenticated.User.Username(), Password: password}, nil
+}
+
+func (gitRepo GoGitRepo) openRepository() (*git.Repository, error) {
+	if gitRepo.storage != nil {
+		return git.Open(gitRepo.storage, gitRepo.worktree)
+	}
+	return git.PlainOpen(gitRepo.localPath)
+}
+
+func (gitRepo GoGitRepo) open() (*git.Repository, *git.Worktree, error) {
+	repository, err := gitRepo.openRepository()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	worktree, err := repository.Worktree()
+	if err != nil {
+		return nil, nil, err
+	}
+	return repository, worktree, nil
+}
+
+// Cmd is not available without a git binary
+func (gitRepo GoGitRepo) Cmd(ctx context.Context, args ...string) (string, error) {
+	return "", fmt.Errorf("the go-git backend cannot run 'git %s'", strings.Join(args, " "))
+}
+
+// Update checks out rev, creating the local branch of a remote one as git checkout does
+func (gitRepo GoGitRepo) Update(ctx context.Context, rev string) (string, error) {
+	repository, worktree, err := gitRepo.open()
+	if err != nil {
+		return "", err
+	}
+
+	branch := plumbing.NewBranchReferenceName(rev)
+	if _, err := repository.Reference(branch, false); err == nil {
+		return "", worktree.Checkout(&git.CheckoutOptions{Branch: branch})
+	}
+	if remoteBranch, err := repository.Reference(plumbing.NewRemoteReferenceName(goGitRemote, rev), true); err == nil {
+		return "", worktree.Checkout(&git.CheckoutOptions{Branch: branch, Hash: remoteBranch.Hash(), Create: true})
+	}
+
+	hash, err := repository.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return "", newGoGitError(ErrNotFound, fmt.Errorf("Could not find revision %s: %s", rev, err))
+	}
+	return "", worktree.Checkout(&git.CheckoutOptions{Hash: *hash})
+}
+
+// Clean drops the changes and the untracked files of the working copy
+func (gitRepo GoGitRepo) Clean(ctx context.Context) error {
+	_, worktree, err := gitRepo.open()
+	if err != nil {
+		return err
+	}
+	if err := worktree.Reset(&git.ResetOptions{Mode: git.HardReset}); err != nil {
+		return err
+	}
+	return worktree.Clean(&git.CleanOptions{Dir: true})
+}
+
+// Branch creates and checks out a branch from the current commit, keeping the working tree changes
+func (gitRepo GoGitRepo) Branch(ctx context.Context, branchname string) (string, error) {
+	repository, worktree, err := gitRepo.open()
+	if err != nil {
+		return "", err
+	}
+	head, err := repository.Head()
+	if err != nil {
+		return "", err
+	}
+
+	branch := plumbing.NewBranchReferenceName(gitRepo.SanitizeBranchName(branchname))
+	if _, err := repository.Reference(branch, false); err == nil {
+		return "", newGoGitError(ErrBranchExists, fmt.Errorf("a branch named '%s' already exists", branch.Short()))
+	}
+	return "", worktree.Checkout(&git.CheckoutOptions{Branch: branch, Hash: head.Hash(), Create: true, Keep: true})
+}
+
+func (gitRepo GoGitRepo) SoftBranch(ctx context.Context, branchname string) (string, error) {
+	return gitRepo.Branch(ctx, branchname)
+}
+
+// Commit stages every change, deletions included, and commits them with the configured author, or the one of the git config
+func (gitRepo GoGitRepo) Commit(ctx context.Context, message string) (string, error) {
+	_, worktree, err := gitRepo.open()
+	if err != nil {
+		return "", err
+	}
+
+	status, err := worktree.Status()
+	if err != nil {
+		return "", err
+	}
+	if status.IsClean() {
+		return "", newGoGitError(ErrNothingToCommit, errors.New("nothing to commit, working tree clean"))
+	}
+	for path, fileStatus := range status {
+		if fileStatus.Worktree == git.Deleted {
+			_, err = worktree.Remove(path)
+		} else if fileStatus.Worktree != git.Unmodified {
+			_, err = worktree.Add(path)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	hash, err := worktree.Commit(message, gitRepo.newCommitOptions())
+	if err != nil {
+		return "", err
+	}
+	return hash.String(), nil
+}
+
+// newCommitOptions applies the commit options, go-git reading the author from the git config otherwise
+func (gitRepo GoGitRepo) newCommitOptions() *git.CommitOptions {
+	options := &git.CommitOptions{SignKey: gitRepo.signKey}
+	if gitRepo.commitOptions.AuthorName != "" || gitRepo.commitOptions.AuthorEmail != "" {
+		options.Author = &object.Signature{Name: gitRepo.commitOptions.AuthorName, Email: gitRepo.commitOptions.AuthorEmail, When: time.Now()}
+	}
+	return options
+}
+
+// Merge merges rev into the current branch with a merge commit, as git merge --no-ff does.
+// go-git cannot merge diverging histories, so only a current branch behind rev can be merged.
+func (gitRepo GoGitRepo) Merge(ctx context.Context, rev string, message string) (string, error) {
+	repository, worktree, err := gitRepo.open()
+	if err != nil {
+		return "", err
+	}
+
+	head, err := repository.Head()
+	if err != nil {
+		return "", err
+	}
+	headCommit, err := repository.CommitObject(head.Hash())
+	if err != nil {
+		return "", err
+	}
+	hash, err := repository.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return "", newGoGitError(ErrNotFound, fmt.Errorf("Could not find revision %s: %s", rev, err))
+	}
+	revCommit, err := repository.CommitObject(*hash)
+	if err != nil {
+		return "", err
+	}
+
+	upToDate, err := revCommit.IsAncestor(headCommit)
+	if err != nil {
+		return "", err
+	}
+	if upToDate || revCommit.Hash == headCommit.Hash {
+		return "Already up to date.", nil
+	}
+	fastForward, err := headCommit.IsAncestor(revCommit)
+	if err != nil {
+		return "", err
+	}
+	if !fastForward {
+		return "", newGoGitError(ErrConflict, fmt.Errorf("the go-git backend cannot merge %s, its history diverged from the current branch", rev))
+	}
+
+	if err := worktree.Reset(&git.ResetOptions{Commit: revCommit.Hash, Mode: git.HardReset}); err != nil {
+		return "", err
+	}
+	options := gitRepo.newCommitOptions()
+	options.Parents = []plumbing.Hash{headCommit.Hash, revCommit.Hash}
+	merge, err := worktree.Commit(message, options)
+	if err != nil {
+		return "", err
+	}
+	return merge.String(), nil
+}
+
+// ConflictedFiles returns nothing, failed merges leaving no conflicts behind
+func (gitRepo GoGitRepo) ConflictedFiles(ctx context.Context) ([]string, error) {
+	return nil, nil
+}
+
+// AbortMerge does nothing, failed merges leaving the working tree untouched
+func (gitRepo GoGitRepo) AbortMerge(ctx context.Context) error {
+	return nil
+}
+
+// CherryPick is not supported by go-git
+func (gitRepo GoGitRepo) CherryPick(ctx context.Context, rev string) (string, error) {
+	return "", errors.New("the go-git backend cannot cherry-pick, use the git cli backend")
+}
+
+func (gitRepo GoGitRepo) AbortCherryPick(ctx context.Context) error {
+	return nil
+}
+
+// Push pushes the current branch to the branch of the same name
+func (gitRepo GoGitRepo) Push(ctx context.Context) (string, error) {
+	repository, _, err := gitRepo.open()
+	if err != nil {
+		return "", err
+	}
+	head, err := repository.Head()
+	if err != nil {
+		return "", err
+	}
+
+	refSpec := config.RefSpec(fmt.Sprintf("%s:%s", head.Name(), head.Name()))
+	return "", gitRepo.push(ctx, repository, refSpec)
+}
+
+func (gitRepo GoGitRepo) push(ctx context.Context, repository *git.Repository, refSpec config.RefSpec) error {
+	auth, err := gitRepo.transportAuth()
+	if err != nil {
+		return err
+	}
+	err = repository.PushContext(ctx, &git.PushOptions{
+		RemoteName: goGitRemote,
+		RefSpecs:   []config.RefSpec{refSpec},
+		Auth:       auth,
+	})
+	if err == git.NoErrAlreadyUpToDate {
+		return nil
+	}
+	return goGitError(err)
+}
+
+// goGitError wraps the go-git failures callers can act on, the others are returned untouched
+func goGitError(err error) error {
+	switch {
+	case errors.Is(err, transport.ErrAuthenticationRequired), errors.Is(err, transport.ErrAuthorizationFailed):
+		return newGoGitError(ErrAuth, err)
+	case errors.Is(err, transport.ErrRepositoryNotFound), errors.Is(err, plumbing.ErrReferenceNotFound), errors.Is(err, plumbing.ErrObjectNotFound):
+		return newGoGitError(ErrNotFound, err)
+	}
+	return err
+}
+
+func (gitRepo GoGitRepo) CommitsBetween(ctx context.Context, baseRev string, secondRev string) ([]string, error) {
+	commits, err := gitRepo.commitsBetween(baseRev, secondRev)
+	if err != nil {
+		return []string{}, err
+	}
+
+	hashes := []string{}
+	for _, commit := range commits {
+		hashes = append(hashes, commit.Hash.String()[:7])
+	}
+	return hashes, nil
+}
+
+// Log returns the commits of headRev missing from baseRev, from the oldest
+func (gitRepo GoGitRepo) Log(ctx context.Context, baseRev string, headRev string) ([]Commit, error) {
+	commits, err := gitRepo.commitsBetween(baseRev, headRev)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []Commit{}
+	for _, commit := range commits {
+		// as git log, the files of merges are not listed
+		var files []string
+		if commit.NumParents() < 2 {
+			stats, err := commit.Stats()
+			if err != nil {
+				return nil, err
+			}
+			for _, stat := range stats {
+				files = append(files, stat.Name)
+			}
+		}
+
+		entries = append(entries, Commit{
+			Hash:      commit.Hash.String(),
+			ShortHash: commit.Hash.String()[:7],
+			Author:    commit.Author.Name,
+			Email:     commit.Author.Email,
+			Date:      commit.Author.When,
+			Message:   strings.TrimSpace(commit.Message),
+			Files:     files,
+		})
+	}
+	return entries, nil
+}
+
+// commitsBetween returns the commits of secondRev missing from baseRev, from the oldest as git log --reverse does
+func (gitRepo GoGitRepo) commitsBetween(baseRev string, secondRev string) ([]*object.Commit, error) {
+	repository, _, err := gitRepo.open()
+	if err != nil {
+		return nil, err
+	}
+
+	baseCommit, err := gitRepo.commit(repository, baseRev)
+	if err != nil {
+		return nil, err
+	}
+	secondCommit, err := gitRepo.commit(repository, secondRev)
+	if err != nil {
+		return nil, err
+	}
+
+	reachable := map[plumbing.Hash]bool{}
+	err = object.NewCommitPreorderIter(baseCommit, nil, nil).ForEach(func(commit *object.Commit) error {
+		reachable[commit.Hash] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	commits := []*object.Commit{}
+	err = object.NewCommitPreorderIter(secondCommit, reachable, nil).ForEach(func(commit *object.Commit) error {
+		commits = append([]*object.Commit{commit}, commits...)
+		return nil
+	})
+	return commits, err
+}
+
+func (gitRepo GoGitRepo) commit(repository *git.Repository, rev string) (*object.Commit, error) {
+	hash, err := repository.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return nil, newGoGitError(ErrNotFound, fmt.Errorf("Could not find revision %s: %s", rev, err))
+	}
+	return repository.CommitObject(*hash)
+}
+
+// ActiveBranches returns all currently active branches without origin/ prefix
+func (gitRepo GoGitRepo) ActiveBranches(ctx context.Context) ([]string, error) {
+	repository, _, err := gitRepo.open()
+	if err != nil {
+		return nil, err
+	}
+	references, err := repository.References()
+	if err != nil {
+		return nil, err
+	}
+
+	var branches []string
+	remotePrefix := "refs/remotes/" + goGitRemote + "/"
+	err = references.ForEach(func(reference *plumbing.Reference) error {
+		name := reference.Name().String()
+		if strings.HasPrefix(name, remotePrefix) && name != remotePrefix+"HEAD" {
+			branches = append(branches, strings.TrimPrefix(name, remotePrefix))
+		}
+		return nil
+	})
+
+	// sorted like git branch -r lists them
+	sort.Strings(branches)
+	return branches, err
+}
+
+// CloseBranch deletes the branch for the remote repository
+func (gitRepo GoGitRepo) CloseBranch(ctx context.Context, branch string) error {
+	log.For(ctx).Infof("Closing branch %s.", branch)
+	repository, _, err := gitRepo.open()
+	if err != nil {
+		return err
+	}
+	return gitRepo.push(ctx, repository, config.RefSpec(":"+plumbing.NewBranchReferenceName(branch).String()))
+}
+
+// CloseBranches deletes the branches for the remote repository, going on when one of them fails
+func (gitRepo GoGitRepo) CloseBranches(ctx context.Context, branches []string) error {
+	var failed []string
+	for _, branch := range branches {
+		if err := gitRepo.CloseBranch(ctx, branch); err != nil {
+			log.For(ctx).Errorf("Error: \"Could not close branch %s\" %s", branch, err)
+			failed = append(failed, branch)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("Could not close branches %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// BranchDate returns the newest of the commit date of the branch and of when the refresh of a cached clone fetched
+// that commit, as the git cli does, so a branch just pushed at an old commit is recent
+func (gitRepo GoGitRepo) BranchDate(ctx context.Context, branch string) (time.Time, error) {
+	repository, _, err := gitRepo.open()
+	if err != nil {
+		return time.Time{}, err
+	}
+	commit, err := gitRepo.commit(repository, goGitRemote+"/"+branch)
+	if err != nil {
+		return time.Time{}, err
+	}
+	date := commit.Committer.When
+
+	cfg, err := repository.Config()
+	if err != nil {
+		return date, err
+	}
+	if seconds, err := strconv.ParseInt(cfg.Raw.Section(fetchDateSection).Subsection(branch).Option("date"), 10, 64); err == nil {
+		if fetched := time.Unix(seconds, 0); fetched.After(date) {
+			return fetched, nil
+		}
+	}
+	return date, nil
+}
+
+func (gitRepo GoGitRepo) GetName() string {
+	return Git
+}
@@ -0,0 +1,355 @@
+package vcs_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+
+	"github.com/coveooss/lure/lib/lure/vcs"
+)
+
+const memoryRemoteURL = "mem://git.example.com/lure/catfeeder.git"
+
+// newMemoryRemote serves an in-memory repository holding one commit on master at memoryRemoteURL
+func newMemoryRemote(t *testing.T) *git.Repository {
+	storage := memory.NewStorage()
+	remote, err := git.Init(storage, memfs.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, remote, "package.json", `{"name": "catfeeder"}`, "Initial commit")
+
+	client.InstallProtocol("mem", server.NewServer(server.MapLoader{memoryRemoteURL: storage}))
+	return remote
+}
+
+func commitFile(t *testing.T, repository *git.Repository, name string, content string, message string) plumbing.Hash {
+	worktree, err := repository.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := worktree.Filesystem.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte(content))
+	file.Close()
+
+	if _, err := worktree.Add(name); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := worktree.Commit(message, &git.CommitOptions{Author: &object.Signature{Name: "Someone", Email: "someone@example.com", When: time.Now()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+// withGitAuthor configures the author read by go-git commits
+func withGitAuthor(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "lure-git-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Join(dir, "git"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "git", "config"), []byte("[user]\n\tname = lure\n\temail = lure@example.com\n"), 0644)
+
+	previous, hadPrevious := os.LookupEnv("XDG_CONFIG_HOME")
+	os.Setenv("XDG_CONFIG_HOME", dir)
+	return func() {
+		if hadPrevious {
+			os.Setenv("XDG_CONFIG_HOME", previous)
+		} else {
+			os.Unsetenv("XDG_CONFIG_HOME")
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func newClonedGoGit(t *testing.T) vcs.GoGitRepo {
+	repo := vcs.NewGoGitInMemory(vcs.TokenAuth{User: "x-access-token", Token: "secret"}, memoryRemoteURL)
+	if err := repo.Clone(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+func TestGoGitShouldPushAndCloseBranches(t *testing.T) {
+	defer withGitAuthor(t)()
+	remote := newMemoryRemote(t)
+	repo := newClonedGoGit(t)
+
+	if _, err := repo.Update(context.Background(), "master"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Branch(context.Background(), "lure-left pad-1.3.0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Commit(context.Background(), "Update left-pad to 1.3.0"); !errors.Is(err, vcs.ErrNothingToCommit) {
+		t.Errorf("Should not commit a clean working tree, got %v", err)
+	}
+	if _, err := repo.Push(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	branch, err := remote.Reference(plumbing.NewBranchReferenceName("lure-left_pad-1_3_0"), false)
+	if err != nil {
+		t.Fatalf("Should have pushed the sanitized branch: %s", err)
+	}
+	master, _ := remote.Reference(plumbing.NewBranchReferenceName("master"), false)
+	if branch.Hash() != master.Hash() {
+		t.Errorf("Pushed branch should point to master")
+	}
+
+	if err := repo.CloseBranch(context.Background(), "lure-left_pad-1_3_0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := remote.Reference(plumbing.NewBranchReferenceName("lure-left_pad-1_3_0"), false); err == nil {
+		t.Error("Should have deleted the remote branch")
+	}
+}
+
+func TestGoGitShouldListBranchesAndCommits(t *testing.T) {
+	remote := newMemoryRemote(t)
+	remoteWorktree, _ := remote.Worktree()
+	remoteWorktree.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName("develop"), Create: true})
+	first := commitFile(t, remote, "README.md", "catfeeder", "Add readme")
+	second := commitFile(t, remote, "README.md", "catfeeder, feeds cats", "Describe catfeeder")
+	repo := newClonedGoGit(t)
+
+	branches, err := repo.ActiveBranches(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(branches, ",") != "develop,master" {
+		t.Errorf("Unexpected branches %q", branches)
+	}
+
+	commits, err := repo.CommitsBetween(context.Background(), "origin/master", "origin/develop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(commits, ",") != first.String()[:7]+","+second.String()[:7] {
+		t.Errorf("Expected the develop commits from the oldest, got %q", commits)
+	}
+
+	log, err := repo.Log(context.Background(), "origin/master", "origin/develop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(log) != 2 || log[1].Hash != second.String() || log[1].Subject() != "Describe catfeeder" || strings.Join(log[1].Files, ",") != "README.md" || log[1].Author != "Someone" {
+		t.Errorf("Expected the develop commits from the oldest, got %+v", log)
+	}
+
+	date, err := repo.BranchDate(context.Background(), "develop")
+	if err != nil || time.Since(date) > time.Minute {
+		t.Errorf("Unexpected branch date %s: %s", date, err)
+	}
+}
+
+func TestGoGitShouldOnlyMergeBranchesBehind(t *testing.T) {
+	defer withGitAuthor(t)()
+	remote := newMemoryRemote(t)
+	remoteWorktree, _ := remote.Worktree()
+	remoteWorktree.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName("develop"), Create: true})
+	commitFile(t, remote, "README.md", "catfeeder", "Add readme")
+	repo := newClonedGoGit(t)
+
+	if _, err := repo.Update(context.Background(), "master"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Branch(context.Background(), "sync"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Merge(context.Background(), "origin/develop", "Merge develop into sync"); err != nil {
+		t.Fatalf("Should merge a branch ahead: %s", err)
+	}
+	commits, _ := repo.CommitsBetween(context.Background(), "origin/develop", "sync")
+	if len(commits) != 1 {
+		t.Errorf("Should have created a merge commit, got %q", commits)
+	}
+
+	if _, err := repo.Update(context.Background(), "master"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Branch(context.Background(), "diverged"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Commit(context.Background(), "Nothing"); err == nil {
+		t.Error("Should not commit a clean working tree")
+	}
+	if _, err := repo.Merge(context.Background(), "sync", "Merge sync into master"); err != nil {
+		t.Errorf("Should fast-forward master: %s", err)
+	}
+	if output, err := repo.Merge(context.Background(), "origin/master", "Merge master"); err != nil || output != "Already up to date." {
+		t.Errorf("Should already be up to date, got %s: %s", output, err)
+	}
+}
+
+func TestGoGitShouldNotExposeCredentials(t *testing.T) {
+	repo := vcs.NewGoGitInMemory(vcs.TokenAuth{User: "x-access-token", Token: "secret"}, "https://someone@github.com/coveooss/lure.git")
+
+	if repo.RemotePath() != "https://github.com/coveooss/lure.git" {
+		t.Errorf("Remote path should not hold credentials, got %s", repo.RemotePath())
+	}
+	if _, err := repo.CherryPick(context.Background(), "abc"); err == nil {
+		t.Error("Cherry-picks should not be supported")
+	}
+}
+
+func TestGoGitShouldRefreshCachedClone(t *testing.T) {
+	remote := newMemoryRemote(t)
+	remoteWorktree, _ := remote.Worktree()
+	master, _ := remote.Head()
+	remote.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("merged"), master.Hash()))
+
+	dir, err := ioutil.TempDir("", "lure-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo, _ := vcs.NewGoGit(vcs.TokenAuth{User: "x-access-token", Token: "secret"}, memoryRemoteURL, dir, "", vcs.CloneOptions{Cached: true}, vcs.CommitOptions{})
+	if err := repo.Clone(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	repo.Update(context.Background(), "merged")
+	repo.Branch(context.Background(), "lure-leftover")
+	ioutil.WriteFile(filepath.Join(dir, "untracked.txt"), []byte("left by an earlier run"), 0644)
+
+	remote.Storer.RemoveReference(plumbing.NewBranchReferenceName("merged"))
+	remoteWorktree.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName("master")})
+	latest := commitFile(t, remote, "README.md", "catfeeder", "Add readme")
+
+	if err := repo.Clone(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	branches, _ := repo.ActiveBranches(context.Background())
+	if strings.Join(branches, ",") != "master" {
+		t.Errorf("Should have pruned the deleted branch, got %q", branches)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "untracked.txt")); !os.IsNotExist(err) {
+		t.Error("Should have removed the untracked files")
+	}
+	if _, err := repo.Update(context.Background(), "lure-leftover"); err == nil {
+		t.Error("Should have removed the local branches")
+	}
+	if _, err := repo.Update(context.Background(), "master"); err != nil {
+		t.Fatal(err)
+	}
+	if commits, _ := repo.CommitsBetween(context.Background(), latest.String(), "master"); len(commits) != 0 {
+		t.Errorf("Master should be at the latest remote commit, %q are ahead", commits)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "README.md")); err != nil {
+		t.Errorf("Should have checked out the latest files: %s", err)
+	}
+}
+
+func TestGoGitShouldDateBranchesFromTheirFetchWhenCached(t *testing.T) {
+	remote := newMemoryRemote(t)
+	remoteWorktree, _ := remote.Worktree()
+	file, _ := remoteWorktree.Filesystem.Create("README.md")
+	file.Write([]byte("catfeeder"))
+	file.Close()
+	remoteWorktree.Add("README.md")
+	old := time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC)
+	hash, err := remoteWorktree.Commit("Add readme", &git.CommitOptions{Author: &object.Signature{Name: "Someone", Email: "someone@example.com", When: old}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "lure-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo, _ := vcs.NewGoGit(vcs.TokenAuth{}, memoryRemoteURL, dir, "", vcs.CloneOptions{Cached: true}, vcs.CommitOptions{})
+	if err := repo.Clone(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// pushed by a colleague at the old commit after the cache was created
+	remote.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("lure-colleague"), hash))
+	if err := repo.Clone(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if date, err := repo.BranchDate(context.Background(), "master"); err != nil || !date.Equal(old) {
+		t.Errorf("Should have dated master from its commit, got %s %v", date, err)
+	}
+	if date, err := repo.BranchDate(context.Background(), "lure-colleague"); err != nil || time.Since(date) > time.Hour {
+		t.Errorf("Should have dated the new branch from its fetch, got %s %v", date, err)
+	}
+}
+
+func TestGoGitShouldSignCommitsAsTheConfiguredAuthor(t *testing.T) {
+	newMemoryRemote(t)
+	dir, err := ioutil.TempDir("", "lure-gogit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	entity, err := openpgp.NewEntity("lure-bot", "", "lure-bot@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var privateKey, publicKey bytes.Buffer
+	writer, _ := armor.Encode(&privateKey, openpgp.PrivateKeyType, nil)
+	entity.SerializePrivate(writer, nil)
+	writer.Close()
+	writer, _ = armor.Encode(&publicKey, openpgp.PublicKeyType, nil)
+	entity.Serialize(writer)
+	writer.Close()
+	keyPath := filepath.Join(dir, "lure-bot.asc")
+	ioutil.WriteFile(keyPath, privateKey.Bytes(), 0600)
+
+	clone := filepath.Join(dir, "clone")
+	commitOptions := vcs.CommitOptions{AuthorName: "lure-bot", AuthorEmail: "lure-bot@example.com", SigningFormat: vcs.GPG, SigningKey: keyPath}
+	repo, err := vcs.NewGoGit(vcs.TokenAuth{User: "x-access-token", Token: "secret"}, memoryRemoteURL, clone, "", vcs.CloneOptions{}, commitOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Clone(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	repo.Branch(context.Background(), "lure-left_pad-1_3_0")
+	ioutil.WriteFile(filepath.Join(clone, "package.json"), []byte(`{"name": "catfeeder", "version": "1.0.1"}`), 0644)
+	hash, err := repo.Commit(context.Background(), "Update left-pad to 1.3.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	local, _ := git.PlainOpen(clone)
+	commit, err := local.CommitObject(plumbing.NewHash(hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commit.Author.Name != "lure-bot" || commit.Committer.Email != "lure-bot@example.com" {
+		t.Errorf("Should have committed as lure-bot, got %s", commit.Author)
+	}
+	if _, err := commit.Verify(publicKey.String()); err != nil {
+		t.Errorf("Should have signed the commit: %s", err)
+	}
+
+	if _, err := vcs.NewGoGit(vcs.TokenAuth{}, memoryRemoteURL, clone, "", vcs.CloneOptions{}, vcs.CommitOptions{SigningFormat: vcs.SSH, SigningKey: keyPath}); err == nil {
+		t.Error("Should not accept ssh signing")
+	}
+}
@@ -1,58 +1,148 @@
 package vcs
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/coveooss/lure/lib/lure/log"
 	osutil "github.com/coveooss/lure/lib/lure/os"
 )
 
 type HgRepo struct {
-	workingPath   string
-	localPath     string
-	remotePath    string
-	trashBranch   string
-	defaultBranch string
-	authArgs      []string
+	workingPath    string
+	localPath      string
+	source         string
+	authentication Authentication
+	trashBranch    string
+	defaultBranch  string
+	branchMode     string
+	commandArgs    []string
+	commandEnv     []string
+	cached         bool
 }
 
-func NewHg(auth Authentication, source string, to string, defaultBranch string, trashBranch string, basePath string) (HgRepo, error) {
+// NewHg only honors the Cached clone option, mercurial having no shallow nor sparse clones.
+// Mercurial has no credential helpers either, their credentials go in the URL.
+// Commits are signed by the commitsigs extension with the gpg key id, ssh signing is not supported.
+// branchMode is one of HgBranch, HgBookmark and HgTopic, the trash branch only being used by HgBranch.
+func NewHg(auth Authentication, source string, to string, defaultBranch string, trashBranch string, branchMode string, basePath string, cloneOptions CloneOptions, commitOptions CommitOptions) (HgRepo, error) {
+	switch branchMode {
+	case "":
+		branchMode = HgBranch
+	case HgBranch, HgBookmark, HgTopic:
+	default:
+		return HgRepo{}, fmt.Errorf("Unknown hg branch mode '%s' - must be one of %s, %s, %s", branchMode, HgBranch, HgBookmark, HgTopic)
+	}
+	if err := commitOptions.validate(); err != nil {
+		return HgRepo{}, err
+	}
+	if commitOptions.SigningFormat == SSH {
+		return HgRepo{}, errors.New("mercurial commits cannot be signed with ssh")
+	}
+	if credentialHelperAuth, ok := auth.(CredentialHelperAuth); ok {
+		auth = credentialHelperAuth.Authentication
+	}
+
 	var workingPath strings.Builder
 	workingPath.WriteString(to)
 	workingPath.WriteString("/")
 	workingPath.WriteString(basePath)
 
 	repo := HgRepo{
-		workingPath:   workingPath.String(),
-		localPath:     to,
-		remotePath:    auth.AuthenticateURL(source),
-		defaultBranch: defaultBranch,
-		trashBranch:   trashBranch,
+		workingPath:    workingPath.String(),
+		localPath:      to,
+		source:         source,
+		authentication: auth,
+		defaultBranch:  defaultBranch,
+		trashBranch:    trashBranch,
+		branchMode:     branchMode,
+		cached:         cloneOptions.Cached,
+	}
+	// the close branch and fake merge commits get the commit options as well
+	repo.commandArgs = hgCommitArgs(commitOptions)
+	if branchMode == HgTopic {
+		// topics are abandoned with prune, which comes with evolve
+		repo.commandArgs = append(repo.commandArgs, "--config", "extensions.topic=", "--config", "extensions.evolve=")
+	}
+	if commandAuth, ok := auth.(CommandAuthentication); ok {
+		authArgs, authEnv := commandAuth.CommandArgs(Hg, source)
+		repo.commandArgs, repo.commandEnv = append(repo.commandArgs, authArgs...), authEnv
 	}
 
 	return repo, nil
 }
 
+func hgCommitArgs(commitOptions CommitOptions) []string {
+	var args []string
+	if commitOptions.AuthorName != "" || commitOptions.AuthorEmail != "" {
+		args = append(args, "--config", "ui.username="+commitOptions.author())
+	}
+	if commitOptions.SigningFormat == GPG {
+		args = append(args,
+			"--config", "extensions.commitsigs="+commitOptions.HgExtension,
+			"--config", "commitsigs.scheme=gnupg",
+			"--config", "commitsigs.gnupg.flags=--local-user "+commitOptions.SigningKey,
+		)
+	}
+	return args
+}
+
 func (hgRepo HgRepo) SanitizeBranchName(branchName string) string {
 	reg, _ := regexp.Compile("[^a-zA-Z0-9/_-]+")
 	safe := reg.ReplaceAllString(branchName, "_")
 	return safe
 }
 
-func (hgRepo HgRepo) Clone() error {
-	log.Logger.Infof("cloning to %s", hgRepo.localPath)
-	args := []string{"clone", hgRepo.remotePath, hgRepo.localPath}
+// Clone clones the source, or refreshes the clone of an earlier run when cached
+func (hgRepo HgRepo) Clone(ctx context.Context) error {
+	if hgRepo.cached && isDir(filepath.Join(hgRepo.localPath, ".hg")) {
+		return hgRepo.refresh(ctx)
+	}
 
-	if _, err := osutil.Execute("", "hg", args...); err != nil {
+	log.For(ctx).Infof("cloning to %s", hgRepo.localPath)
+	args := []string{"clone", hgRepo.RemotePath(), hgRepo.localPath}
+
+	if _, err := hgRepo.hg(ctx, "", args...); err != nil {
 		return err
 	}
 	return nil
 }
 
+// refresh pulls the source and drops what an earlier run left behind: unpushed changesets, changes and untracked files
+func (hgRepo HgRepo) refresh(ctx context.Context) error {
+	log.For(ctx).Infof("refreshing %s", hgRepo.localPath)
+	if _, err := hgRepo.Cmd(ctx, "pull", hgRepo.RemotePath()); err != nil {
+		return err
+	}
+
+	// the changesets of the topics stay drafts once pushed, only the ones missing from the source are stripped
+	unpushed := "draft()"
+	if hgRepo.branchMode == HgTopic {
+		unpushed = "outgoing()"
+	}
+	drafts, err := hgRepo.Cmd(ctx, "log", "-r", unpushed, "--template", "{node}\n")
+	if err != nil {
+		return err
+	}
+	if len(splitLines(drafts)) > 0 {
+		if _, err := hgRepo.Cmd(ctx, "--config", "extensions.strip=", "strip", "--force", "--no-backup", "-r", unpushed); err != nil {
+			return err
+		}
+	}
+
+	if _, err := hgRepo.Cmd(ctx, "update", "--clean", hgRepo.defaultBranch); err != nil {
+		return err
+	}
+	_, err = hgRepo.Cmd(ctx, "--config", "extensions.purge=", "purge", "--all")
+	return err
+}
+
 func (hgRepo HgRepo) WorkingPath() string {
 	return hgRepo.workingPath
 }
@@ -65,12 +155,24 @@ func (hgRepo HgRepo) LocalPath() string {
 	return hgRepo.localPath
 }
 
+// RemotePath authenticates the source at each call, the credentials possibly expiring during long runs
 func (hgRepo HgRepo) RemotePath() string {
-	return hgRepo.remotePath
+	return hgRepo.authentication.AuthenticateURL(hgRepo.source)
+}
+
+func (hgRepo HgRepo) Cmd(ctx context.Context, args ...string) (string, error) {
+	return hgRepo.hg(ctx, hgRepo.localPath, args...)
 }
 
-func (hgRepo HgRepo) Cmd(args ...string) (string, error) {
-	return osutil.Execute(hgRepo.localPath, "hg", args...)
+// hg runs an hg command with the commit and authentication options. Failures are returned as an *Error.
+func (hgRepo HgRepo) hg(ctx context.Context, dir string, args ...string) (string, error) {
+	// The errors are recognized from the untranslated messages
+	env := append([]string{"LC_ALL=C"}, hgRepo.commandEnv...)
+	out, err := osutil.ExecuteWithEnv(ctx, dir, env, "hg", append(append([]string{}, hgRepo.commandArgs...), args...)...)
+	if err != nil {
+		return out, newCommandError(err)
+	}
+	return out, nil
 }
 
 func (hgRepo HgRepo) SetUserPas(user string, pass string) error {
@@ -87,17 +189,50 @@ func (hgRepo HgRepo) SetUserPas(user string, pass string) error {
 	return f.Close()
 }
 
-func (hgRepo HgRepo) Update(rev string) (string, error) {
-	return hgRepo.Cmd("update", rev)
+func (hgRepo HgRepo) Update(ctx context.Context, rev string) (string, error) {
+	return hgRepo.Cmd(ctx, "update", rev)
+}
+
+// Clean drops the changes and the untracked files of the working copy, the ignored ones are kept
+func (hgRepo HgRepo) Clean(ctx context.Context) error {
+	if _, err := hgRepo.Cmd(ctx, "update", "--clean", "."); err != nil {
+		return err
+	}
+	_, err := hgRepo.Cmd(ctx, "--config", "extensions.purge=", "purge")
+	return err
+}
+
+// AddWorktree shares the clone with a working copy in dir, replacing what an earlier run left there
+func (hgRepo HgRepo) AddWorktree(ctx context.Context, dir string) (SourceControl, error) {
+	if err := hgRepo.RemoveWorktree(ctx, dir); err != nil {
+		return nil, err
+	}
+	if _, err := hgRepo.hg(ctx, "", "--config", "extensions.share=", "share", hgRepo.localPath, dir); err != nil {
+		return nil, err
+	}
+
+	share := hgRepo
+	share.localPath = dir
+	share.workingPath = dir + strings.TrimPrefix(hgRepo.workingPath, hgRepo.localPath)
+	return share, nil
+}
+
+// RemoveWorktree removes the working copy in dir, if any. The changesets it committed stay in the shared clone.
+func (hgRepo HgRepo) RemoveWorktree(ctx context.Context, dir string) error {
+	return os.RemoveAll(dir)
 }
 
-func (hgRepo HgRepo) Branch(branchname string) (string, error) {
-	branch, err := hgRepo.SoftBranch(branchname)
+// Branch creates a named branch with its first commit. Bookmarks and topics need no commit, they are only created.
+func (hgRepo HgRepo) Branch(ctx context.Context, branchname string) (string, error) {
+	branch, err := hgRepo.SoftBranch(ctx, branchname)
 	if err != nil {
 		return "", err
 	}
+	if hgRepo.branchMode != HgBranch {
+		return branch, nil
+	}
 
-	_, err = hgRepo.Commit(fmt.Sprintf("creating branch %s", branch))
+	_, err = hgRepo.Commit(ctx, fmt.Sprintf("creating branch %s", branch))
 	if err != nil {
 		return "", err
 	}
@@ -105,32 +240,108 @@ func (hgRepo HgRepo) Branch(branchname string) (string, error) {
 	return branch, nil
 }
 
-func (hgRepo HgRepo) SoftBranch(branchname string) (string, error) {
-	return hgRepo.Cmd("branch", hgRepo.SanitizeBranchName(branchname))
+// SoftBranch marks the working directory with a named branch, or activates a bookmark or topic, for the next commit
+func (hgRepo HgRepo) SoftBranch(ctx context.Context, branchname string) (string, error) {
+	switch hgRepo.branchMode {
+	case HgBookmark:
+		return hgRepo.Cmd(ctx, "bookmark", hgRepo.SanitizeBranchName(branchname))
+	case HgTopic:
+		return hgRepo.Cmd(ctx, "topic", hgRepo.SanitizeBranchName(branchname))
+	default:
+		return hgRepo.Cmd(ctx, "branch", hgRepo.SanitizeBranchName(branchname))
+	}
 }
 
-func (hgRepo HgRepo) Commit(message string) (string, error) {
-	return hgRepo.Cmd("commit", "-m", message)
+func (hgRepo HgRepo) Commit(ctx context.Context, message string) (string, error) {
+	out, err := hgRepo.Cmd(ctx, "commit", "-m", message)
+	// hg commit exits with 1 when nothing changed
+	if hasExitCode(err, 1) {
+		return out, withKind(err, ErrNothingToCommit)
+	}
+	return out, err
 }
 
-func (hgRepo HgRepo) Merge(branch string) (string, error) {
-	_, err := hgRepo.Cmd("merge", branch)
+// Merge merges rev into the current branch and commits the result. On conflict, the merge is left in progress.
+func (hgRepo HgRepo) Merge(ctx context.Context, rev string, message string) (string, error) {
+	commits, err := hgRepo.CommitsBetween(ctx, ".", rev)
 	if err != nil {
-		return "", errors.New(fmt.Sprintf("Error: \"Could not merge %s into current branch\" %s", branch, err.Error()))
+		return "", err
+	}
+	if len(commits) == 0 {
+		return "", nil
+	}
+
+	if _, err := hgRepo.Cmd(ctx, "merge", "--tool=internal:merge", rev); err != nil {
+		// hg merge exits with 1 when files are left unresolved
+		if hasExitCode(err, 1) {
+			err = withKind(err, ErrConflict)
+		}
+		return "", fmt.Errorf("Error: \"Could not merge %s into current branch\" %w", rev, err)
+	}
+	return hgRepo.Commit(ctx, message)
+}
+
+// ConflictedFiles returns the files left unresolved by the merge in progress
+func (hgRepo HgRepo) ConflictedFiles(ctx context.Context) ([]string, error) {
+	out, err := hgRepo.Cmd(ctx, "resolve", "--list")
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, line := range splitLines(out) {
+		if strings.HasPrefix(line, "U ") {
+			files = append(files, strings.TrimPrefix(line, "U "))
+		}
 	}
-	return "", nil
+	return files, nil
+}
+
+func (hgRepo HgRepo) AbortMerge(ctx context.Context) error {
+	_, err := hgRepo.Cmd(ctx, "update", "--clean", ".")
+	return err
 }
 
-func (hgRepo HgRepo) Push() (string, error) {
-	return hgRepo.Cmd("push", "--new-branch", hgRepo.remotePath)
+// CherryPick grafts rev on the current branch. For a merge, the changesets it brought are grafted instead.
+// On conflict, the graft is left in progress.
+func (hgRepo HgRepo) CherryPick(ctx context.Context, rev string) (string, error) {
+	out, err := hgRepo.Cmd(ctx, "graft", "--log", "--tool=internal:merge", "-r", fmt.Sprintf("only(%s, p1(%s)) - merge()", rev, rev))
+	// hg graft exits with 1 when files are left unresolved, older versions abort as recognized from stderr
+	if hasExitCode(err, 1) {
+		return out, withKind(err, ErrConflict)
+	}
+	return out, err
 }
 
-func (hgRepo HgRepo) PushDefault() (string, error) {
-	return hgRepo.Cmd("push", "--new-branch")
+func (hgRepo HgRepo) AbortCherryPick(ctx context.Context) error {
+	_, err := hgRepo.Cmd(ctx, "graft", "--abort")
+	return err
 }
 
-func (hgRepo HgRepo) CommitsBetween(baseRev string, secondRev string) ([]string, error) {
-	out, err := hgRepo.Cmd("log", "-r", "ancestors("+secondRev+") and not ancestors("+baseRev+")", "--template", "{node}\n")
+// Push pushes the current named branch, or only the active bookmark or the current topic. The shares of a clone
+// hold the changesets of each other, so only the ancestors of the working directory are pushed.
+func (hgRepo HgRepo) Push(ctx context.Context) (string, error) {
+	switch hgRepo.branchMode {
+	case HgBookmark:
+		bookmark, err := hgRepo.Cmd(ctx, "log", "-r", ".", "--template", "{activebookmark}")
+		if err != nil {
+			return "", err
+		}
+		if bookmark != "" {
+			return hgRepo.Cmd(ctx, "push", "--bookmark", bookmark, hgRepo.RemotePath())
+		}
+	case HgTopic:
+		return hgRepo.Cmd(ctx, "push", "--rev", ".", hgRepo.RemotePath())
+	}
+	return hgRepo.Cmd(ctx, "push", "--new-branch", "--rev", ".", hgRepo.RemotePath())
+}
+
+func (hgRepo HgRepo) PushDefault(ctx context.Context) (string, error) {
+	return hgRepo.Cmd(ctx, "push", "--new-branch")
+}
+
+func (hgRepo HgRepo) CommitsBetween(ctx context.Context, baseRev string, secondRev string) ([]string, error) {
+	out, err := hgRepo.Cmd(ctx, "log", "-r", "ancestors("+secondRev+") and not ancestors("+baseRev+")", "--template", "{node}\n")
 	if err != nil {
 		return []string{}, err
 	}
@@ -139,65 +350,186 @@ func (hgRepo HgRepo) CommitsBetween(baseRev string, secondRev string) ([]string,
 	return append(lines[:0], lines[:len(lines)-1]...), nil
 }
 
-// ActiveBranches returns all currently active branches
-func (hgRepo HgRepo) ActiveBranches() ([]string, error) {
-	out, err := hgRepo.Cmd("branches", "--active", "--template", "{branch}\n")
+// Log returns the changesets of headRev missing from baseRev, from the oldest
+func (hgRepo HgRepo) Log(ctx context.Context, baseRev string, headRev string) ([]Commit, error) {
+	template := logRecordSeparator + strings.Join([]string{"{node}", "{node|short}", "{author|person}", "{author|email}", "{date|rfc3339date}", "{desc}", `{join(files, "\n")}`}, logFieldSeparator)
+	out, err := hgRepo.Cmd(ctx, "log", "-r", "ancestors("+headRev+") and not ancestors("+baseRev+")", "--template", template)
+	if err != nil {
+		return nil, err
+	}
+	return parseLog(out)
+}
+
+// ActiveBranches returns all currently active branches, followed by the bookmarks or topics
+func (hgRepo HgRepo) ActiveBranches(ctx context.Context) ([]string, error) {
+	out, err := hgRepo.Cmd(ctx, "branches", "--active", "--template", "{branch}\n")
 	if err != nil {
 		return nil, err
 	}
 
+	switch hgRepo.branchMode {
+	case HgBookmark:
+		bookmarks, err := hgRepo.Cmd(ctx, "bookmarks", "--template", "{bookmark}\n")
+		if err != nil {
+			return nil, err
+		}
+		return append(splitLines(out), splitLines(bookmarks)...), nil
+	case HgTopic:
+		topics, err := hgRepo.Cmd(ctx, "topics", "--template", "{topic}\n")
+		if err != nil {
+			return nil, err
+		}
+		return append(splitLines(out), splitLines(topics)...), nil
+	}
 	return strings.Split(out, "\n"), nil
 }
 
 // CloseBranch closes the branch then it merges it to a trash branch so no heads are left
-func (hgRepo HgRepo) CloseBranch(branch string) error {
-	log.Logger.Infof("Closing branch %s.", branch)
+func (hgRepo HgRepo) CloseBranch(ctx context.Context, branch string) error {
+	return hgRepo.CloseBranches(ctx, []string{branch})
+}
+
+// CloseBranches closes the branches then merges them to a trash branch so no heads are left.
+// It goes on when one of them fails and pushes all the closed branches at once.
+// Bookmarks are deleted and topics pruned instead, leaving no commit behind.
+func (hgRepo HgRepo) CloseBranches(ctx context.Context, branches []string) error {
+	switch hgRepo.branchMode {
+	case HgBookmark:
+		return hgRepo.deleteBookmarks(ctx, branches)
+	case HgTopic:
+		return hgRepo.pruneTopics(ctx, branches)
+	}
+
+	var failed []string
+	closed := 0
+	for _, branch := range branches {
+		if err := hgRepo.closeBranchLocally(ctx, branch); err != nil {
+			failed = append(failed, branch)
+			hgRepo.Cmd(ctx, "update", "-C", hgRepo.defaultBranch)
+			continue
+		}
+		closed++
+	}
+
+	if closed > 0 {
+		if _, err := hgRepo.Push(ctx); err != nil {
+			log.For(ctx).Errorf("Error: \"Could not push closed branches\" %s", err)
+			return err
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("Could not close branches %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// deleteBookmarks deletes the bookmarks then pushes them, which deletes them from the source
+func (hgRepo HgRepo) deleteBookmarks(ctx context.Context, bookmarks []string) error {
+	if len(bookmarks) == 0 {
+		return nil
+	}
+	log.For(ctx).Infof("Deleting bookmarks %s.", strings.Join(bookmarks, ", "))
 
-	if _, err := hgRepo.Cmd("update", "-C", branch); err != nil {
-		log.Logger.Errorf("Error: \"Could not switch to branch %s\" %s", branch, err)
+	if _, err := hgRepo.Cmd(ctx, append([]string{"bookmark", "--delete"}, bookmarks...)...); err != nil {
+		log.For(ctx).Errorf("Error: \"Could not delete bookmarks\" %s", err)
 		return err
 	}
 
-	if _, err := hgRepo.Cmd("commit", "-m", "Close branch "+branch, "--close-branch"); err != nil {
-		log.Logger.Errorf("Error: \"Could not commit\" %s", err)
+	push := []string{"push"}
+	for _, bookmark := range bookmarks {
+		push = append(push, "--bookmark", bookmark)
+	}
+	if _, err := hgRepo.Cmd(ctx, append(push, hgRepo.RemotePath())...); err != nil {
+		log.For(ctx).Errorf("Error: \"Could not push deleted bookmarks\" %s", err)
 		return err
 	}
+	return nil
+}
 
-	if _, err := hgRepo.Update(hgRepo.trashBranch); err != nil {
-		log.Logger.Errorf("Error: \"Could not switch to branch %s, trying to create it.\" %s", hgRepo.trashBranch, err)
-		if _, err := hgRepo.Update(hgRepo.defaultBranch); err != nil {
-			log.Logger.Errorf("Error: \"Could not switch to branch %s\" %s", hgRepo.defaultBranch, err)
-			return err
+// pruneTopics marks the changesets of the topics obsolete then pushes the markers, the source needing evolve as well
+func (hgRepo HgRepo) pruneTopics(ctx context.Context, topics []string) error {
+	var failed []string
+	for _, topic := range topics {
+		log.For(ctx).Infof("Pruning topic %s.", topic)
+		if _, err := hgRepo.Cmd(ctx, "prune", "--rev", fmt.Sprintf("topic(%q)", topic)); err != nil {
+			log.For(ctx).Errorf("Error: \"Could not prune topic %s\" %s", topic, err)
+			failed = append(failed, topic)
 		}
-		if _, err := hgRepo.Branch(hgRepo.trashBranch); err != nil {
-			log.Logger.Errorf("Error: \"Could not create branch %s\" %s", hgRepo.trashBranch, err)
+	}
+
+	if len(failed) < len(topics) {
+		if _, err := hgRepo.Cmd(ctx, "push", hgRepo.RemotePath()); err != nil {
+			log.For(ctx).Errorf("Error: \"Could not push pruned topics\" %s", err)
 			return err
 		}
 	}
 
-	if err := hgRepo.fakeMerge(branch, hgRepo.trashBranch); err != nil {
-		log.Logger.Errorf("Error: \"Could not fake merge branch %s to branch %s\" %s", branch, hgRepo.trashBranch, err)
+	if len(failed) > 0 {
+		return fmt.Errorf("Could not prune topics %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func (hgRepo HgRepo) closeBranchLocally(ctx context.Context, branch string) error {
+	log.For(ctx).Infof("Closing branch %s.", branch)
+
+	if _, err := hgRepo.Cmd(ctx, "update", "-C", branch); err != nil {
+		log.For(ctx).Errorf("Error: \"Could not switch to branch %s\" %s", branch, err)
+		return err
+	}
+
+	if _, err := hgRepo.Cmd(ctx, "commit", "-m", "Close branch "+branch, "--close-branch"); err != nil {
+		log.For(ctx).Errorf("Error: \"Could not commit\" %s", err)
 		return err
 	}
 
-	if _, err := hgRepo.Push(); err != nil {
-		log.Logger.Errorf("Error: \"Could not push closed branch %s\" %s", branch, err)
+	if _, err := hgRepo.Update(ctx, hgRepo.trashBranch); err != nil {
+		log.For(ctx).Errorf("Error: \"Could not switch to branch %s, trying to create it.\" %s", hgRepo.trashBranch, err)
+		if _, err := hgRepo.Update(ctx, hgRepo.defaultBranch); err != nil {
+			log.For(ctx).Errorf("Error: \"Could not switch to branch %s\" %s", hgRepo.defaultBranch, err)
+			return err
+		}
+		if _, err := hgRepo.Branch(ctx, hgRepo.trashBranch); err != nil {
+			log.For(ctx).Errorf("Error: \"Could not create branch %s\" %s", hgRepo.trashBranch, err)
+			return err
+		}
+	}
+
+	if err := hgRepo.fakeMerge(ctx, branch, hgRepo.trashBranch); err != nil {
+		log.For(ctx).Errorf("Error: \"Could not fake merge branch %s to branch %s\" %s", branch, hgRepo.trashBranch, err)
 		return err
 	}
 
 	return nil
 }
 
-func (hgRepo HgRepo) fakeMerge(branch string, toBranch string) error {
+// BranchDate returns the date of the last commit of the branch, bookmark or topic
+func (hgRepo HgRepo) BranchDate(ctx context.Context, branch string) (time.Time, error) {
+	revision := fmt.Sprintf("max(branch(%q))", branch)
+	switch hgRepo.branchMode {
+	case HgBookmark:
+		revision = fmt.Sprintf("bookmark(%q)", branch)
+	case HgTopic:
+		revision = fmt.Sprintf("max(topic(%q))", branch)
+	}
+	out, err := hgRepo.Cmd(ctx, "log", "-r", revision, "--template", "{date|rfc3339date}")
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, strings.TrimSpace(out))
+}
+
+func (hgRepo HgRepo) fakeMerge(ctx context.Context, branch string, toBranch string) error {
 
-	hgRepo.Cmd("-y", "merge", "--tool=internal:fail", branch) // Always produces an err
-	if _, err := hgRepo.Cmd("revert", "--all", "--rev", "."); err != nil {
+	hgRepo.Cmd(ctx, "-y", "merge", "--tool=internal:fail", branch) // Always produces an err
+	if _, err := hgRepo.Cmd(ctx, "revert", "--all", "--rev", "."); err != nil {
 		return err
 	}
-	if _, err := hgRepo.Cmd("resolve", "-a", "-m"); err != nil {
+	if _, err := hgRepo.Cmd(ctx, "resolve", "-a", "-m"); err != nil {
 		return err
 	}
-	if _, err := hgRepo.Commit(fmt.Sprintf("Fake merge to close %s into %s", branch, toBranch)); err != nil {
+	if _, err := hgRepo.Commit(ctx, fmt.Sprintf("Fake merge to close %s into %s", branch, toBranch)); err != nil {
 		return err
 	}
 
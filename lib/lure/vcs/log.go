@@ -0,0 +1,56 @@
+package vcs
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Commit describes a commit listed by Log
+type Commit struct {
+	Hash      string
+	ShortHash string
+	Author    string
+	Email     string
+	Date      time.Time
+	Message   string
+	Files     []string // files changed from the first parent, none for merges
+}
+
+// Subject returns the first line of the message
+func (commit Commit) Subject() string {
+	return strings.SplitN(commit.Message, "\n", 2)[0]
+}
+
+// git and hg print every commit as a record of fields, the message and files being free text
+const (
+	logRecordSeparator = "\x1e"
+	logFieldSeparator  = "\x1f"
+	logFields          = 7
+)
+
+// parseLog reads the records of hash, short hash, author, email, RFC 3339 date, message and files
+func parseLog(out string) ([]Commit, error) {
+	commits := []Commit{}
+	for _, record := range strings.Split(out, logRecordSeparator)[1:] {
+		fields := strings.SplitN(record, logFieldSeparator, logFields)
+		if len(fields) != logFields {
+			return nil, fmt.Errorf("Could not read the log record %q", record)
+		}
+		date, err := time.Parse(time.RFC3339, fields[4])
+		if err != nil {
+			return nil, err
+		}
+
+		commits = append(commits, Commit{
+			Hash:      fields[0],
+			ShortHash: fields[1],
+			Author:    fields[2],
+			Email:     fields[3],
+			Date:      date,
+			Message:   strings.TrimSpace(fields[5]),
+			Files:     splitLines(fields[6]),
+		})
+	}
+	return commits, nil
+}
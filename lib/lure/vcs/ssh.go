@@ -0,0 +1,120 @@
+package vcs
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+)
+
+// CommandAuthentication authenticates the git and hg commands through their options and environment,
+// keeping the credentials out of the URL
+type CommandAuthentication interface {
+	Authentication
+	// CommandArgs returns the options of the vcs command and the variables to add to its environment
+	CommandArgs(vcs string, source string) ([]string, []string)
+}
+
+// SSHAuth clones over SSH with a deploy key, or with the keys of the SSH agent when KeyPath is empty.
+// Host keys are checked against KnownHostsPath, or the default known_hosts files when empty.
+// The API of the host is not reachable over SSH, it has to be authenticated separately.
+type SSHAuth struct {
+	User           string // git when empty
+	Port           string
+	KeyPath        string
+	KnownHostsPath string
+}
+
+func (auth SSHAuth) user() string {
+	if auth.User == "" {
+		return "git"
+	}
+	return auth.User
+}
+
+// AuthenticateURL turns an HTTPS clone URL into its ssh:// form and gives the user to ssh:// URLs without one.
+// Other URLs, like the scp-like git@github.com:coveooss/lure.git, are left untouched.
+func (auth SSHAuth) AuthenticateURL(source string) string {
+	parsed, err := url.Parse(source)
+	if err != nil {
+		return source
+	}
+	if parsed.Scheme == "ssh" {
+		if parsed.User == nil {
+			parsed.User = url.User(auth.user())
+		}
+		return parsed.String()
+	}
+	if parsed.Scheme != "https" && parsed.Scheme != "http" {
+		return source
+	}
+
+	host := parsed.Hostname()
+	if auth.Port != "" {
+		host += ":" + auth.Port
+	}
+	return (&url.URL{Scheme: "ssh", User: url.User(auth.user()), Host: host, Path: parsed.Path}).String()
+}
+
+func (auth SSHAuth) AuthenticateHTTPRequest(header header) {
+	//NOOP
+}
+
+func (auth SSHAuth) AuthenticateWithToken(transport http.RoundTripper) *http.Client {
+	return &http.Client{Transport: baseTransport(transport)}
+}
+
+// CommandArgs gives the ssh command to git through GIT_SSH_COMMAND and to hg through ui.ssh
+func (auth SSHAuth) CommandArgs(vcs string, source string) ([]string, []string) {
+	if vcs == Hg {
+		return []string{"--config", "ui.ssh=" + auth.sshCommand()}, nil
+	}
+	return nil, []string{"GIT_SSH_COMMAND=" + auth.sshCommand()}
+}
+
+func (auth SSHAuth) sshCommand() string {
+	command := []string{"ssh", "-o", "BatchMode=yes", "-o", "StrictHostKeyChecking=yes"}
+	if auth.KnownHostsPath != "" {
+		command = append(command, "-o", "UserKnownHostsFile="+shellQuote(auth.KnownHostsPath))
+	}
+	if auth.KeyPath != "" {
+		command = append(command, "-i", shellQuote(auth.KeyPath), "-o", "IdentitiesOnly=yes")
+	}
+	return strings.Join(command, " ")
+}
+
+// transportAuth authenticates the go-git SSH transport, which checks the host keys like the ssh command
+func (auth SSHAuth) transportAuth() (transport.AuthMethod, error) {
+	var knownHosts []string
+	if auth.KnownHostsPath != "" {
+		knownHosts = append(knownHosts, auth.KnownHostsPath)
+	}
+	hostKeyCallback, err := gitssh.NewKnownHostsCallback(knownHosts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if auth.KeyPath == "" {
+		agentAuth, err := gitssh.NewSSHAgentAuth(auth.user())
+		if err != nil {
+			return nil, err
+		}
+		agentAuth.HostKeyCallback = hostKeyCallback
+		return agentAuth, nil
+	}
+
+	keyAuth, err := gitssh.NewPublicKeysFromFile(auth.user(), auth.KeyPath, "")
+	if err != nil {
+		return nil, err
+	}
+	keyAuth.HostKeyCallback = hostKeyCallback
+	return keyAuth, nil
+}
+
+// shellQuote quotes a path for the shell running GIT_SSH_COMMAND and ui.ssh
+func shellQuote(value string) string {
+	return fmt.Sprintf("'%s'", strings.Replace(value, "'", `'\''`, -1))
+}
@@ -0,0 +1,88 @@
+package vcs_test
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/coveooss/lure/lib/lure/vcs"
+)
+
+func TestSSHAuthShouldConvertCloneURLs(t *testing.T) {
+	auth := vcs.SSHAuth{Port: "7999"}
+
+	tests := map[string]string{
+		"https://bitbucket.example.com/scm/lure/catfeeder.git": "ssh://git@bitbucket.example.com:7999/scm/lure/catfeeder.git",
+		"ssh://bitbucket.example.com/lure/catfeeder.git":       "ssh://git@bitbucket.example.com/lure/catfeeder.git",
+		"ssh://deploy@bitbucket.example.com/lure/catfeeder":    "ssh://deploy@bitbucket.example.com/lure/catfeeder",
+		"git@github.com:coveooss/lure.git":                     "git@github.com:coveooss/lure.git",
+	}
+	for source, expected := range tests {
+		if actual := auth.AuthenticateURL(source); actual != expected {
+			t.Errorf("Expected %s to be cloned from %s, got %s", source, expected, actual)
+		}
+	}
+}
+
+func TestSSHAuthShouldCheckHostKeys(t *testing.T) {
+	auth := vcs.SSHAuth{KeyPath: "/keys/lure's key", KnownHostsPath: "/keys/known_hosts"}
+
+	args, env := auth.CommandArgs(vcs.Git, "https://github.com/coveooss/lure.git")
+	if len(args) != 0 || len(env) != 1 {
+		t.Fatalf("Expected git to only get GIT_SSH_COMMAND, got %q %q", args, env)
+	}
+	expected := `GIT_SSH_COMMAND=ssh -o BatchMode=yes -o StrictHostKeyChecking=yes -o UserKnownHostsFile='/keys/known_hosts' -i '/keys/lure'\''s key' -o IdentitiesOnly=yes`
+	if env[0] != expected {
+		t.Errorf("Unexpected ssh command %s", env[0])
+	}
+
+	args, _ = auth.CommandArgs(vcs.Hg, "https://hg.example.com/lure")
+	if len(args) != 2 || args[0] != "--config" || !strings.HasPrefix(args[1], "ui.ssh=ssh -o BatchMode=yes") {
+		t.Errorf("Expected hg to get ui.ssh, got %q", args)
+	}
+}
+
+func TestCredentialHelperShouldKeepSecretsOffTheCommandLine(t *testing.T) {
+	auth := vcs.CredentialHelperAuth{Authentication: vcs.TokenAuth{User: "x-access-token", Token: "secret"}}
+	source := "https://github.com/coveooss/lure.git"
+
+	if auth.AuthenticateURL(source) != source {
+		t.Errorf("URL should not hold credentials, got %s", auth.AuthenticateURL(source))
+	}
+	args, env := auth.CommandArgs(vcs.Git, source)
+	if strings.Contains(strings.Join(args, " "), "secret") {
+		t.Errorf("Arguments should not hold the token: %q", args)
+	}
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	cmd := exec.Command("git", append(args, "credential", "fill")...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = strings.NewReader("protocol=https\nhost=github.com\n\n")
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "username=x-access-token\n") || !strings.Contains(string(out), "password=secret\n") {
+		t.Errorf("The helper should answer the token, got %s", out)
+	}
+}
+
+func TestCredentialHelperShouldOnlyAnswerForTheHostOfTheSource(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	auth := vcs.CredentialHelperAuth{Authentication: vcs.TokenAuth{User: "x-access-token", Token: "secret"}}
+	args, env := auth.CommandArgs(vcs.Git, "https://github.com/coveooss/lure.git")
+
+	cmd := exec.Command("git", append(args, "credential", "fill")...)
+	cmd.Env = append(os.Environ(), append(env, "GIT_TERMINAL_PROMPT=0", "GIT_ASKPASS=", "SSH_ASKPASS=")...)
+	cmd.Stdin = strings.NewReader("protocol=https\nhost=submodules.example.com\n\n")
+	out, _ := cmd.Output()
+
+	if strings.Contains(string(out), "secret") {
+		t.Errorf("The helper should not give the token to another host, got %s", out)
+	}
+}
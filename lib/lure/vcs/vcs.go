@@ -1,11 +1,14 @@
 package vcs
 
 import (
+	"encoding/base64"
 	"fmt"
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
 	"net/http"
+	"os"
 	"strings"
+	"time"
 )
 
 type header interface {
@@ -15,7 +18,7 @@ type header interface {
 type Authentication interface {
 	AuthenticateURL(url string) string
 	AuthenticateHTTPRequest(header header)
-	AuthenticateWithToken() *http.Client
+	AuthenticateWithToken(transport http.RoundTripper) *http.Client
 }
 
 type TokenAuth struct {
@@ -31,8 +34,8 @@ func (auth TokenAuth) AuthenticateHTTPRequest(header header) {
 	header.Add("Authorization", "Bearer " + auth.Token)
 }
 
-func (auth TokenAuth) AuthenticateWithToken() *http.Client {
-	ctx := context.Background()
+func (auth TokenAuth) AuthenticateWithToken(transport http.RoundTripper) *http.Client {
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: transport})
 	ts := oauth2.StaticTokenSource(
 		&oauth2.Token{AccessToken: auth.Token},
 	)
@@ -53,39 +56,193 @@ func (auth UserPassAuth) AuthenticateHTTPRequest(header header) {
 	//NOOP
 }
 
-func (auth UserPassAuth) AuthenticateWithToken() *http.Client {
-	ctx := context.Background()
+func (auth UserPassAuth) AuthenticateWithToken(transport http.RoundTripper) *http.Client {
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: transport})
 	ts := oauth2.StaticTokenSource(
 		&oauth2.Token{AccessToken: auth.Password},
 	)
 	return oauth2.NewClient(ctx, ts)
 }
 
+// JobTokenAuth authenticates with a GitLab CI job token
+type JobTokenAuth struct {
+	Token string
+}
+
+func (auth JobTokenAuth) AuthenticateURL(url string) string {
+	return strings.Replace(url, "://", fmt.Sprintf("://gitlab-ci-token:%s@", auth.Token), 1)
+}
+
+func (auth JobTokenAuth) AuthenticateHTTPRequest(header header) {
+	header.Add("JOB-TOKEN", auth.Token)
+}
+
+func (auth JobTokenAuth) AuthenticateWithToken(transport http.RoundTripper) *http.Client {
+	return &http.Client{Transport: jobTokenTransport{token: auth.Token, base: transport}}
+}
+
+type jobTokenTransport struct {
+	token string
+	base  http.RoundTripper
+}
+
+func (transport jobTokenTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	request = request.Clone(request.Context())
+	request.Header.Add("JOB-TOKEN", transport.token)
+	return baseTransport(transport.base).RoundTrip(request)
+}
+
+// PersonalAccessTokenAuth authenticates with an Azure DevOps personal access token, sent as the basic auth password
+type PersonalAccessTokenAuth struct {
+	Token string
+}
+
+func (auth PersonalAccessTokenAuth) AuthenticateURL(url string) string {
+	return strings.Replace(url, "://", fmt.Sprintf("://pat:%s@", auth.Token), 1)
+}
+
+func (auth PersonalAccessTokenAuth) AuthenticateHTTPRequest(header header) {
+	header.Add("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(":"+auth.Token)))
+}
+
+func (auth PersonalAccessTokenAuth) AuthenticateWithToken(transport http.RoundTripper) *http.Client {
+	return &http.Client{Transport: personalAccessTokenTransport{auth: auth, base: transport}}
+}
+
+type personalAccessTokenTransport struct {
+	auth PersonalAccessTokenAuth
+	base http.RoundTripper
+}
+
+func (transport personalAccessTokenTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	request = request.Clone(request.Context())
+	transport.auth.AuthenticateHTTPRequest(request.Header)
+	return baseTransport(transport.base).RoundTrip(request)
+}
+
+// baseTransport defaults a nil transport to http.DefaultTransport, as http.Client does
+func baseTransport(transport http.RoundTripper) http.RoundTripper {
+	if transport == nil {
+		return http.DefaultTransport
+	}
+	return transport
+}
+
+// CloneOptions tune how repositories are cloned and kept between runs
+type CloneOptions struct {
+	Depth  int    // clones only the last Depth commits of every branch
+	Filter string // partial clone filter like blob:none, git cli only
+	Sparse bool   // only checks out the base path, git cli only
+	Cached bool   // refreshes a clone left by an earlier run instead of cloning again
+	// Proxy and CACertificates reach the remote through a proxy and trust its certificates, instead of the system ones,
+	// git cli only
+	Proxy          string
+	CACertificates []string
+}
+
+// CommitOptions set the identity and signature of every commit lure makes, the host configuration applies when empty
+type CommitOptions struct {
+	AuthorName    string
+	AuthorEmail   string
+	SigningFormat string // GPG or SSH
+	SigningKey    string // key id or path, see the backends
+	HgExtension   string // path of the commitsigs extension signing hg commits, when not installed
+}
+
+func (options CommitOptions) author() string {
+	if options.AuthorEmail == "" {
+		return options.AuthorName
+	}
+	return fmt.Sprintf("%s <%s>", options.AuthorName, options.AuthorEmail)
+}
+
+func (options CommitOptions) validate() error {
+	switch options.SigningFormat {
+	case "":
+		return nil
+	case GPG, SSH:
+		if options.SigningKey == "" {
+			return fmt.Errorf("a signing key is required to sign commits with %s", options.SigningFormat)
+		}
+		return nil
+	default:
+		return fmt.Errorf("Unknown signing format '%s' - must be one of %s, %s", options.SigningFormat, GPG, SSH)
+	}
+}
+
 type SourceControl interface {
 	WorkingPath() string
 	LocalPath() string
 	RemotePath() string
 
-	Cmd(args ...string) (string, error)
-	Update(rev string) (string, error)
-	Branch(branchname string) (string, error)
-	SoftBranch(branchname string) (string, error)
-	Commit(message string) (string, error)
-	Push() (string, error)
-	ActiveBranches() ([]string, error)
-	CloseBranch(branch string) error
-	Clone() error
+	Cmd(ctx context.Context, args ...string) (string, error)
+	Update(ctx context.Context, rev string) (string, error)
+	Clean(ctx context.Context) error
+	Branch(ctx context.Context, branchname string) (string, error)
+	SoftBranch(ctx context.Context, branchname string) (string, error)
+	Commit(ctx context.Context, message string) (string, error)
+	Merge(ctx context.Context, rev string, message string) (string, error)
+	ConflictedFiles(ctx context.Context) ([]string, error)
+	AbortMerge(ctx context.Context) error
+	CherryPick(ctx context.Context, rev string) (string, error)
+	AbortCherryPick(ctx context.Context) error
+	Push(ctx context.Context) (string, error)
+	ActiveBranches(ctx context.Context) ([]string, error)
+	CloseBranch(ctx context.Context, branch string) error
+	CloseBranches(ctx context.Context, branches []string) error
+	BranchDate(ctx context.Context, branch string) (time.Time, error)
+	Clone(ctx context.Context) error
 	SanitizeBranchName(branchName string) string
 
-	CommitsBetween(baseRev string, secondRev string) ([]string, error)
+	CommitsBetween(ctx context.Context, baseRev string, secondRev string) ([]string, error)
+	Log(ctx context.Context, baseRev string, headRev string) ([]Commit, error)
 
 	GetName() string
 }
 
+// Worktrees is implemented by the SourceControls able to check out more working copies of their clone,
+// sharing its history, so modules can be updated in parallel
+type Worktrees interface {
+	AddWorktree(ctx context.Context, dir string) (SourceControl, error)
+	RemoveWorktree(ctx context.Context, dir string) error
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// splitLines splits a command output into its non empty lines
+func splitLines(out string) []string {
+	var lines []string
+	for _, line := range strings.Split(out, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
 const (
 	Git = "git"
 	Hg  = "hg"
 
+	GitCLI = "cli"
+	GoGit  = "go-git"
+
+	HgBranch   = "branch"
+	HgBookmark = "bookmark"
+	HgTopic    = "topic"
+
+	HTTPS = "https"
+	SSH   = "ssh"
+
+	GPG = "gpg"
+
 	Bitbucket = "bitbucket"
 	GitHub = "github"
+	GitLab = "gitlab"
+	BitbucketServer = "bitbucketServer"
+	Gitea = "gitea"
+	AzureDevOps = "azureDevOps"
 )
@@ -1,18 +1,52 @@
 package versionManager
 
+import (
+	"context"
+
+	"github.com/blang/semver"
+)
+
 type UpdateFunc func() error
 
 // Allow the module to be updated
 type ModuleUpdater interface {
-	UpdateDependency(path string, moduleVersion ModuleVersion) (bool, error)
+	UpdateDependency(ctx context.Context, path string, moduleVersion ModuleVersion) (bool, error)
 }
 
 type ModuleVersion struct {
-	Type          string
-	Module        string
-	Current       string
-	Latest        string
-	Wanted        string
-	Name          string
-	ModuleUpdater ModuleUpdater
+	Type           string
+	Module         string
+	Current        string
+	Latest         string
+	Wanted         string
+	Name           string
+	DependencyType string
+	ModuleUpdater  ModuleUpdater
+}
+
+const (
+	MajorUpdate = "major"
+	MinorUpdate = "minor"
+	PatchUpdate = "patch"
+)
+
+// UpdateType returns whether going from Current to Latest is a major, minor or patch update.
+// An empty string is returned when one of the versions can't be parsed.
+func (moduleVersion ModuleVersion) UpdateType() string {
+	current, err := semver.ParseTolerant(moduleVersion.Current)
+	if err != nil {
+		return ""
+	}
+	latest, err := semver.ParseTolerant(moduleVersion.Latest)
+	if err != nil {
+		return ""
+	}
+
+	if current.Major != latest.Major {
+		return MajorUpdate
+	}
+	if current.Minor != latest.Minor {
+		return MinorUpdate
+	}
+	return PatchUpdate
 }
@@ -3,6 +3,7 @@ package mvn
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/xml"
 	"os/exec"
 	"regexp"
@@ -20,10 +21,10 @@ import (
 
 type Mvn struct{}
 
-func (mvn *Mvn) GetOutdated(path string) ([]versionManager.ModuleVersion, error) {
+func (mvn *Mvn) GetOutdated(ctx context.Context, path string) ([]versionManager.ModuleVersion, error) {
 	const pomDefaultFileName = "pom.xml"
 	if !fileExists(path + pomDefaultFileName) {
-		log.Logger.Info(pomDefaultFileName + " doesn't exist, skipping mvn update")
+		log.For(ctx).Info(pomDefaultFileName + " doesn't exist, skipping mvn update")
 		return make([]versionManager.ModuleVersion, 0, 0), nil
 	}
 
@@ -38,13 +39,16 @@ func (mvn *Mvn) GetOutdated(path string) ([]versionManager.ModuleVersion, error)
 	cmd.Stdout = &out
 	cmd.Stderr = &stderr
 	cmd.Dir = path
-	err := cmd.Run()
+	err := osUtils.Run(ctx, cmd)
 
+	if ctx.Err() != nil {
+		return make([]versionManager.ModuleVersion, 0, 0), err
+	}
 	if err != nil {
-		log.Logger.Error("Error running mvn versions:display-dependency-updates")
-		log.Logger.Error(out.String())
-		log.Logger.Error(stderr.String())
-		log.Logger.Error(err)
+		log.For(ctx).Error("Error running mvn versions:display-dependency-updates")
+		log.For(ctx).Error(out.String())
+		log.For(ctx).Error(stderr.String())
+		log.For(ctx).Error(err)
 		return make([]versionManager.ModuleVersion, 0, 0), nil
 	}
 
@@ -56,10 +60,13 @@ func (mvn *Mvn) GetOutdated(path string) ([]versionManager.ModuleVersion, error)
 
 	version := make([]versionManager.ModuleVersion, 0, 0)
 	var lastPackage []string
-	var modulePropertyMap map[string]string = getModulePropertyMap(path)
+	modulePropertyMap, moduleScopeMap, err := getModuleDeclarations(ctx, path)
+	if err != nil {
+		return make([]versionManager.ModuleVersion, 0, 0), err
+	}
 
 	for scanner.Scan() {
-		log.Logger.Tracef("> %s\n", scanner.Text())
+		log.For(ctx).Tracef("> %s\n", scanner.Text())
 		packageName := mvnPackageRegex.FindStringSubmatch(scanner.Text())
 		if packageName != nil {
 			lastPackage = packageName
@@ -68,7 +75,7 @@ func (mvn *Mvn) GetOutdated(path string) ([]versionManager.ModuleVersion, error)
 		packageVersion := mvnVersionRegex.FindStringSubmatch(scanner.Text())
 		if packageVersion != nil {
 
-			log.Logger.Tracef(">%q - %q\n", packageName, packageVersion)
+			log.For(ctx).Tracef(">%q - %q\n", packageName, packageVersion)
 			mv := versionManager.ModuleVersion{
 				Type:          "maven",
 				Module:        lastPackage[1] + ":" + lastPackage[2],
@@ -77,8 +84,10 @@ func (mvn *Mvn) GetOutdated(path string) ([]versionManager.ModuleVersion, error)
 				Latest:        packageVersion[2],
 				Name:          modulePropertyMap[lastPackage[1]+":"+lastPackage[2]],
 				ModuleUpdater: mvn,
+				// the scope, like compile or test
+				DependencyType: moduleScopeMap[lastPackage[1]+":"+lastPackage[2]],
 			}
-			log.Logger.Trace(mv)
+			log.For(ctx).Trace(mv)
 			version = append(version, mv)
 		}
 	}
@@ -86,8 +95,11 @@ func (mvn *Mvn) GetOutdated(path string) ([]versionManager.ModuleVersion, error)
 	return version, nil
 }
 
-func getModulePropertyMap(path string) map[string]string {
+// getModuleDeclarations returns the version property and the scope of the dependencies declared by the poms.
+// It only returns an error when ctx is done, it exits on other failures.
+func getModuleDeclarations(ctx context.Context, path string) (map[string]string, map[string]string, error) {
 	var moduleProperties map[string]string = make(map[string]string)
+	moduleScopes := make(map[string]string)
 
 	cmd := exec.Command("mvn", "test-compile", "compile", "--batch-mode", "--update-snapshots", "--quiet", "--also-make", "exec:exec", "-Dexec.executable=pwd")
 	var out bytes.Buffer
@@ -95,14 +107,17 @@ func getModulePropertyMap(path string) map[string]string {
 	cmd.Stdout = &out
 	cmd.Stderr = &stree
 	cmd.Dir = path
-	err := cmd.Run()
+	err := osUtils.Run(ctx, cmd)
 
+	if ctx.Err() != nil {
+		return nil, nil, err
+	}
 	if err != nil {
-		log.Logger.Error("Error running mvn -q --also-make exec:exec -Dexec.executable=pwd")
-		log.Logger.Error(err)
-		log.Logger.Error(out.String())
-		log.Logger.Error(stree.String())
-		log.Logger.Fatal(err)
+		log.For(ctx).Error("Error running mvn -q --also-make exec:exec -Dexec.executable=pwd")
+		log.For(ctx).Error(err)
+		log.For(ctx).Error(out.String())
+		log.For(ctx).Error(stree.String())
+		log.For(ctx).Fatal(err)
 	}
 
 	reader := bytes.NewReader(out.Bytes())
@@ -110,7 +125,7 @@ func getModulePropertyMap(path string) map[string]string {
 
 	var folders []string
 	for scanner.Scan() {
-		log.Logger.Infof(scanner.Text())
+		log.For(ctx).Infof(scanner.Text())
 		folders = append(folders, scanner.Text())
 	}
 
@@ -119,7 +134,7 @@ func getModulePropertyMap(path string) map[string]string {
 	for _, folder := range folders {
 		xmlFile, err := os.Open(folder + "/pom.xml")
 		if err != nil {
-			log.Logger.Error("Error opening file:", err)
+			log.For(ctx).Error("Error opening file:", err)
 			break
 		}
 		defer xmlFile.Close()
@@ -133,9 +148,14 @@ func getModulePropertyMap(path string) map[string]string {
 			if isProperty.MatchString(dep.Version) {
 				moduleProperties[(dep.GroupId + ":" + dep.ArtifactId)] = strings.TrimRight(strings.TrimLeft(dep.Version, "${"), "}")
 			}
+			scope := dep.Scope
+			if scope == "" {
+				scope = "compile"
+			}
+			moduleScopes[dep.GroupId+":"+dep.ArtifactId] = scope
 		}
 	}
-	return moduleProperties
+	return moduleProperties, moduleScopes, nil
 }
 
 type mvnProjectDef struct {
@@ -145,6 +165,7 @@ type mvnProjectDef struct {
 		ArtifactId string `xml:"artifactId"`
 		GroupId    string `xml:"groupId"`
 		Version    string `xml:"version"`
+		Scope      string `xml:"scope"`
 	} `xml:"dependencies>dependency"`
 }
 
@@ -159,7 +180,7 @@ type Property struct {
 type property struct {
 }
 
-func (mvn *Mvn) UpdateDependency(path string, moduleVersion versionManager.ModuleVersion) (bool, error) { //dependency string, version string)
+func (mvn *Mvn) UpdateDependency(ctx context.Context, path string, moduleVersion versionManager.ModuleVersion) (bool, error) { //dependency string, version string)
 	dependency := moduleVersion.Module
 	version := moduleVersion.Latest
 
@@ -175,11 +196,14 @@ func (mvn *Mvn) UpdateDependency(path string, moduleVersion versionManager.Modul
 		cmd.Stdout = &out
 		cmd.Stderr = &stderr
 		cmd.Dir = path
-		err := cmd.Run()
+		err := osUtils.Run(ctx, cmd)
 
+		if ctx.Err() != nil {
+			return false, err
+		}
 		if err != nil {
-			log.Logger.Error(stderr.String())
-			log.Logger.Fatal(err)
+			log.For(ctx).Error(stderr.String())
+			log.For(ctx).Fatal(err)
 		}
 
 		reader := bytes.NewReader(out.Bytes())
@@ -187,14 +211,14 @@ func (mvn *Mvn) UpdateDependency(path string, moduleVersion versionManager.Modul
 
 		var folders []string
 		for scanner.Scan() {
-			log.Logger.Info(scanner.Text())
+			log.For(ctx).Info(scanner.Text())
 			folders = append(folders, scanner.Text())
 		}
 
 		for _, folder := range folders {
 			xmlFile, err := os.Open(folder + "/pom.xml")
 			if err != nil {
-				log.Logger.Println("Error opening file:", err)
+				log.For(ctx).Println("Error opening file:", err)
 				return false, err
 			}
 			defer xmlFile.Close()
@@ -206,13 +230,13 @@ func (mvn *Mvn) UpdateDependency(path string, moduleVersion versionManager.Modul
 
 			for _, property := range mvnProject.Properties.PropertyList {
 				if property.XMLName.Local == moduleVersion.Name {
-					log.Logger.Infof("%s : %s : %s", folder, property.XMLName.Local, property.Value)
+					log.For(ctx).Infof("%s : %s : %s", folder, property.XMLName.Local, property.Value)
 					var propertyToReplace = strings.TrimRight(strings.TrimLeft(moduleVersion.Name, "${"), "}")
 
 					for _, folder2 := range folders {
 						xmlFile, err := os.Open(folder2 + "/pom.xml")
 						if err != nil {
-							log.Logger.Error("Error opening file:", err)
+							log.For(ctx).Error("Error opening file:", err)
 							return false, err
 						}
 						defer xmlFile.Close()
@@ -221,7 +245,7 @@ func (mvn *Mvn) UpdateDependency(path string, moduleVersion versionManager.Modul
 							propertyToReplace)
 						root, err := xmlpath.Parse(xmlFile)
 						if err != nil {
-							log.Logger.Fatal(err)
+							log.For(ctx).Fatal(err)
 						}
 						if _, ok := path.String(root); ok {
 							b, _ := ioutil.ReadFile(folder2 + "/pom.xml")
@@ -241,17 +265,17 @@ func (mvn *Mvn) UpdateDependency(path string, moduleVersion versionManager.Modul
 		}
 	} else {
 		var autoUpdateResult string
-		autoUpdateResult, err = osUtils.Execute(path, "mvn", "test-compile", "compile", "--batch-mode", "--update-snapshots", "org.codehaus.mojo:versions-maven-plugin:2.4:use-dep-version", "-Dincludes="+dependency, "-DdepVersion="+version)
+		autoUpdateResult, err = osUtils.Execute(ctx, path, "mvn", "test-compile", "compile", "--batch-mode", "--update-snapshots", "org.codehaus.mojo:versions-maven-plugin:2.4:use-dep-version", "-Dincludes="+dependency, "-DdepVersion="+version)
 
 		var regex = strings.Replace(regexp.QuoteMeta(fmt.Sprintf("Updated %s:jar:%s to version %s", dependency, moduleVersion.Current, version)), ":jar:", ":(test-)?jar:", 1)
-		log.Logger.Tracef("regex: %s", regex)
+		log.For(ctx).Tracef("regex: %s", regex)
 		if matched, _ := regexp.MatchString(regex, autoUpdateResult); matched {
 			hasUpdate = true
 		}
 	}
 
 	if hasUpdate == true {
-		log.Logger.Infof("Updated %s:%s:(test-)?jar:%s to version %s", moduleVersion.Module, dependency, moduleVersion.Current, version)
+		log.For(ctx).Infof("Updated %s:%s:(test-)?jar:%s to version %s", moduleVersion.Module, dependency, moduleVersion.Current, version)
 	}
 
 	return hasUpdate, err
@@ -3,6 +3,7 @@ package npm
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"io/ioutil"
 	"os"
@@ -12,6 +13,7 @@ import (
 
 	"github.com/blang/semver"
 	"github.com/coveooss/lure/lib/lure/log"
+	osutil "github.com/coveooss/lure/lib/lure/os"
 	"github.com/coveooss/lure/lib/lure/versionManager"
 )
 
@@ -20,19 +22,19 @@ type packageJSON map[string]interface{}
 type Npm struct {
 }
 
-func (npm *Npm) GetOutdated(path string) ([]versionManager.ModuleVersion, error) {
+func (npm *Npm) GetOutdated(ctx context.Context, path string) ([]versionManager.ModuleVersion, error) {
 	const packageJSONDefaultFileName = "package.json"
 	if _, err := os.Stat(path + packageJSONDefaultFileName); os.IsNotExist(err) {
-		log.Logger.Infof(packageJSONDefaultFileName + " doesn't exist, skipping npm update")
+		log.For(ctx).Infof(packageJSONDefaultFileName + " doesn't exist, skipping npm update")
 		return make([]versionManager.ModuleVersion, 0, 0), err
 	}
 
-	log.Logger.Infof("Running npm install")
+	log.For(ctx).Infof("Running npm install")
 	cmd := exec.Command("npm", "install")
 	cmd.Dir = path
-	err := cmd.Run()
+	err := osutil.Run(ctx, cmd)
 	if err != nil {
-		log.Logger.Errorf("Could not npm install: '%s'\n", err)
+		log.For(ctx).Errorf("Could not npm install: '%s'\n", err)
 		return make([]versionManager.ModuleVersion, 0, 0), err
 	}
 
@@ -42,7 +44,14 @@ func (npm *Npm) GetOutdated(path string) ([]versionManager.ModuleVersion, error)
 	cmd.Stdout = &out
 	cmd.Stderr = &errStrm
 	cmd.Dir = path
-	cmd.Run()
+	// npm outdated exits with 1 when modules are outdated
+	if err := osutil.Run(ctx, cmd); ctx.Err() != nil {
+		return make([]versionManager.ModuleVersion, 0, 0), err
+	}
+
+	packageJSONBuffer, _ := ioutil.ReadFile(path + packageJSONDefaultFileName)
+	var parsedPackageJSON packageJSON
+	json.Unmarshal(packageJSONBuffer, &parsedPackageJSON)
 
 	reader := bytes.NewReader(out.Bytes())
 	scanner := bufio.NewScanner(reader)
@@ -56,18 +65,19 @@ func (npm *Npm) GetOutdated(path string) ([]versionManager.ModuleVersion, error)
 		if lineIndex != 0 {
 			result := npmRegex.FindStringSubmatch(scanner.Text())
 			mv := versionManager.ModuleVersion{
-				Type:          "npm",
-				Module:        result[1],
-				Wanted:        result[3],
-				Current:       result[2],
-				Latest:        result[4],
-				ModuleUpdater: npm,
+				Type:           "npm",
+				Module:         result[1],
+				Wanted:         result[3],
+				Current:        result[2],
+				Latest:         result[4],
+				DependencyType: getDependencyType(&parsedPackageJSON, result[1]),
+				ModuleUpdater:  npm,
 			}
 			wantedVersion, _ := semver.Parse(mv.Wanted)
 			latestVersion, _ := semver.Parse(mv.Latest)
 
 			if wantedVersion.LT(latestVersion) {
-				log.Logger.Infof("Including NPM version %s", mv)
+				log.For(ctx).Infof("Including NPM version %s", mv)
 				version = append(version, mv)
 			}
 		}
@@ -77,7 +87,7 @@ func (npm *Npm) GetOutdated(path string) ([]versionManager.ModuleVersion, error)
 	return version, nil
 }
 
-func (npm *Npm) UpdateDependency(dir string, moduleToUpdate versionManager.ModuleVersion) (bool, error) {
+func (npm *Npm) UpdateDependency(ctx context.Context, dir string, moduleToUpdate versionManager.ModuleVersion) (bool, error) {
 	module := moduleToUpdate.Module
 	version := moduleToUpdate.Latest
 	packageJSONBuffer, _ := ioutil.ReadFile(path.Join(dir, "package.json"))
@@ -119,6 +129,17 @@ func updateJSON(parsedPackageJSON *packageJSON, key string, module string, versi
 	}
 }
 
+// getDependencyType returns the package.json section declaring the module (dependencies, devDependencies, ...)
+func getDependencyType(parsedPackageJSON *packageJSON, module string) string {
+	for _, key := range []string{"dependencies", "devDependencies", "optionalDependencies"} {
+		dependencies, ok := (*parsedPackageJSON)[key].(map[string]interface{})
+		if ok && dependencies[module] != nil {
+			return key
+		}
+	}
+	return ""
+}
+
 func getRangeOperator(version string) string {
 	// https://docs.npmjs.com/misc/semver#x-ranges-12x-1x-12-
 	r, _ := regexp.Compile("^(\\^|~).*")
@@ -1,14 +1,24 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path"
 	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/coveooss/lure/lib/lure/versionManager/mvn"
 	"github.com/coveooss/lure/lib/lure/versionManager/npm"
@@ -60,13 +70,15 @@ func main() {
 		log.Logger.Info("Running in DryRun mode, not doing the pull request nor pushing the changes")
 	}
 
+	ctx := interruptibleContext()
+
 	switch *mode {
 	case "oauth":
 		log.Logger.Info("Using OAuth Authentication")
-		mainWithOAuth(config)
+		mainWithOAuth(ctx, config)
 	case "env":
 		log.Logger.Info("Using Environment Authentication")
-		mainWithEnvironmentAuth(config)
+		mainWithEnvironmentAuth(ctx, config)
 	default:
 		log.Logger.Errorf("Invalid auth mode: %s", *mode)
 		flag.PrintDefaults()
@@ -74,74 +86,336 @@ func main() {
 	}
 }
 
-func runMain(config *project.LureConfig, auth vcs.Authentication) {
+// interruptibleContext returns a context cancelled on SIGINT or SIGTERM, which stops the commands and their child processes.
+// A second signal is not caught, it ends lure right away.
+func interruptibleContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		received := <-signals
+		signal.Stop(signals)
+		log.Logger.Warnf("Received %s, stopping", received)
+		cancel()
+	}()
+	return ctx
+}
+
+// withTimeout returns a context done after the timeout, a duration like "30m". An empty timeout sets no deadline.
+func withTimeout(ctx context.Context, timeout string) (context.Context, context.CancelFunc, error) {
+	if timeout == "" {
+		ctx, cancel := context.WithCancel(ctx)
+		return ctx, cancel, nil
+	}
+	duration, err := time.ParseDuration(timeout)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid timeout '%s': %w", timeout, err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	return ctx, cancel, nil
+}
+
+// runMain runs the projects, LURE_WORKERS of them at a time. The first failing command stops the other projects.
+func runMain(ctx context.Context, config *project.LureConfig, auth vcs.Authentication) {
+	workers := 1
+	if value := os.Getenv("LURE_WORKERS"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			log.Logger.Fatalf("\"Invalid LURE_WORKERS\" %q is not a positive number", value)
+		}
+		workers = parsed
+	}
+	if value := os.Getenv("LURE_API_RATE_LIMIT"); value != "" {
+		requestsPerSecond, err := strconv.ParseFloat(value, 64)
+		if err != nil || requestsPerSecond < 0 {
+			log.Logger.Fatalf("\"Invalid LURE_API_RATE_LIMIT\" %q is not a number of requests per second", value)
+		}
+		repository.SetRateLimit(requestsPerSecond)
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	projects := make(chan project.Project)
+	var failed int32
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for projectConfig := range projects {
+				if runCtx.Err() != nil {
+					continue
+				}
+				projectCtx := log.WithPrefix(runCtx, projectConfig.Owner+"/"+projectConfig.Name)
+				if err := runProject(projectCtx, projectConfig, auth); err != nil {
+					log.For(projectCtx).Error(fmt.Sprintf("Command failed: %s", err))
+					atomic.StoreInt32(&failed, 1)
+					cancel()
+				}
+			}
+		}()
+	}
+
+feed:
 	for _, projectConfig := range config.Projects {
-		log.Logger.Info(fmt.Sprintf("Project: %s/%s", projectConfig.Owner, projectConfig.Name))
+		select {
+		case projects <- projectConfig:
+		case <-runCtx.Done():
+			break feed
+		}
+	}
+	close(projects)
+	wg.Wait()
+
+	if atomic.LoadInt32(&failed) == 1 {
+		os.Exit(1)
+	}
+	if ctx.Err() != nil {
+		log.Logger.Error("Interrupted, skipping the remaining projects")
+		os.Exit(1)
+	}
+}
 
-		project.InitProjectDefaultValues(&projectConfig)
+// runProject clones the project and runs its commands. The projects that cannot be set up or cloned are skipped,
+// only the configuration errors and the failing commands are returned.
+func runProject(ctx context.Context, projectConfig project.Project, auth vcs.Authentication) error {
+	log.For(ctx).Info(fmt.Sprintf("Project: %s/%s", projectConfig.Owner, projectConfig.Name))
 
-		repoGUID, err := guid.V4()
+	project.InitProjectDefaultValues(&projectConfig)
 
+	projectAuth := auth
+	if ownerAuth, ok := auth.(vcs.OwnerAuthentication); ok {
+		transport, err := repository.NewTransport(projectConfig)
 		if err != nil {
-			log.Logger.Fatalf("\"Could not generate guid\" %s", err)
+			log.For(ctx).Errorf("\"Could not create the transport of the project\" %s", err)
+			return nil
 		}
-		localDestination := path.Join(os.TempDir(), repoGUID.String())
-
-		var provider command.Repository
-		switch projectConfig.Host {
-		case vcs.GitHub:
-			provider = repository.NewGitHub(auth, projectConfig)
-		case vcs.Bitbucket:
-			provider = repository.NewBitbucket(auth, projectConfig)
+		projectAuth = ownerAuth.ForOwner(projectConfig.Owner, transport)
+	}
+
+	var err error
+	var provider command.Repository
+	switch projectConfig.Host {
+	case vcs.GitHub:
+		provider, err = repository.NewGitHub(projectAuth, projectConfig)
+	case vcs.Bitbucket:
+		provider, err = repository.NewBitbucket(projectAuth, projectConfig)
+	case vcs.GitLab:
+		provider, err = repository.NewGitLab(projectAuth, projectConfig)
+	case vcs.BitbucketServer:
+		provider, err = repository.NewBitbucketServer(projectAuth, projectConfig)
+	case vcs.Gitea:
+		provider, err = repository.NewGitea(projectAuth, projectConfig)
+	case vcs.AzureDevOps:
+		provider, err = repository.NewAzureDevOps(projectAuth, projectConfig)
+	default:
+		return fmt.Errorf("Unknown Host '%s' - must be one of %s, %s, %s, %s, %s, %s", projectConfig.Host, vcs.GitHub, vcs.Bitbucket, vcs.GitLab, vcs.BitbucketServer, vcs.Gitea, vcs.AzureDevOps)
+	}
+	if err != nil {
+		log.For(ctx).Errorf("\"Could not create the %s provider\" %s", projectConfig.Host, err)
+		return nil
+	}
+
+	// the API keeps the project authentication, only the clones go over SSH or through the credential helper
+	cloneAuth, cloneURL, err := cloneAuthentication(projectConfig, projectAuth, provider.GetURL())
+	if err != nil {
+		return err
+	}
+
+	cacheDir := os.Getenv("LURE_CACHE_DIR")
+	localDestination, err := workspacePath(cacheDir, cloneURL)
+	if err != nil {
+		return fmt.Errorf("\"Could not create workspace\" %w", err)
+	}
+	// the projects sharing a cached clone take turns
+	defer lockWorkspace(localDestination)()
+	cloneOptions := vcs.CloneOptions{
+		Depth:          projectConfig.Clone.Depth,
+		Filter:         projectConfig.Clone.Filter,
+		Sparse:         projectConfig.Clone.SparseCheckout,
+		Cached:         cacheDir != "",
+		Proxy:          projectConfig.Proxy,
+		CACertificates: projectConfig.CACertificates,
+	}
+	commitOptions := vcs.CommitOptions{
+		AuthorName:    projectConfig.Author.Name,
+		AuthorEmail:   projectConfig.Author.Email,
+		SigningFormat: projectConfig.Signing.Format,
+		SigningKey:    projectConfig.Signing.Key,
+		HgExtension:   projectConfig.Signing.HgExtension,
+	}
+
+	var sourceControl vcs.SourceControl
+	switch projectConfig.Vcs {
+	case vcs.Hg:
+		sourceControl, err = vcs.NewHg(cloneAuth, cloneURL, localDestination, projectConfig.GetDefaultBranch(), projectConfig.GetTrashBranch(), projectConfig.HgBranchMode, projectConfig.GetBasePath(), cloneOptions, commitOptions)
+	case vcs.Git:
+		switch projectConfig.GitBackend {
+		case vcs.GoGit:
+			sourceControl, err = vcs.NewGoGit(cloneAuth, cloneURL, localDestination, projectConfig.GetBasePath(), cloneOptions, commitOptions)
+		case vcs.GitCLI, "":
+			sourceControl, err = vcs.NewGit(cloneAuth, cloneURL, localDestination, projectConfig.GetBasePath(), cloneOptions, commitOptions)
 		default:
-			// host = nil
-			err = fmt.Errorf("Unknown Host '%s' - must be one of %s, %s", projectConfig.Host, vcs.GitHub, vcs.Bitbucket)
-			os.Exit(1)
+			return fmt.Errorf("Unknown git backend '%s' - must be one of %s, %s", projectConfig.GitBackend, vcs.GitCLI, vcs.GoGit)
 		}
+	default:
+		return fmt.Errorf("Unknown VCS '%s' - must be one of %s, %s", projectConfig.Vcs, vcs.Git, vcs.Hg)
+	}
+	if err != nil {
+		log.For(ctx).Errorf("\"Could not set up the %s repository\" %s", projectConfig.Vcs, err)
+		return nil
+	}
 
+	// the project timeout covers the clone and all the commands
+	timeoutCtx, cancel, err := withTimeout(ctx, projectConfig.Timeout)
+	if err != nil {
+		log.For(ctx).Errorf("\"Could not set the timeout of the project\" %s", err)
+		return nil
+	}
+	defer cancel()
+	ctx = timeoutCtx
+
+	if err := sourceControl.Clone(ctx); err != nil {
+		log.For(ctx).Errorf("Could not clone %s: %s", cloneURL, err)
+		// a cache that cannot be refreshed is cloned again on the next run
+		removeWorkspace(ctx, localDestination)
+		return nil
+	}
+	if cacheDir == "" {
+		defer removeWorkspace(ctx, localDestination)
+	}
 
-		var sourceControl vcs.SourceControl
-		switch projectConfig.Vcs {
-		case vcs.Hg:
-			sourceControl, err = vcs.NewHg(auth, provider.GetURL(), localDestination, projectConfig.GetDefaultBranch(), projectConfig.GetTrashBranch(), projectConfig.GetBasePath())
-		case vcs.Git:
-			sourceControl, err = vcs.NewGit(auth, provider.GetURL(), localDestination, projectConfig.GetBasePath())
-		default:
-			//repo = nil
-			err = fmt.Errorf("Unknown VCS '%s' - must be one of %s, %s", projectConfig.Vcs, vcs.Git, vcs.Hg)
-			os.Exit(1)
+	npm := npm.Npm{}
+	mvn := mvn.Mvn{}
+
+	for _, cmd := range projectConfig.Commands {
+		log.For(ctx).Info(fmt.Sprintf("Command: %s", cmd.Name))
+		if err := runCommand(ctx, cmd, projectConfig, sourceControl, provider, &mvn, &npm); err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		sourceControl.Clone()
-
-		npm := npm.Npm{}
-		mvn := mvn.Mvn{}
-
-		for _, cmd := range projectConfig.Commands {
-			log.Logger.Info(fmt.Sprintf("Command: %s", cmd.Name))
-			var err error
-			switch cmd.Name {
-			case "updateDependencies":
-				err = command.CheckForUpdatesJobCommand(projectConfig, sourceControl, provider, cmd.Args, &mvn, &npm)
-			case "synchronizedBranches":
-				err = command.SynchronizedBranchesCommand(projectConfig, sourceControl, provider, cmd.Args)
-			default:
-				log.Logger.Info(fmt.Sprintf("\tSkipping invalid command: %s", cmd.Name))
-			}
+// runCommand runs a command of the project, within the timeout of the command
+func runCommand(ctx context.Context, cmd project.Command, projectConfig project.Project, sourceControl vcs.SourceControl, provider command.Repository, mvn *mvn.Mvn, npm *npm.Npm) error {
+	ctx, cancel, err := withTimeout(ctx, cmd.Timeout)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	switch cmd.Name {
+	case "updateDependencies":
+		return command.CheckForUpdatesJobCommand(ctx, projectConfig, sourceControl, provider, cmd.Args, mvn, npm)
+	case "synchronizedBranches":
+		return command.SynchronizedBranchesCommand(ctx, projectConfig, sourceControl, provider, cmd.Args)
+	case "mergeReady":
+		return command.MergeReadyCommand(ctx, projectConfig, sourceControl, provider, cmd.Args)
+	case "dashboard":
+		return command.DashboardCommand(ctx, projectConfig, sourceControl, provider, cmd.Args, mvn, npm)
+	case "backport":
+		return command.BackportCommand(ctx, projectConfig, sourceControl, provider, cmd.Args)
+	case "cleanupBranches":
+		return command.CleanupBranchesCommand(ctx, projectConfig, sourceControl, provider, cmd.Args)
+	default:
+		log.For(ctx).Info(fmt.Sprintf("\tSkipping invalid command: %s", cmd.Name))
+		return nil
+	}
+}
 
-			if err != nil {
-				log.Logger.Error(fmt.Sprintf("Command failed: %s", err))
-				os.Exit(1)
-			}
+// cloneAuthentication returns the authentication and URL of the clone. Over SSH, the key is read from the sshKeyPath
+// of the project or SSH_KEY_PATH, or else the SSH agent is used. The host keys are checked against the knownHosts of
+// the project or SSH_KNOWN_HOSTS, or else the default known_hosts files.
+func cloneAuthentication(projectConfig project.Project, projectAuth vcs.Authentication, url string) (vcs.Authentication, string, error) {
+	switch projectConfig.CloneProtocol {
+	case vcs.SSH:
+		if projectConfig.SSHURL != "" {
+			url = projectConfig.SSHURL
+		}
+		auth := vcs.SSHAuth{
+			User:           os.Getenv("SSH_USER"),
+			Port:           os.Getenv("SSH_PORT"),
+			KeyPath:        projectConfig.SSHKeyPath,
+			KnownHostsPath: projectConfig.KnownHosts,
+		}
+		if auth.KeyPath == "" {
+			auth.KeyPath = os.Getenv("SSH_KEY_PATH")
+		}
+		if auth.KnownHostsPath == "" {
+			auth.KnownHostsPath = os.Getenv("SSH_KNOWN_HOSTS")
+		}
+		return auth, url, nil
+	case vcs.HTTPS, "":
+		if projectConfig.CredentialHelper {
+			return vcs.CredentialHelperAuth{Authentication: projectAuth}, url, nil
+		}
+		return projectAuth, url, nil
+	default:
+		return nil, "", fmt.Errorf("Unknown clone protocol '%s' - must be one of %s, %s", projectConfig.CloneProtocol, vcs.HTTPS, vcs.SSH)
+	}
+}
+
+// workspacePath returns where to clone the remote: a directory of the cache keyed by remote, kept between runs,
+// or a new temporary directory without cache
+func workspacePath(cacheDir string, remote string) (string, error) {
+	if cacheDir == "" {
+		repoGUID, err := guid.V4()
+		if err != nil {
+			return "", err
 		}
+		return path.Join(os.TempDir(), repoGUID.String()), nil
+	}
+
+	key := sha256.Sum256([]byte(remote))
+	return path.Join(cacheDir, hex.EncodeToString(key[:])[:16]), os.MkdirAll(cacheDir, 0755)
+}
+
+// workspaceLocks are held by the projects running in a workspace
+var workspaceLocks = struct {
+	sync.Mutex
+	locks map[string]*sync.Mutex
+}{locks: map[string]*sync.Mutex{}}
+
+// lockWorkspace waits for the other projects running in the workspace and returns the function releasing it
+func lockWorkspace(localDestination string) func() {
+	workspaceLocks.Lock()
+	lock, ok := workspaceLocks.locks[localDestination]
+	if !ok {
+		lock = &sync.Mutex{}
+		workspaceLocks.locks[localDestination] = lock
+	}
+	workspaceLocks.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+func removeWorkspace(ctx context.Context, localDestination string) {
+	log.For(ctx).Infof("Removing workspace %s", localDestination)
+	if err := os.RemoveAll(localDestination); err != nil {
+		log.For(ctx).Warnf("Could not remove workspace %s: %s", localDestination, err)
 	}
 }
 
-func mainWithEnvironmentAuth(config *project.LureConfig) {
+func mainWithEnvironmentAuth(ctx context.Context, config *project.LureConfig) {
 	var auth vcs.Authentication
 	accessToken := os.Getenv("GITHUB_ACCESS_TOKEN")
 	if accessToken != "" {
 		auth = vcs.TokenAuth{User: "x-access-token", Token: accessToken}
+	} else if appID := os.Getenv("GITHUB_APP_ID"); appID != "" {
+		auth = newGitHubAppAuth(appID)
+	} else if gitLabToken := os.Getenv("GITLAB_ACCESS_TOKEN"); gitLabToken != "" {
+		auth = vcs.TokenAuth{User: "oauth2", Token: gitLabToken}
+	} else if os.Getenv("GITLAB_USE_JOB_TOKEN") == "1" {
+		auth = vcs.JobTokenAuth{Token: os.Getenv("CI_JOB_TOKEN")}
+	} else if bitbucketServerToken := os.Getenv("BITBUCKET_SERVER_ACCESS_TOKEN"); bitbucketServerToken != "" {
+		auth = vcs.TokenAuth{User: "x-token-auth", Token: bitbucketServerToken}
+	} else if giteaToken := os.Getenv("GITEA_ACCESS_TOKEN"); giteaToken != "" {
+		auth = vcs.TokenAuth{User: "x-access-token", Token: giteaToken}
+	} else if azureDevOpsToken := os.Getenv("AZURE_DEVOPS_ACCESS_TOKEN"); azureDevOpsToken != "" {
+		auth = vcs.PersonalAccessTokenAuth{Token: azureDevOpsToken}
 	} else {
 		username := os.Getenv("GITHUB_USERNAME")
 		password := os.Getenv("GITHUB_PASSWORD")
@@ -157,10 +431,31 @@ func mainWithEnvironmentAuth(config *project.LureConfig) {
 		}
 	}
 
-	runMain(config, auth)
+	runMain(ctx, config, auth)
 }
 
-func mainWithOAuth(config *project.LureConfig) {
+// newGitHubAppAuth reads the app private key from GITHUB_APP_PRIVATE_KEY or from the GITHUB_APP_PRIVATE_KEY_PATH file
+func newGitHubAppAuth(appID string) vcs.Authentication {
+	id, err := strconv.ParseInt(appID, 10, 64)
+	if err != nil {
+		log.Logger.Fatalf("\"Invalid GITHUB_APP_ID\" %s", err)
+	}
+
+	privateKey := []byte(os.Getenv("GITHUB_APP_PRIVATE_KEY"))
+	if privateKeyPath := os.Getenv("GITHUB_APP_PRIVATE_KEY_PATH"); privateKeyPath != "" {
+		if privateKey, err = ioutil.ReadFile(privateKeyPath); err != nil {
+			log.Logger.Fatalf("\"Could not read the GitHub App private key\" %s", err)
+		}
+	}
+
+	auth, err := vcs.NewGitHubAppAuth(id, privateKey, os.Getenv("GITHUB_APP_API_URL"))
+	if err != nil {
+		log.Logger.Fatalf("\"Could not parse the GitHub App private key\" %s", err)
+	}
+	return auth
+}
+
+func mainWithOAuth(ctx context.Context, config *project.LureConfig) {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, bitBucketOAuthConfig.AuthCodeURL(""), http.StatusFound)
@@ -202,7 +497,7 @@ func mainWithOAuth(config *project.LureConfig) {
 		go (func() {
 			auth := vcs.TokenAuth{User:"x-token-auth", Token: token.AccessToken}
 
-			runMain(config, auth)
+			runMain(ctx, config, auth)
 			os.Exit(0)
 		})()
 	})
@@ -242,6 +537,59 @@ func open(url string) error {
 	return exec.Command(cmd, args...).Start()
 }
 
+// goGitUnsupportedCommands need merges of diverged histories or cherry-picks, which go-git cannot do
+var goGitUnsupportedCommands = []string{"synchronizedBranches", "backport"}
+
+// validateGitBackend rejects the projects running commands their git backend cannot run
+func validateGitBackend(projectConfig project.Project) error {
+	if projectConfig.Vcs != vcs.Git || projectConfig.GitBackend != vcs.GoGit {
+		return nil
+	}
+	for _, cmd := range projectConfig.Commands {
+		for _, unsupported := range goGitUnsupportedCommands {
+			if cmd.Name == unsupported {
+				return fmt.Errorf("Project %s/%s: the %s git backend cannot run %s, it can neither merge diverged branches nor cherry-pick - use the %s backend", projectConfig.Owner, projectConfig.Name, vcs.GoGit, cmd.Name, vcs.GitCLI)
+			}
+		}
+	}
+	return nil
+}
+
+// hostUnsupportedCommands need an issue tracker, which these hosts lack
+var hostUnsupportedCommands = map[string][]string{
+	vcs.BitbucketServer: {"dashboard"},
+	vcs.AzureDevOps:     {"dashboard"},
+}
+
+// validateHost rejects the projects running commands their host cannot run
+func validateHost(projectConfig project.Project) error {
+	for _, cmd := range projectConfig.Commands {
+		for _, unsupported := range hostUnsupportedCommands[projectConfig.Host] {
+			if cmd.Name == unsupported {
+				return fmt.Errorf("Project %s/%s: %s has no issue tracker, it cannot run %s", projectConfig.Owner, projectConfig.Name, projectConfig.Host, cmd.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// validateTimeouts rejects the projects whose timeout or command timeouts are not durations
+func validateTimeouts(projectConfig project.Project) error {
+	timeouts := []string{projectConfig.Timeout}
+	for _, cmd := range projectConfig.Commands {
+		timeouts = append(timeouts, cmd.Timeout)
+	}
+	for _, timeout := range timeouts {
+		if timeout == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(timeout); err != nil {
+			return fmt.Errorf("Project %s/%s: invalid timeout '%s': %w", projectConfig.Owner, projectConfig.Name, timeout, err)
+		}
+	}
+	return nil
+}
+
 func loadConfig(filePath string) (*project.LureConfig, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -257,6 +605,15 @@ func loadConfig(filePath string) (*project.LureConfig, error) {
 		if lureProject.Host == "" {
 			lureConfig.Projects[i].Host = vcs.Bitbucket
 		}
+		if err := validateGitBackend(lureProject); err != nil {
+			return nil, err
+		}
+		if err := validateHost(lureConfig.Projects[i]); err != nil {
+			return nil, err
+		}
+		if err := validateTimeouts(lureProject); err != nil {
+			return nil, err
+		}
 	}
 	configJson, _ := json.Marshal(lureConfig)
 	log.Logger.Trace("Config:", string(configJson))